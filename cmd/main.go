@@ -3,25 +3,50 @@ package main
 import (
 	"context"
 	"log"
+	"os"
 
 	"github.com/gin-gonic/gin"
 
 	"cloud.google.com/go/logging"
 	"github.com/digizyne/lfcont/internal/api"
+	"github.com/digizyne/lfcont/internal/credentials"
 	"github.com/digizyne/lfcont/internal/data"
 	"github.com/digizyne/lfcont/internal/middleware"
+	"github.com/digizyne/lfcont/internal/vuln"
 	"github.com/gin-contrib/cors"
 )
 
 func main() {
+	ctx := context.Background()
+	creds, err := credentials.NewProvider(ctx, credentials.ConfigFromEnv())
+	if err != nil {
+		log.Fatalf("Invalid credentials configuration: %v", err)
+	}
+	log.Printf("Using %s registry credentials", creds.Source())
+
 	pool, err := data.InitializeDatabase()
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer pool.Close()
 
-	ctx := context.Background()
-	client, err := logging.NewClient(ctx, "local-first-476300")
+	// Load the offline advisory database in the background and rescan every
+	// stored SBOM against it
+	if osvDumpPath := os.Getenv("OSV_DUMP_PATH"); osvDumpPath != "" {
+		go func() {
+			imported, err := vuln.Import(ctx, pool, osvDumpPath)
+			if err != nil {
+				log.Printf("Failed to import advisories from %s: %v", osvDumpPath, err)
+				return
+			}
+			log.Printf("Imported %d advisories from %s", imported, osvDumpPath)
+			if err := vuln.RescanAll(ctx, pool); err != nil {
+				log.Printf("Failed to rescan images for vulnerabilities: %v", err)
+			}
+		}()
+	}
+
+	client, err := logging.NewClient(ctx, "local-first-476300", creds.ClientOptions()...)
 	if err != nil {
 		log.Fatalf("Could not initialize GCP logging: %v", err)
 	}
@@ -30,7 +55,7 @@ func main() {
 
 	corsConfig := cors.Config{
 		AllowOrigins:  []string{"*"},
-		AllowMethods:  []string{"GET", "POST", "OPTIONS"},
+		AllowMethods:  []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowHeaders:  []string{"Origin", "Content-Type", "Authorization"},
 		ExposeHeaders: []string{"Content-Length"},
 	}
@@ -38,6 +63,6 @@ func main() {
 	router := gin.Default()
 	router.Use(cors.New(corsConfig))
 	router.Use(middleware.GcpLogger(gcpLogger))
-	api.InitializeApp(router, pool)
+	api.InitializeApp(router, pool, creds)
 	router.Run("0.0.0.0:8080")
 }
@@ -0,0 +1,234 @@
+package admission
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/digizyne/lfcont/internal/vuln"
+)
+
+const (
+	RuleTrustedRegistry = "trusted-registry"
+	RuleOrgOwned        = "org-owned"
+	RulePinnedDigest    = "pinned-digest"
+	RuleSigned          = "signed"
+	RuleVulnerabilities = "vulnerabilities"
+)
+
+const (
+	StatusPass     = "pass"
+	StatusFail     = "fail"
+	StatusDisabled = "disabled"
+)
+
+// Rules selects which admission rules are enforced for a deployment.
+type Rules struct {
+	// TrustedRegistry requires the image to live in the controller's registry.
+	TrustedRegistry bool `json:"trusted_registry"`
+	// OrgOwned requires the image to have been pushed by the caller or a
+	// member of the caller's organization.
+	OrgOwned bool `json:"org_owned"`
+	// PinnedDigest requires an image reference of the form repo@sha256:...
+	PinnedDigest bool `json:"pinned_digest"`
+	// Signed requires a signature from one of the organization's keys.
+	Signed bool `json:"signed"`
+	// BlockSeverity rejects images with known vulnerabilities of this
+	// severity or higher. Empty disables the rule.
+	BlockSeverity string `json:"block_severity,omitempty"`
+}
+
+// Config is an organization's admission policy: default rules plus full
+// replacements for individual tiers.
+type Config struct {
+	Default Rules            `json:"default"`
+	Tiers   map[string]Rules `json:"tiers,omitempty"`
+}
+
+// For returns the rules that apply to a deployment of tier.
+func (c Config) For(tier string) Rules {
+	if rules, ok := c.Tiers[tier]; ok {
+		return rules
+	}
+	return c.Default
+}
+
+// WithFloor applies the operator's rules as a minimum to every rule set of
+// the config: rules enabled in floor stay enabled and the stricter block
+// severity wins.
+func (c Config) WithFloor(floor Rules) Config {
+	merged := Config{Default: c.Default.withFloor(floor)}
+	if len(c.Tiers) > 0 {
+		merged.Tiers = make(map[string]Rules, len(c.Tiers))
+		for tier, rules := range c.Tiers {
+			merged.Tiers[tier] = rules.withFloor(floor)
+		}
+	}
+	return merged
+}
+
+func (r Rules) withFloor(floor Rules) Rules {
+	r.TrustedRegistry = r.TrustedRegistry || floor.TrustedRegistry
+	r.OrgOwned = r.OrgOwned || floor.OrgOwned
+	r.PinnedDigest = r.PinnedDigest || floor.PinnedDigest
+	r.Signed = r.Signed || floor.Signed
+	r.BlockSeverity = stricterSeverity(r.BlockSeverity, floor.BlockSeverity)
+	return r
+}
+
+// stricterSeverity returns the block severity that rejects more images. An
+// empty severity blocks nothing; an unparsable floor is kept so that
+// Evaluate reports it.
+func stricterSeverity(severity, floor string) string {
+	if floor == "" {
+		return severity
+	}
+	if severity == "" {
+		return floor
+	}
+	floorSeverity, err := vuln.ParseSeverity(floor)
+	if err != nil {
+		return floor
+	}
+	parsed, err := vuln.ParseSeverity(severity)
+	if err != nil || parsed.Rank() > floorSeverity.Rank() {
+		return floor
+	}
+	return severity
+}
+
+// Validate checks that every severity in the config is known.
+func (c Config) Validate() error {
+	if err := c.Default.validate(); err != nil {
+		return fmt.Errorf("default: %v", err)
+	}
+	for tier, rules := range c.Tiers {
+		if err := rules.validate(); err != nil {
+			return fmt.Errorf("tier %s: %v", tier, err)
+		}
+	}
+	return nil
+}
+
+func (r Rules) validate() error {
+	if r.BlockSeverity == "" {
+		return nil
+	}
+	if _, err := vuln.ParseSeverity(r.BlockSeverity); err != nil {
+		return fmt.Errorf("block_severity: %v", err)
+	}
+	return nil
+}
+
+// DefaultConfig is used for callers whose organization has no admission
+// policy. It keeps the controller-wide DEPLOY_BLOCK_SEVERITY setting, which
+// is also the floor of organization policies.
+func DefaultConfig() Config {
+	return Config{
+		Default: Rules{
+			BlockSeverity: os.Getenv("DEPLOY_BLOCK_SEVERITY"),
+		},
+	}
+}
+
+// Facts is what is known about the image being deployed. Facts for disabled
+// rules may be left empty. Vulnerabilities is nil when the image has no SBOM,
+// which fails the vulnerability rule rather than passing it.
+type Facts struct {
+	Image           string       `json:"image"`
+	InRegistry      bool         `json:"in_registry"`
+	Recorded        bool         `json:"recorded"`
+	Owner           string       `json:"owner,omitempty"`
+	OrgOwned        bool         `json:"org_owned"`
+	Pinned          bool         `json:"pinned"`
+	SignedBy        string       `json:"signed_by,omitempty"`
+	Vulnerabilities *vuln.Counts `json:"vulnerabilities,omitempty"`
+}
+
+type Result struct {
+	Rule    string `json:"rule"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+type Decision struct {
+	Allowed bool     `json:"allowed"`
+	Tier    string   `json:"tier"`
+	Rules   Rules    `json:"rules"`
+	Results []Result `json:"results"`
+}
+
+// Failed returns the results of the rules that rejected the image.
+func (d Decision) Failed() []Result {
+	var failed []Result
+	for _, result := range d.Results {
+		if result.Status == StatusFail {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Evaluate applies rules to facts. Every rule is reported, including disabled
+// ones, so callers can see the whole policy.
+func Evaluate(tier string, rules Rules, facts Facts) Decision {
+	decision := Decision{Allowed: true, Tier: tier, Rules: rules}
+	add := func(rule string, enabled, passed bool, message string) {
+		status := StatusDisabled
+		if enabled {
+			status = StatusFail
+			if passed {
+				status = StatusPass
+			}
+		}
+		if status == StatusFail {
+			decision.Allowed = false
+		}
+		decision.Results = append(decision.Results, Result{Rule: rule, Status: status, Message: message})
+	}
+
+	if facts.InRegistry {
+		add(RuleTrustedRegistry, rules.TrustedRegistry, true, "image is in the controller's registry")
+	} else {
+		add(RuleTrustedRegistry, rules.TrustedRegistry, false, "image is not in the controller's registry")
+	}
+
+	switch {
+	case facts.OrgOwned:
+		add(RuleOrgOwned, rules.OrgOwned, true, fmt.Sprintf("image was pushed by %s", facts.Owner))
+	case facts.Recorded:
+		add(RuleOrgOwned, rules.OrgOwned, false, "image was pushed by a user outside your organization")
+	default:
+		add(RuleOrgOwned, rules.OrgOwned, false, "image was not pushed through the controller")
+	}
+
+	if facts.Pinned {
+		add(RulePinnedDigest, rules.PinnedDigest, true, "image is pinned by digest")
+	} else {
+		add(RulePinnedDigest, rules.PinnedDigest, false, "image is referenced by tag, not by digest")
+	}
+
+	switch {
+	case !rules.Signed:
+		add(RuleSigned, false, false, "signature not checked")
+	case facts.SignedBy != "":
+		add(RuleSigned, true, true, fmt.Sprintf("image is signed with key %s", facts.SignedBy))
+	default:
+		add(RuleSigned, true, false, "image is not signed by a trusted key")
+	}
+
+	threshold, err := vuln.ParseSeverity(rules.BlockSeverity)
+	switch {
+	case rules.BlockSeverity == "":
+		add(RuleVulnerabilities, false, false, "vulnerabilities not checked")
+	case err != nil:
+		add(RuleVulnerabilities, true, false, err.Error())
+	case facts.Vulnerabilities == nil:
+		add(RuleVulnerabilities, true, false, "image has no SBOM, so its vulnerabilities cannot be checked")
+	case facts.Vulnerabilities.AtLeast(threshold) > 0:
+		add(RuleVulnerabilities, true, false, fmt.Sprintf("image has %d vulnerabilities of severity %s or higher", facts.Vulnerabilities.AtLeast(threshold), threshold))
+	default:
+		add(RuleVulnerabilities, true, true, fmt.Sprintf("no vulnerabilities of severity %s or higher", threshold))
+	}
+
+	return decision
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/digizyne/lfcont/internal/admission"
+	"github.com/digizyne/lfcont/internal/vuln"
+	"github.com/digizyne/lfcont/tools"
+)
+
+type AdmissionCheckRequest struct {
+	ContainerImage string `json:"container_image" binding:"required"`
+	Tier           string `json:"tier"`
+}
+
+// checkAdmission is a dry run of the admission policy: it reports every rule
+// without deploying anything.
+func (app *App) checkAdmission(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	var req AdmissionCheckRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{
+			"error":   "invalid request payload",
+			"message": err.Error(),
+		})
+		return
+	}
+	if _, err := name.ParseReference(req.ContainerImage); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("invalid image reference: %v", err),
+		})
+		return
+	}
+
+	// Evaluate the digest a deployment would pin the image to
+	ctx := c.Request.Context()
+	image, err := app.pinImage(ctx, req.ContainerImage)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{
+			"error": fmt.Sprintf("Failed to resolve container image: %v", err),
+		})
+		return
+	}
+
+	decision, err := app.admissionDecision(ctx, userClaims.Username, req.ContainerImage, image, req.Tier)
+	if err != nil {
+		log.Printf("Admission policy error: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to evaluate admission policy: %v", err),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, decision)
+}
+
+// admissionDecision evaluates the caller's admission policy for tier against
+// image, the digest requested resolved to. Every fact is gathered for that
+// digest, so a tag moved during the rollout cannot change what was checked;
+// only the pinned-digest rule looks at the reference as requested.
+func (app *App) admissionDecision(ctx context.Context, username, requested string, image name.Digest, tier string) (admission.Decision, error) {
+	ref, err := name.ParseReference(requested)
+	if err != nil {
+		return admission.Decision{}, err
+	}
+
+	policy, err := app.organizationPolicy(ctx, username)
+	if err != nil {
+		return admission.Decision{}, err
+	}
+	// Organizations can tighten the operator's rules but not relax them
+	config := admission.DefaultConfig()
+	if policy.Admission != nil {
+		config = policy.Admission.WithFloor(config.Default)
+	}
+	rules := config.For(tier)
+
+	organization, err := app.userOrganization(ctx, username)
+	if err != nil {
+		return admission.Decision{}, err
+	}
+
+	facts := admission.Facts{Image: requested, InRegistry: inControllerRepo(image)}
+	_, facts.Pinned = ref.(name.Digest)
+
+	// Find who pushed the image: a push of the digest within the same
+	// repository, or the requested tag if it was recorded without a digest
+	var owner string
+	var ownerOrganization *string
+	err = app.Pool.QueryRow(ctx, `
+		SELECT ci.username, u.organization
+		FROM container_images ci
+		JOIN users u ON u.username = ci.username
+		WHERE starts_with(ci.fqin, $2 || ':') AND (ci.digest = $1 OR (ci.digest IS NULL AND ci.fqin = $3))
+		ORDER BY ci.digest IS NULL
+		LIMIT 1
+	`, image.DigestStr(), image.Context().Name(), requested).Scan(&owner, &ownerOrganization)
+	switch {
+	case err == pgx.ErrNoRows:
+	case err != nil:
+		return admission.Decision{}, err
+	default:
+		facts.Recorded = true
+		facts.Owner = owner
+		facts.OrgOwned = owner == username ||
+			(organization != "" && ownerOrganization != nil && *ownerOrganization == organization)
+	}
+
+	if rules.BlockSeverity != "" {
+		facts.Vulnerabilities, err = app.digestVulnerabilities(ctx, image.DigestStr())
+		if err != nil {
+			return admission.Decision{}, err
+		}
+	}
+
+	if rules.Signed {
+		// An image whose signatures cannot be read counts as unsigned.
+		facts.SignedBy, err = app.imageSignedBy(ctx, organization, image)
+		if err != nil {
+			log.Printf("Warning: failed to verify signatures of %s: %v", image, err)
+		}
+	}
+
+	return admission.Evaluate(tier, rules, facts), nil
+}
+
+// digestVulnerabilities returns the finding counts for an image digest, or
+// nil when no SBOM was generated for it. Callers must not read nil as clean.
+func (app *App) digestVulnerabilities(ctx context.Context, digest string) (*vuln.Counts, error) {
+	var exists bool
+	if err := app.Pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM sboms WHERE digest = $1)`, digest).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+	counts, err := vuln.CountsByDigest(ctx, app.Pool, []string{digest})
+	if err != nil {
+		return nil, err
+	}
+	imageCounts := counts[digest]
+	return &imageCounts, nil
+}
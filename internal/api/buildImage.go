@@ -0,0 +1,219 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+
+	"github.com/digizyne/lfcont/internal/build"
+	"github.com/digizyne/lfcont/internal/jobs"
+	"github.com/digizyne/lfcont/internal/rebase"
+	"github.com/digizyne/lfcont/internal/registry"
+	"github.com/digizyne/lfcont/tools"
+)
+
+func (app *App) listBuildBases(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"bases": build.SortedBases(),
+	})
+}
+
+// buildContainerImage layers an uploaded artifact archive onto one of the
+// supported base images and publishes the result like a pushed image. It takes
+// a multipart form with the fields:
+//
+//	artifact    tar or tar.gz archive (required)
+//	base        name of a base image, see GET /container-images/bases (required)
+//	name        image name (required)
+//	entrypoint  JSON array or a single path; relative paths are resolved
+//	            against the artifact directory
+//	env         KEY=VALUE, may be repeated
+//	port        port the program listens on (default 8080)
+func (app *App) buildContainerImage(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	// Parse the form up front so an oversized upload is reported as such
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadSize())
+	if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		c.AbortWithStatusJSON(status, gin.H{
+			"error": fmt.Sprintf("Failed to read upload: %v", err),
+		})
+		return
+	}
+
+	base, ok := build.Bases[c.PostForm("base")]
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("unknown base image %q", c.PostForm("base")),
+		})
+		return
+	}
+
+	imageName := c.PostForm("name")
+	if imageName == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	dst, err := name.ParseReference(newTargetTag(imageName))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("invalid image name: %v", err),
+		})
+		return
+	}
+
+	entrypoint, err := parseEntrypoint(c.PostForm("entrypoint"), base.Dir)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if base.NeedsEntrypoint && len(entrypoint) == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("base image %s requires an entrypoint", base.Name),
+		})
+		return
+	}
+
+	env := c.PostFormArray("env")
+	for _, kv := range env {
+		if key, _, ok := strings.Cut(kv, "="); !ok || key == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("invalid env entry %q, expected KEY=VALUE", kv),
+			})
+			return
+		}
+	}
+
+	port := c.DefaultPostForm("port", "8080")
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("invalid port %q", port),
+		})
+		return
+	}
+
+	artifact, err := c.FormFile("artifact")
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "artifact file is required",
+		})
+		return
+	}
+	if abortOnQuota(c, app.checkStorageQuota(c.Request.Context(), userClaims.Username, artifact.Size)) {
+		return
+	}
+
+	// Spool the artifact so the worker can read it after this request returns
+	spool, err := os.CreateTemp("", "artifact-upload-*")
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to store upload: %v", err),
+		})
+		return
+	}
+	spool.Close()
+	if err := c.SaveUploadedFile(artifact, spool.Name()); err != nil {
+		os.Remove(spool.Name())
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Failed to read upload: %v", err),
+		})
+		return
+	}
+
+	username := userClaims.Username
+	options := build.Options{Entrypoint: entrypoint, Env: env, Port: port}
+	job, err := app.Jobs.Submit("build", username, func(ctx context.Context, r *jobs.Reporter) (any, error) {
+		defer os.Remove(spool.Name())
+
+		r.Stage("resolving base image")
+		opts := app.Credentials.RemoteOptions(ctx)
+		baseVersion, err := rebase.Latest(ctx, base.Image, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("build failed: %v", err)
+		}
+		baseImage, err := baseVersion.Image(ctx, registry.DefaultPlatform, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("build failed: %v", err)
+		}
+
+		r.Stage("building image")
+		layer, layerPath, err := build.ArtifactLayer(spool.Name(), base.Dir, base.UID, base.GID)
+		if err != nil {
+			return nil, fmt.Errorf("build failed: %v", err)
+		}
+		defer os.Remove(layerPath)
+		img, err := build.Image(baseImage, layer, base.Dir, options)
+		if err != nil {
+			return nil, fmt.Errorf("build failed: %v", err)
+		}
+
+		result, err := app.publishImage(ctx, r, dst, img, username)
+		if err != nil {
+			return result, err
+		}
+
+		if err := app.recordBase(ctx, dst.String(), baseVersion, rebase.SourceBuild); err != nil {
+			return nil, err
+		}
+
+		log.Printf("Built %s from %s for %s", dst, base.Image, username)
+		result["base"] = base.Image
+		return result, nil
+	})
+	if err != nil {
+		os.Remove(spool.Name())
+	}
+	if abortOnSubmit(c, err) {
+		return
+	}
+
+	log.Printf("Queued build job %s for user %s", job.ID, username)
+	c.JSON(http.StatusAccepted, gin.H{
+		"job_id":     job.ID,
+		"status_url": fmt.Sprintf("/api/v1/container-images/jobs/%s", job.ID),
+		"events_url": fmt.Sprintf("/api/v1/container-images/jobs/%s/events", job.ID),
+	})
+}
+
+// parseEntrypoint accepts a JSON array or a single path.
+func parseEntrypoint(value, dir string) ([]string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	var entrypoint []string
+	if strings.HasPrefix(value, "[") {
+		if err := json.Unmarshal([]byte(value), &entrypoint); err != nil {
+			return nil, fmt.Errorf("invalid entrypoint: %v", err)
+		}
+	} else {
+		entrypoint = []string{value}
+	}
+	if len(entrypoint) > 0 && !path.IsAbs(entrypoint[0]) {
+		entrypoint[0] = path.Join(dir, entrypoint[0])
+	}
+	return entrypoint, nil
+}
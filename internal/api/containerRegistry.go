@@ -3,27 +3,29 @@ package api
 import (
 	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/moby/moby/client"
 
+	"github.com/digizyne/lfcont/internal/jobs"
+	"github.com/digizyne/lfcont/internal/quota"
+	"github.com/digizyne/lfcont/internal/registry"
 	"github.com/digizyne/lfcont/tools"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1/daemon"
-	"github.com/google/go-containerregistry/pkg/v1/remote"
 
-	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/uuid"
 )
 
 func (app *App) pushToContainerRegistry(c *gin.Context) {
-	ctx := context.Background()
-
 	authHeader := c.GetHeader("Authorization")
 	userClaims, err := tools.GetUserClaims(authHeader)
 	if err != nil {
@@ -35,68 +37,143 @@ func (app *App) pushToContainerRegistry(c *gin.Context) {
 	}
 	log.Printf("Authenticated user: %s", userClaims.Username)
 
-	// Initialize Docker client
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		log.Printf("Docker client error: %v", err)
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to connect to Docker daemon. Is Docker running?",
-		})
+	if c.ContentType() != "application/gzip" {
+		c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/gzip"})
 		return
 	}
-	defer cli.Close()
 
-	// Read gzip stream from request body
-	gzipStream := c.Request.Body
-	if c.ContentType() != "application/gzip" {
-		c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/gzip"})
+	// Reject uploads that cannot fit before reading them. The gzipped archive
+	// is roughly the size of the compressed layers it contains.
+	if abortOnQuota(c, app.checkStorageQuota(c.Request.Context(), userClaims.Username, max(c.Request.ContentLength, 0))) {
 		return
 	}
-	gzr, err := gzip.NewReader(gzipStream)
+
+	// Spool the upload to disk so the worker can process it after this request returns
+	archivePath, err := spoolImageArchive(http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadSize()))
 	if err != nil {
-		log.Printf("Gzip reader error: %v", err)
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
-			"error": "Failed to create gzip reader (invalid gzip data).",
+		log.Printf("Upload spool error: %v", err)
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		c.AbortWithStatusJSON(status, gin.H{
+			"error": fmt.Sprintf("Failed to read upload: %v", err),
 		})
 		return
 	}
+
+	username := userClaims.Username
+	job, err := app.Jobs.Submit("push", username, func(ctx context.Context, r *jobs.Reporter) (any, error) {
+		defer os.Remove(archivePath)
+		return app.pushImageArchive(ctx, r, archivePath, username)
+	})
+	if err != nil {
+		os.Remove(archivePath)
+	}
+	if abortOnSubmit(c, err) {
+		return
+	}
+
+	log.Printf("Queued push job %s for user %s", job.ID, username)
+	c.JSON(http.StatusAccepted, gin.H{
+		"job_id":     job.ID,
+		"status_url": fmt.Sprintf("/api/v1/container-images/jobs/%s", job.ID),
+		"events_url": fmt.Sprintf("/api/v1/container-images/jobs/%s/events", job.ID),
+	})
+}
+
+// maxUploadSize is the largest archive or artifact accepted in one request,
+// PUSH_MAX_SIZE or 10GiB.
+func maxUploadSize() int64 {
+	if limit, err := quota.ParseSize(os.Getenv("PUSH_MAX_SIZE")); err == nil && limit > 0 {
+		return limit
+	}
+	return 10 << 30
+}
+
+// spoolImageArchive copies the gzipped 'docker save' archive to a temporary
+// file and checks that it at least starts with a valid gzip header.
+func spoolImageArchive(body io.Reader) (string, error) {
+	f, err := os.CreateTemp("", "push-*.tar.gz")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, body); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	if _, err := gzip.NewReader(f); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("invalid gzip data: %v", err)
+	}
+	return f.Name(), nil
+}
+
+// pushImageArchive loads a gzipped 'docker save' archive into the local Docker
+// daemon, checks that it can run on Cloud Run, pushes it to Artifact Registry
+// under a unique tag and records it. The returned job result carries the check
+// findings even when the push is rejected.
+func (app *App) pushImageArchive(ctx context.Context, r *jobs.Reporter, archivePath, username string) (gin.H, error) {
+	// Multi-arch builds cannot be loaded into the daemon as a single image,
+	// so OCI layout archives holding an index are pushed directly
+	r.Stage("reading archive")
+	archiveIndex, err := registry.LoadIndex(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image archive: %v", err)
+	}
+	if archiveIndex != nil {
+		defer archiveIndex.Close()
+		return app.pushImageIndex(ctx, r, archiveIndex, username)
+	}
+
+	// Initialize Docker client
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Docker daemon: %v", err)
+	}
+	defer cli.Close()
+
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload: %v", err)
+	}
+	defer archive.Close()
+
+	gzr, err := gzip.NewReader(archive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gzip reader (invalid gzip data): %v", err)
+	}
 	defer gzr.Close()
 
 	// Load image into Docker daemon
+	r.Stage("loading image")
 	imageLoadResponse, err := cli.ImageLoad(ctx, gzr, client.ImageLoadWithQuiet(true))
 	if err != nil {
-		log.Printf("Docker ImageLoad error: %v", err)
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Docker ImageLoad failed. Is the tar archive a valid 'docker save' output? Error: %v", err),
-		})
-		return
+		return nil, fmt.Errorf("Docker ImageLoad failed. Is the tar archive a valid 'docker save' output? Error: %v", err)
 	}
 	defer imageLoadResponse.Body.Close()
 
 	// Get image details (specifically image ID and name so that we can tag it)
 	imageDetails, err := tools.GetContainerImageDetails(imageLoadResponse)
 	if err != nil {
-		log.Printf("Error getting image details: %v", err)
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Failed to get image details: %v", err),
-		})
-		return
+		return nil, fmt.Errorf("failed to get image details: %v", err)
 	}
 	imageID := imageDetails.ImageID
 	imageName := imageDetails.ImageName
 
 	// Tag image for target registry
-	arRepoUrl := os.Getenv("AR_REPO_URL")
-	uuid := uuid.New().String()
-	shortTag := uuid[:8]
-	targetTag := fmt.Sprintf("%s/%s:%s", arRepoUrl, imageName, shortTag)
+	r.Stage("tagging image")
+	targetTag := newTargetTag(imageName)
 	err = cli.ImageTag(ctx, imageID, targetTag)
 	if err != nil {
-		log.Printf("Docker ImageTag error: %v", err)
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Docker ImageTag failed: %v", err),
-		})
-		return
+		return nil, fmt.Errorf("Docker ImageTag failed: %v", err)
 	}
 
 	// Delete original image before tagging from local Docker daemon to free up space
@@ -108,80 +185,117 @@ func (app *App) pushToContainerRegistry(c *gin.Context) {
 		log.Printf("Warning: failed to remove original image %s from local Docker daemon: %v", imageID, err)
 	}
 
-	// TODO: Change this logic and/or the data model now that we're using unique tags
-	// Check if targetTag already exists in DB and is owned by a different user
-	// var existingUser string
-	// err = app.Pool.QueryRow(ctx, `
-	// 	SELECT COALESCE(
-	// 		(SELECT username FROM container_images WHERE fqin = $1 LIMIT 1),
-	// 		''
-	// 	)
-	// `, targetTag).Scan(&existingUser)
-	// if err != nil {
-	// 	log.Printf("DB query error: %v", err)
-	// 	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-	// 		"error": fmt.Sprintf("Database error while checking existing image: %v", err),
-	// 	})
-	// 	return
-	// }
-	// if existingUser != "" && existingUser != userClaims.Username {
-	// 	log.Printf("Image %s already exists and is owned by another user", targetTag)
-	// 	c.AbortWithStatusJSON(http.StatusConflict, gin.H{
-	// 		"error": "Image already exists and is owned by another user",
-	// 	})
-	// 	return
-	// }
+	// Delete image from local Docker daemon to free up space once we are done with it
+	defer func() {
+		_, err := cli.ImageRemove(context.Background(), targetTag, client.ImageRemoveOptions{
+			Force:         true,
+			PruneChildren: true,
+		})
+		if err != nil {
+			log.Printf("Warning: failed to remove image %s from local Docker daemon: %v", targetTag, err)
+		}
+	}()
 
 	// Get image from local Docker daemon
 	imageRef, err := name.ParseReference(targetTag)
 	if err != nil {
-		log.Fatalf("Failed to parse source reference: %v", err)
+		return nil, fmt.Errorf("failed to parse target reference: %v", err)
 	}
-	img, err := daemon.Image(imageRef)
+	img, err := daemon.Image(imageRef, daemon.WithContext(ctx))
 	if err != nil {
-		log.Fatalf("Failed to read image from local Docker daemon. Ensure Docker is running and image '%s' exists. Error: %v", imageRef, err)
+		return nil, fmt.Errorf("failed to read image '%s' from local Docker daemon: %v", imageRef, err)
 	}
 
-	// Authenticate to Artifact Registry using Service Account key
-	key, err := os.ReadFile("./sakey.json")
+	result, err := app.publishImage(ctx, r, imageRef, img, username)
 	if err != nil {
-		log.Fatalf("Failed to read Service Account key file: %v", err)
+		return result, err
 	}
-	auth := authn.FromConfig(authn.AuthConfig{
-		Username: "_json_key",
-		Password: string(key),
-	})
 
-	// Push image to Artifact Registry
-	err = remote.Write(imageRef, img, remote.WithAuth(auth), remote.WithContext(ctx))
+	log.Printf("Successfully pushed image %s to registry", targetTag)
+	return result, nil
+}
+
+// pushImageIndex pushes a multi-arch index from an uploaded archive under a
+// unique tag.
+func (app *App) pushImageIndex(ctx context.Context, r *jobs.Reporter, archiveIndex *registry.ArchiveIndex, username string) (gin.H, error) {
+	imageName := archiveIndex.Name
+	if imageName == "" {
+		imageName = "image"
+	}
+	imageRef, err := name.ParseReference(newTargetTag(imageName))
 	if err != nil {
-		log.Fatalf("Image push failed! Error: %v", err)
+		return nil, fmt.Errorf("failed to parse target reference: %v", err)
 	}
 
-	// Delete image from local Docker daemon to free up space
-	_, err = cli.ImageRemove(ctx, targetTag, client.ImageRemoveOptions{
-		Force:         true,
-		PruneChildren: true,
-	})
+	result, err := app.publishIndex(ctx, r, imageRef, archiveIndex.Index, username)
 	if err != nil {
-		log.Printf("Warning: failed to remove image %s from local Docker daemon: %v", targetTag, err)
+		return result, err
 	}
 
-	// Record pushed image in database
-	_, err = app.Pool.Exec(ctx, `
-			INSERT INTO container_images (fqin, username)
-			VALUES ($1, $2)
-		`, targetTag, userClaims.Username)
+	log.Printf("Successfully pushed image index %s to registry", imageRef)
+	return result, nil
+}
+
+// newTargetTag returns a unique reference for imageName in Artifact Registry.
+func newTargetTag(imageName string) string {
+	arRepoUrl := os.Getenv("AR_REPO_URL")
+	uuid := uuid.New().String()
+	shortTag := uuid[:8]
+	return fmt.Sprintf("%s/%s:%s", arRepoUrl, imageName, shortTag)
+}
+
+// inControllerRepo reports whether ref lives in AR_REPO_URL, the repository
+// the controller pushes to.
+func inControllerRepo(ref name.Reference) bool {
+	repoURL := strings.TrimSuffix(os.Getenv("AR_REPO_URL"), "/")
+	return repoURL != "" && strings.HasPrefix(ref.Context().Name(), repoURL+"/")
+}
+
+// reserveContainerImage records fqin (with manifest digest and compressed
+// size) as owned by username before it is uploaded. The quotas are checked
+// again while the user and their organization are locked, so concurrent
+// uploads count against each other. A failed upload releases the record
+// with releaseContainerImage.
+func (app *App) reserveContainerImage(ctx context.Context, fqin, digest, username string, size int64) error {
+	tx, err := app.Pool.Begin(ctx)
 	if err != nil {
-		log.Printf("DB insert error: %v", err)
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Failed to record image in database: %v", err),
-		})
-		return
+		return fmt.Errorf("failed to record image in database: %v", err)
 	}
+	defer tx.Rollback(ctx)
 
-	log.Printf("Successfully pushed image %s to registry", targetTag)
-	c.JSON(http.StatusOK, gin.H{
-		"fqin": targetTag,
-	})
+	var organization *string
+	if err := tx.QueryRow(ctx, `SELECT organization FROM users WHERE username = $1 FOR UPDATE`, username).Scan(&organization); err != nil {
+		return fmt.Errorf("failed to lock user: %v", err)
+	}
+	if organization != nil {
+		if _, err := tx.Exec(ctx, `SELECT 1 FROM organizations WHERE name = $1 FOR UPDATE`, *organization); err != nil {
+			return fmt.Errorf("failed to lock organization: %v", err)
+		}
+	}
+	usages, err := app.storageUsage(ctx, tx, username)
+	if err != nil {
+		return err
+	}
+	if err := quota.Check(size, usages...); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+			INSERT INTO container_images (fqin, digest, username, size_bytes)
+			VALUES ($1, $2, $3, $4)
+		`, fqin, digest, username, size)
+	if err != nil {
+		return fmt.Errorf("failed to record image in database: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to record image in database: %v", err)
+	}
+	return nil
+}
+
+// releaseContainerImage drops the record of an image whose upload failed.
+func (app *App) releaseContainerImage(ctx context.Context, fqin string) {
+	if _, err := app.Pool.Exec(context.WithoutCancel(ctx), `DELETE FROM container_images WHERE fqin = $1`, fqin); err != nil {
+		log.Printf("Failed to release record of %s: %v", fqin, err)
+	}
 }
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optdestroy"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+
+	"github.com/digizyne/lfcont/internal/operations"
+	"github.com/digizyne/lfcont/tools"
+)
+
+const OperationDelete = "delete"
+
+type DeleteDeploymentRequest struct {
+	Name string `json:"name"`
+}
+
+// deleteDeployment queues the teardown of a deployment: its Pulumi stack is
+// destroyed and removed, and the name becomes available again. Deployments
+// with a queued or running operation cannot be deleted.
+func (app *App) deleteDeployment(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	deploymentName := c.Param("name")
+	ctx := c.Request.Context()
+
+	var owner string
+	err = app.Pool.QueryRow(ctx, "SELECT username FROM deployments WHERE name = $1", deploymentName).Scan(&owner)
+	if err == pgx.ErrNoRows {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "deployment not found",
+		})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to load deployment: %v", err),
+		})
+		return
+	}
+	if owner != userClaims.Username {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": "access denied - deployment belongs to another user",
+		})
+		return
+	}
+
+	active, err := app.Operations.Active(ctx, deploymentName)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to check pending operations: %v", err),
+		})
+		return
+	}
+	if active {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error": "another operation on this deployment is queued or running",
+		})
+		return
+	}
+
+	op, err := app.Operations.Enqueue(ctx, OperationDelete, deploymentName, userClaims.Username, DeleteDeploymentRequest{Name: deploymentName})
+	if err != nil {
+		log.Printf("Failed to queue deletion: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to queue deletion: %v", err),
+		})
+		return
+	}
+
+	log.Printf("Queued delete operation %s for %s", op.ID, deploymentName)
+	c.JSON(http.StatusAccepted, gin.H{
+		"operation_id": op.ID,
+		"status_url":   fmt.Sprintf("/api/v1/operations/%s", op.ID),
+		"events_url":   fmt.Sprintf("/api/v1/operations/%s/events", op.ID),
+	})
+}
+
+// runDeleteOperation is the queue handler for delete operations.
+func (app *App) runDeleteOperation(ctx context.Context, op operations.Operation) (operations.Result, error) {
+	var req DeleteDeploymentRequest
+	if err := json.Unmarshal(op.Payload, &req); err != nil {
+		return operations.Result{}, fmt.Errorf("invalid delete operation: %v", err)
+	}
+	progress := app.Operations.Recorder(op.ID)
+
+	// The deployment may have been deleted by an earlier operation
+	var owner string
+	err := app.Pool.QueryRow(ctx, "SELECT username FROM deployments WHERE name = $1", req.Name).Scan(&owner)
+	if err == pgx.ErrNoRows || (err == nil && owner != op.Username) {
+		return operations.Result{}, fmt.Errorf("deployment %s not found", req.Name)
+	}
+	if err != nil {
+		return operations.Result{}, err
+	}
+
+	// Destroy does not run the program, so an empty one selects the stack
+	s, err := app.deploymentStack(ctx, op.Username, req.Name, func(*pulumi.Context) error { return nil })
+	if err != nil {
+		return operations.Result{}, err
+	}
+
+	engineEvents, waitForEvents := engineEventStream(progress)
+	_, err = s.Destroy(ctx, optdestroy.ProgressStreams(os.Stdout, progress), optdestroy.EventStreams(engineEvents))
+	waitForEvents()
+	if err != nil {
+		log.Printf("Destroy error: %v", err)
+		return operations.Result{}, fmt.Errorf("Failed to destroy stack: %v", err)
+	}
+
+	if err := s.Workspace().RemoveStack(ctx, s.Name()); err != nil {
+		log.Printf("Failed to remove stack %s: %v", s.Name(), err)
+		return operations.Result{}, fmt.Errorf("Failed to remove stack: %v", err)
+	}
+
+	if _, err := app.Pool.Exec(ctx, "DELETE FROM deployments WHERE name = $1", req.Name); err != nil {
+		return operations.Result{}, fmt.Errorf("Failed to delete deployment record: %v", err)
+	}
+
+	log.Printf("Deployment %s of %s deleted", req.Name, op.Username)
+	return operations.Result{Data: gin.H{
+		"name":    req.Name,
+		"deleted": true,
+	}}, nil
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/events"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+
+	"github.com/digizyne/lfcont/internal/operations"
+)
+
+// colorDirective matches Pulumi's color markup, e.g. "<{%fg 1%}>".
+var colorDirective = regexp.MustCompile(`<\{%[^%]*%\}>`)
+
+// engineEventStream returns a channel for optup.EventStreams and similar
+// options that records into progress. Pulumi closes the channel when the
+// command finishes; wait blocks until the remaining events are recorded, but
+// not for long if the command failed before it started streaming.
+func engineEventStream(progress *operations.Recorder) (chan<- events.EngineEvent, func()) {
+	engineEvents := make(chan events.EngineEvent)
+	done := make(chan struct{})
+	go func() {
+		recordEngineEvents(progress, engineEvents)
+		close(done)
+	}()
+	wait := func() {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+		}
+		progress.Flush()
+	}
+	return engineEvents, wait
+}
+
+// recordEngineEvents turns Pulumi engine events into per-resource step events
+// and records diagnostics as log lines, until events is closed.
+func recordEngineEvents(recorder *operations.Recorder, engineEvents <-chan events.EngineEvent) {
+	for event := range engineEvents {
+		switch {
+		case event.Error != nil:
+			recorder.Log("failed to read engine event: " + event.Error.Error())
+		case event.ResourcePreEvent != nil && !event.ResourcePreEvent.Planning:
+			metadata := event.ResourcePreEvent.Metadata
+			if status, ok := stepStatus(metadata.Op); ok {
+				recorder.Step(metadata.URN, metadata.Type, string(metadata.Op), status)
+			}
+		case event.ResOutputsEvent != nil && !event.ResOutputsEvent.Planning:
+			metadata := event.ResOutputsEvent.Metadata
+			if _, ok := stepStatus(metadata.Op); ok {
+				recorder.Step(metadata.URN, metadata.Type, string(metadata.Op), operations.StepDone)
+			}
+		case event.ResOpFailedEvent != nil:
+			metadata := event.ResOpFailedEvent.Metadata
+			recorder.Step(metadata.URN, metadata.Type, string(metadata.Op), operations.StepFailed)
+		case event.DiagnosticEvent != nil && !event.DiagnosticEvent.Ephemeral:
+			message := strings.TrimSpace(colorDirective.ReplaceAllString(event.DiagnosticEvent.Message, ""))
+			recorder.Log(event.DiagnosticEvent.Severity + ": " + message)
+		}
+	}
+}
+
+// stepStatus maps the operations that change a resource to the status shown
+// while they run. Unchanged resources are not reported.
+func stepStatus(op apitype.OpType) (string, bool) {
+	switch op {
+	case apitype.OpCreate:
+		return operations.StepCreating, true
+	case apitype.OpUpdate:
+		return operations.StepUpdating, true
+	case apitype.OpReplace, apitype.OpCreateReplacement:
+		return operations.StepReplacing, true
+	case apitype.OpDelete, apitype.OpDeleteReplaced:
+		return operations.StepDeleting, true
+	}
+	return "", false
+}
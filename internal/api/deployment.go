@@ -2,23 +2,40 @@ package api
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 
+	"github.com/digizyne/lfcont/internal/imagecheck"
+	"github.com/digizyne/lfcont/internal/operations"
+	"github.com/digizyne/lfcont/internal/registry"
+	"github.com/digizyne/lfcont/internal/secrets"
+	"github.com/digizyne/lfcont/internal/tiers"
 	"github.com/digizyne/lfcont/tools"
 	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/jackc/pgx/v5"
 	"github.com/pulumi/pulumi-gcp/sdk/v9/go/gcp/cloudrunv2"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto/optup"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
+const OperationDeploy = "deploy"
+
 type RequestBody struct {
 	Name           string `json:"name"`
 	ContainerImage string `json:"container_image"`
 	Tier           string `json:"tier"`
+	// Env and Secrets replace the deployment's variables and secrets when
+	// present. Secrets are sealed into SealedSecrets before the request is
+	// queued, so their values are never stored in plain text.
+	Env           map[string]string         `json:"env"`
+	Secrets       map[string]string         `json:"secrets,omitempty"`
+	SealedSecrets map[string]secrets.Sealed `json:"sealed_secrets"`
 }
 
 func (app *App) deploy(c *gin.Context) {
@@ -41,137 +58,197 @@ func (app *App) deploy(c *gin.Context) {
 		})
 		return
 	}
+	if !app.validateTier(c, userClaims.Username, req.Tier) {
+		return
+	}
+	if !sealRequestSecrets(c, &req) {
+		return
+	}
 
-	// Check for existing deployment with the same name
-	updateNeeded := false
-	rows, err := app.Pool.Query(c.Request.Context(), `SELECT username FROM deployments WHERE name=$1`, req.Name)
-	if err != nil {
+	// Reject names owned by someone else right away; everything else is
+	// checked by the worker
+	var existingUsername string
+	err = app.Pool.QueryRow(c.Request.Context(), `SELECT username FROM deployments WHERE name=$1`, req.Name).Scan(&existingUsername)
+	if err != nil && err != pgx.ErrNoRows {
 		log.Printf("DB query error: %v", err)
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
 			"error": fmt.Sprintf("Failed to check existing deployments: %v", err),
 		})
 		return
 	}
-	defer rows.Close()
-
-	var existingUsername string
-	if rows.Next() {
-		if err := rows.Scan(&existingUsername); err != nil {
-			log.Printf("DB scan error: %v", err)
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-				"error": fmt.Sprintf("Failed to read existing deployment: %v", err),
-			})
-			return
-		}
-		if existingUsername != userClaims.Username {
-			log.Printf("Deployment name %s already owned by %s", req.Name, existingUsername)
-			c.AbortWithStatusJSON(http.StatusConflict, gin.H{
-				"error": "Deployment name already in use by another user",
-			})
-			return
-		}
-		updateNeeded = true
+	if err == nil && existingUsername != userClaims.Username {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error": "Deployment name already in use by another user",
+		})
+		return
 	}
 
-	createCloudRunService := func(ctx *pulumi.Context) error {
-		service, err := cloudrunv2.NewService(ctx, req.Name, &cloudrunv2.ServiceArgs{
-			Location:           pulumi.String("us-central1"),
-			Name:               pulumi.String(req.Name),
-			DeletionProtection: pulumi.Bool(false),
-			Scaling: &cloudrunv2.ServiceScalingArgs{
-				MinInstanceCount: pulumi.Int(0),
-				MaxInstanceCount: pulumi.Int(1),
-			},
-			Template: &cloudrunv2.ServiceTemplateArgs{
-				Scaling: &cloudrunv2.ServiceTemplateScalingArgs{
-					MinInstanceCount: pulumi.Int(0),
-					MaxInstanceCount: pulumi.Int(1),
-				},
-				Containers: cloudrunv2.ServiceTemplateContainerArray{
-					&cloudrunv2.ServiceTemplateContainerArgs{
-						Image: pulumi.String(req.ContainerImage),
-					},
-				},
-			},
+	op, err := app.Operations.Enqueue(c.Request.Context(), OperationDeploy, req.Name, userClaims.Username, req)
+	if err != nil {
+		log.Printf("Failed to queue deployment: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to queue deployment: %v", err),
 		})
-		if err != nil {
-			return err
-		}
+		return
+	}
 
-		// Allow public access using Cloud Run service IAM policy
-		_, err = cloudrunv2.NewServiceIamBinding(ctx, "public-access", &cloudrunv2.ServiceIamBindingArgs{
-			Project:  pulumi.String("local-first-476300"),
-			Location: pulumi.String("us-central1"),
-			Name:     service.Name,
-			Role:     pulumi.String("roles/run.invoker"),
-			Members: pulumi.StringArray{
-				pulumi.String("allUsers"),
-			},
-		})
-		if err != nil {
-			return err
-		}
+	log.Printf("Queued deploy operation %s for %s", op.ID, req.Name)
+	c.JSON(http.StatusAccepted, gin.H{
+		"operation_id": op.ID,
+		"status_url":   fmt.Sprintf("/api/v1/operations/%s", op.ID),
+		"events_url":   fmt.Sprintf("/api/v1/operations/%s/events", op.ID),
+	})
+}
 
-		// Export the service URL as a stack output
-		ctx.Export("serviceUrl", service.Uri)
+// runDeployOperation is the queue handler for deploy operations.
+func (app *App) runDeployOperation(ctx context.Context, op operations.Operation) (operations.Result, error) {
+	var req RequestBody
+	if err := json.Unmarshal(op.Payload, &req); err != nil {
+		return operations.Result{}, fmt.Errorf("invalid deploy operation: %v", err)
+	}
+	result, err := app.rollout(ctx, op, req, nil)
+	if err != nil {
+		return operations.Result{}, err
+	}
+	url, _ := result["service_url"].(string)
+	return operations.Result{URL: url, Data: result}, nil
+}
 
-		return nil
+// deployError is a failed rollout with the details reported to the client.
+type deployError struct {
+	body gin.H
+}
+
+func (e *deployError) Error() string {
+	return fmt.Sprint(e.body["error"])
+}
+
+// Details are stored with the failed operation, e.g. the admission decision.
+func (e *deployError) Details() any {
+	return e.body
+}
+
+func failDeploy(message string) error {
+	return &deployError{body: gin.H{"error": message}}
+}
+
+// rollout deploys req.ContainerImage as req.Name for the owner of op,
+// creating the service or updating it if they already own one with that name.
+// Progress of the Pulumi update is recorded to the operation's events and the
+// result is recorded as a new revision; rollbackOf is the revision being
+// restored, if any.
+func (app *App) rollout(ctx context.Context, op operations.Operation, req RequestBody, rollbackOf *int) (gin.H, error) {
+	username := op.Username
+	progress := app.Operations.Recorder(op.ID)
+
+	// Check for existing deployment with the same name
+	updateNeeded := false
+	var existingUsername, existingTier string
+	err := app.Pool.QueryRow(ctx, `SELECT username, tier FROM deployments WHERE name=$1`, req.Name).Scan(&existingUsername, &existingTier)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+	case err != nil:
+		log.Printf("DB query error: %v", err)
+		return nil, failDeploy(fmt.Sprintf("Failed to check existing deployments: %v", err))
+	case existingUsername != username:
+		log.Printf("Deployment name %s already owned by %s", req.Name, existingUsername)
+		return nil, failDeploy("Deployment name already in use by another user")
+	default:
+		updateNeeded = true
 	}
 
-	ctx := context.Background()
+	// A redeploy keeps the deployment's tier unless another one is requested
+	tier, tierSettings, err := app.deploymentTier(ctx, username, req.Tier, existingTier)
+	if err != nil {
+		return nil, failDeploy(err.Error())
+	}
 
-	// Create unique stack name to ensure each deployment creates a new service
-	stackName := fmt.Sprintf("stack-%s-%s", userClaims.Username, req.Name)
-	projectName := fmt.Sprintf("project-%s", req.Name)
+	env, sealedSecrets, err := app.deploymentEnv(ctx, req)
+	if err != nil {
+		return nil, failDeploy(err.Error())
+	}
+	secretValues, err := openSecrets(sealedSecrets)
+	if err != nil {
+		return nil, failDeploy(fmt.Sprintf("Failed to decrypt secrets: %v", err))
+	}
 
-	s, err := auto.UpsertStackInlineSource(ctx, stackName, projectName, createCloudRunService)
+	// Pin the image to the digest the tag points at now, so the rollout and
+	// the deployment record are not affected if the tag is moved later
+	pinnedImage, err := app.pinImage(ctx, req.ContainerImage)
 	if err != nil {
-		log.Printf("Stack creation error: %v", err)
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Failed to create or select stack: %v", err),
-		})
-		return
+		log.Printf("Image resolution error: %v", err)
+		return nil, failDeploy(fmt.Sprintf("Failed to resolve container image: %v", err))
+	}
+	requestedImage, err := app.recordedImageName(ctx, req.ContainerImage, pinnedImage)
+	if err != nil {
+		log.Printf("DB query error: %v", err)
+		return nil, failDeploy(fmt.Sprintf("Failed to look up container image: %v", err))
 	}
 
-	w := s.Workspace()
-	err = w.InstallPlugin(ctx, "gcp", "v9.3.0")
+	// Cloud Run runs a single platform, so for a multi-arch index the child
+	// built for it is deployed. The record keeps the digest of the index.
+	runtimeImage, err := registry.PinPlatform(ctx, pinnedImage, imagecheck.PolicyFromEnv().Platform, app.Credentials.RemoteOptions(ctx)...)
 	if err != nil {
-		log.Printf("Plugin install error: %v", err)
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Failed to install GCP plugin: %v", err),
-		})
-		return
+		log.Printf("Image resolution error: %v", err)
+		return nil, failDeploy(fmt.Sprintf("Failed to resolve container image for the runtime platform: %v", err))
 	}
 
-	s.SetConfig(ctx, "gcp:project", auto.ConfigValue{Value: "local-first-476300"})
+	// Check that the image can run on Cloud Run before creating the stack
+	findings, err := app.inspectImage(ctx, runtimeImage.String())
+	if err != nil {
+		log.Printf("Image inspection error: %v", err)
+		return nil, failDeploy(fmt.Sprintf("Failed to inspect container image: %v", err))
+	}
+	if imagecheck.HasErrors(findings) {
+		return nil, &deployError{body: gin.H{
+			"error":    "Container image is not compatible with the runtime",
+			"findings": findings,
+		}}
+	}
+
+	// Evaluate the organization's admission policy for this tier
+	decision, err := app.admissionDecision(ctx, username, req.ContainerImage, pinnedImage, tier)
+	if err != nil {
+		log.Printf("Admission policy error: %v", err)
+		return nil, failDeploy(fmt.Sprintf("Failed to evaluate admission policy: %v", err))
+	}
+	if !decision.Allowed {
+		return nil, &deployError{body: gin.H{
+			"error":     "Container image rejected by admission policy",
+			"admission": decision,
+		}}
+	}
+
+	// Cancelling Pulumi part way leaves the stack locked with pending
+	// operations, so once the update starts it runs to completion even if
+	// the controller is shutting down; the record below must match it too
+	ctx = context.WithoutCancel(ctx)
+
+	s, err := app.deploymentStack(ctx, username, req.Name, cloudRunProgram(req.Name, runtimeImage.String(), tierSettings, serviceConfig{Env: env, Secrets: secretValues}))
+	if err != nil {
+		return nil, failDeploy(err.Error())
+	}
 
 	_, err = s.Refresh(ctx)
 	if err != nil {
 		log.Printf("Refresh error: %v", err)
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Failed to refresh stack: %v", err),
-		})
-		return
+		return nil, failDeploy(fmt.Sprintf("Failed to refresh stack: %v", err))
 	}
 
-	stdoutStreamer := optup.ProgressStreams(os.Stdout)
+	stdoutStreamer := optup.ProgressStreams(os.Stdout, progress)
 
-	output, err := s.Up(ctx, stdoutStreamer)
+	engineEvents, waitForEvents := engineEventStream(progress)
+	output, err := s.Up(ctx, stdoutStreamer, optup.EventStreams(engineEvents))
+	waitForEvents()
 	if err != nil {
 		log.Printf("Deployment error: %v", err)
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Failed to update stack: %v", err),
-		})
-		return
+		return nil, failDeploy(fmt.Sprintf("Failed to update stack: %v", err))
 	}
 
 	// Check for errors in the deployment output
 	if output.Summary.ResourceChanges == nil || len(*output.Summary.ResourceChanges) == 0 {
 		log.Printf("No resource changes detected - possible deployment issue")
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"error": "Deployment completed but no resources were changed",
-		})
-		return
+		return nil, failDeploy("Deployment completed but no resources were changed")
 	}
 
 	// Check deployment result
@@ -183,29 +260,24 @@ func (app *App) deploy(c *gin.Context) {
 
 	if totalChanges == 0 {
 		log.Printf("No resource operations performed")
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"error": "Deployment completed but no resources were processed",
-		})
-		return
+		return nil, failDeploy("Deployment completed but no resources were processed")
 	}
 
 	// Get the service URL from stack outputs
 	outputs, err := s.Outputs(ctx)
 	if err != nil {
 		log.Printf("Failed to get stack outputs: %v", err)
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"error": "Deployment succeeded but failed to get service URL",
-		})
-		return
+		return nil, failDeploy("Deployment succeeded but failed to get service URL")
 	}
 
-	var serviceUrl string
-	if urlOutput, exists := outputs["serviceUrl"]; exists {
-		serviceUrl = urlOutput.Value.(string)
-		log.Printf("Service deployed successfully at: %s", serviceUrl)
-	} else {
+	serviceUrl := "URL not available"
+	if urlOutput, exists := outputs["serviceUrl"]; !exists {
 		log.Printf("Warning: serviceUrl not found in stack outputs")
-		serviceUrl = "URL not available"
+	} else if url, ok := urlOutput.Value.(string); !ok {
+		log.Printf("Warning: serviceUrl output is not a string: %v", urlOutput.Value)
+	} else {
+		serviceUrl = url
+		log.Printf("Service deployed successfully at: %s", serviceUrl)
 	}
 
 	// Log the resource changes for debugging
@@ -213,35 +285,199 @@ func (app *App) deploy(c *gin.Context) {
 
 	// Record deployment in database
 	if updateNeeded {
-		_, err := app.Pool.Exec(ctx, `UPDATE deployments SET container_image=$1 WHERE name=$2`, req.ContainerImage, req.Name)
+		_, err := app.Pool.Exec(ctx, `UPDATE deployments SET container_image=$1, image_digest=$2, tier=$3 WHERE name=$4`, requestedImage, pinnedImage.DigestStr(), tier, req.Name)
 		if err != nil {
 			log.Printf("DB update error: %v", err)
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-				"error": fmt.Sprintf("Failed to update deployment record: %v", err),
-			})
-			return
+			return nil, failDeploy(fmt.Sprintf("Failed to update deployment record: %v", err))
 		}
 
-		log.Printf("Deployment %s updated with new container image %s", req.Name, req.ContainerImage)
-		c.JSON(http.StatusOK, gin.H{
-			"service_url": serviceUrl,
-		})
-		return
+		log.Printf("Deployment %s updated with new container image %s on tier %s", req.Name, pinnedImage, tier)
 	} else {
 		_, err = app.Pool.Exec(ctx, `
-				INSERT INTO deployments (name, url, tier, container_image, username)
-				VALUES ($1, $2, $3, $4, $5) 
-			`, req.Name, serviceUrl, req.Tier, req.ContainerImage, userClaims.Username)
+				INSERT INTO deployments (name, url, tier, container_image, image_digest, username)
+				VALUES ($1, $2, $3, $4, $5, $6) 
+			`, req.Name, serviceUrl, tier, requestedImage, pinnedImage.DigestStr(), username)
 		if err != nil {
 			log.Printf("DB insert error: %v", err)
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-				"error": fmt.Sprintf("Failed to record deployment in database: %v", err),
-			})
-			return
+			return nil, failDeploy(fmt.Sprintf("Failed to record deployment in database: %v", err))
 		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"service_url": serviceUrl,
-	})
+	if err := app.storeDeploymentEnv(ctx, req.Name, env, sealedSecrets); err != nil {
+		log.Printf("DB update error: %v", err)
+		return nil, failDeploy(fmt.Sprintf("Failed to record deployment environment: %v", err))
+	}
+
+	// Record the revision with the spec pinned to what was deployed, so a
+	// rollback runs the same image even if the tag has moved since
+	spec := RequestBody{
+		Name:           req.Name,
+		ContainerImage: pinnedImage.String(),
+		Tier:           tier,
+		Env:            env,
+		SealedSecrets:  sealedSecrets,
+	}
+	revision, err := app.recordRevision(ctx, op, spec, requestedImage, pinnedImage.DigestStr(), runtimeImage.DigestStr(), output.Summary.Version, rollbackOf)
+	if err != nil {
+		log.Printf("Warning: failed to record revision of %s: %v", req.Name, err)
+	}
+
+	return gin.H{
+		"service_url":     serviceUrl,
+		"container_image": requestedImage,
+		"image_digest":    pinnedImage.DigestStr(),
+		"runtime_digest":  runtimeImage.DigestStr(),
+		"tier":            tier,
+		"env":             env,
+		"secrets":         secretNames(sealedSecrets),
+		"revision":        revision,
+		"findings":        findings,
+	}, nil
+}
+
+// cloudRunProgram is the Pulumi program of a deployment: a public Cloud Run
+// service named name running image with the resources and scaling of tier.
+// The service runs as its own service account, the only one granted access
+// to its secrets, which are stored in Secret Manager and referenced from the
+// environment.
+func cloudRunProgram(name, image string, tier tiers.Tier, config serviceConfig) pulumi.RunFunc {
+	return func(ctx *pulumi.Context) error {
+		runtimeAccount, err := newRuntimeAccount(ctx, name)
+		if err != nil {
+			return err
+		}
+		envs, dependencies, err := containerEnv(ctx, name, runtimeAccount, config)
+		if err != nil {
+			return err
+		}
+
+		service, err := cloudrunv2.NewService(ctx, name, &cloudrunv2.ServiceArgs{
+			Location:           pulumi.String("us-central1"),
+			Name:               pulumi.String(name),
+			DeletionProtection: pulumi.Bool(false),
+			Scaling: &cloudrunv2.ServiceScalingArgs{
+				MinInstanceCount: pulumi.Int(tier.MinInstances),
+				MaxInstanceCount: pulumi.Int(tier.MaxInstances),
+			},
+			Template: &cloudrunv2.ServiceTemplateArgs{
+				Scaling: &cloudrunv2.ServiceTemplateScalingArgs{
+					MinInstanceCount: pulumi.Int(tier.MinInstances),
+					MaxInstanceCount: pulumi.Int(tier.MaxInstances),
+				},
+				ServiceAccount:                runtimeAccount.Email,
+				MaxInstanceRequestConcurrency: pulumi.Int(tier.Concurrency),
+				Timeout:                       pulumi.String(tier.RequestTimeout()),
+				Containers: cloudrunv2.ServiceTemplateContainerArray{
+					&cloudrunv2.ServiceTemplateContainerArgs{
+						Image: pulumi.String(image),
+						Envs:  envs,
+						Resources: &cloudrunv2.ServiceTemplateContainerResourcesArgs{
+							Limits: pulumi.StringMap{
+								"cpu":    pulumi.String(tier.CPU),
+								"memory": pulumi.String(tier.Memory),
+							},
+							CpuIdle: pulumi.Bool(!tier.CPUAlwaysAllocated),
+						},
+					},
+				},
+			},
+		}, pulumi.DependsOn(dependencies))
+		if err != nil {
+			return err
+		}
+
+		// Allow public access using Cloud Run service IAM policy
+		_, err = cloudrunv2.NewServiceIamBinding(ctx, "public-access", &cloudrunv2.ServiceIamBindingArgs{
+			Project:  pulumi.String("local-first-476300"),
+			Location: pulumi.String("us-central1"),
+			Name:     service.Name,
+			Role:     pulumi.String("roles/run.invoker"),
+			Members: pulumi.StringArray{
+				pulumi.String("allUsers"),
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		// Export the service URL as a stack output
+		ctx.Export("serviceUrl", service.Uri)
+
+		return nil
+	}
+}
+
+// deploymentStack creates or selects the Pulumi stack of deployment name
+// owned by username and configures the GCP provider.
+func (app *App) deploymentStack(ctx context.Context, username, name string, program pulumi.RunFunc) (auto.Stack, error) {
+	// Create unique stack name to ensure each deployment creates a new service
+	stackName := fmt.Sprintf("stack-%s-%s", username, name)
+	projectName := fmt.Sprintf("project-%s", name)
+
+	s, err := auto.UpsertStackInlineSource(ctx, stackName, projectName, program)
+	if err != nil {
+		log.Printf("Stack creation error: %v", err)
+		return auto.Stack{}, fmt.Errorf("Failed to create or select stack: %v", err)
+	}
+
+	w := s.Workspace()
+	err = w.InstallPlugin(ctx, "gcp", "v9.3.0")
+	if err != nil {
+		log.Printf("Plugin install error: %v", err)
+		return auto.Stack{}, fmt.Errorf("Failed to install GCP plugin: %v", err)
+	}
+
+	s.SetConfig(ctx, "gcp:project", auto.ConfigValue{Value: "local-first-476300"})
+	if keyFile := app.Credentials.KeyFile(); keyFile != "" {
+		s.SetConfig(ctx, "gcp:credentials", auto.ConfigValue{Value: keyFile})
+	}
+	return s, nil
+}
+
+// inspectImage fetches the image config from the registry and checks it
+// against the runtime requirements.
+func (app *App) inspectImage(ctx context.Context, image string) ([]imagecheck.Finding, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return nil, err
+	}
+	policy := imagecheck.PolicyFromEnv()
+	img, err := registry.Resolve(ctx, ref, policy.Platform, app.Credentials.RemoteOptions(ctx)...)
+	if err != nil {
+		return nil, err
+	}
+	return imagecheck.Inspect(img, policy)
+}
+
+// pinImage resolves image to the manifest digest it currently refers to.
+func (app *App) pinImage(ctx context.Context, image string) (name.Digest, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return name.Digest{}, err
+	}
+	return registry.Pin(ctx, ref, app.Credentials.RemoteOptions(ctx)...)
+}
+
+// recordedImageName returns the name under which the deployed image is
+// recorded in container_images. Tags are recorded as-is; for a reference that
+// was already pinned by digest, the pushed tag with that digest is used.
+func (app *App) recordedImageName(ctx context.Context, image string, pinned name.Digest) (string, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := ref.(name.Digest); !ok {
+		return image, nil
+	}
+	var fqin string
+	err = app.Pool.QueryRow(ctx, `
+		SELECT fqin FROM container_images WHERE digest = $1 AND starts_with(fqin, $2 || ':') ORDER BY fqin LIMIT 1
+	`, pinned.DigestStr(), pinned.Context().Name()).Scan(&fqin)
+	if err == pgx.ErrNoRows {
+		return image, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return fqin, nil
 }
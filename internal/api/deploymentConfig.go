@@ -0,0 +1,303 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/pulumi/pulumi-gcp/sdk/v9/go/gcp/cloudrunv2"
+	"github.com/pulumi/pulumi-gcp/sdk/v9/go/gcp/secretmanager"
+	"github.com/pulumi/pulumi-gcp/sdk/v9/go/gcp/serviceaccount"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+
+	"github.com/digizyne/lfcont/internal/secrets"
+)
+
+// envName is what Cloud Run accepts as an environment variable name.
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// reservedEnv are set by Cloud Run itself.
+var reservedEnv = map[string]bool{
+	"PORT":            true,
+	"K_SERVICE":       true,
+	"K_REVISION":      true,
+	"K_CONFIGURATION": true,
+}
+
+// serviceConfig is the environment of a deployment's container. Secret
+// values are only held in memory while the Pulumi program runs.
+type serviceConfig struct {
+	Env     map[string]string
+	Secrets map[string]string
+}
+
+// validateEnvNames checks the names of plain variables and secrets, which
+// share one environment.
+func validateEnvNames(env, secretValues map[string]string) error {
+	for key := range env {
+		if err := validateEnvName(key); err != nil {
+			return err
+		}
+	}
+	for key := range secretValues {
+		if err := validateEnvName(key); err != nil {
+			return err
+		}
+		if _, ok := env[key]; ok {
+			return fmt.Errorf("%s is set both as a variable and as a secret", key)
+		}
+	}
+	return nil
+}
+
+func validateEnvName(key string) error {
+	if !envName.MatchString(key) {
+		return fmt.Errorf("invalid environment variable name %q", key)
+	}
+	if reservedEnv[key] {
+		return fmt.Errorf("environment variable %s is reserved by Cloud Run", key)
+	}
+	return nil
+}
+
+// sealRequestSecrets validates the requested environment and replaces the
+// plaintext secrets of req with sealed ones, so they are never queued or
+// stored as given. It aborts the request on failure.
+func sealRequestSecrets(c *gin.Context, req *RequestBody) bool {
+	if err := validateEnvNames(req.Env, req.Secrets); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return false
+	}
+
+	req.SealedSecrets = nil
+	if req.Secrets == nil {
+		return true
+	}
+	masterKey := secrets.MasterKey()
+	if masterKey == "" && len(req.Secrets) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "deployment secrets are disabled on this server",
+		})
+		return false
+	}
+	req.SealedSecrets = make(map[string]secrets.Sealed, len(req.Secrets))
+	for key, value := range req.Secrets {
+		sealed, err := secrets.Seal(value, masterKey)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to encrypt secret %s: %v", key, err),
+			})
+			return false
+		}
+		req.SealedSecrets[key] = sealed
+	}
+	req.Secrets = nil
+	return true
+}
+
+// deploymentEnv returns the environment a rollout of req uses. Variables and
+// secrets that are not part of the request are kept from the deployment;
+// an empty object clears them.
+func (app *App) deploymentEnv(ctx context.Context, req RequestBody) (map[string]string, map[string]secrets.Sealed, error) {
+	env, sealed := req.Env, req.SealedSecrets
+
+	if env == nil {
+		var document []byte
+		err := app.Pool.QueryRow(ctx, `SELECT env FROM deployments WHERE name = $1`, req.Name).Scan(&document)
+		if err != nil && err != pgx.ErrNoRows {
+			return nil, nil, fmt.Errorf("Failed to load environment: %v", err)
+		}
+		env = map[string]string{}
+		if err == nil {
+			if err := json.Unmarshal(document, &env); err != nil {
+				return nil, nil, fmt.Errorf("Failed to load environment: %v", err)
+			}
+		}
+	}
+
+	if sealed == nil {
+		rows, err := app.Pool.Query(ctx, `
+			SELECT key, wrapped_key, ciphertext FROM deployment_secrets WHERE deployment_name = $1
+		`, req.Name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to load secrets: %v", err)
+		}
+		defer rows.Close()
+		sealed = map[string]secrets.Sealed{}
+		for rows.Next() {
+			var key string
+			var value secrets.Sealed
+			if err := rows.Scan(&key, &value.Key, &value.Ciphertext); err != nil {
+				return nil, nil, fmt.Errorf("Failed to load secrets: %v", err)
+			}
+			sealed[key] = value
+		}
+		if err := rows.Err(); err != nil {
+			return nil, nil, fmt.Errorf("Failed to load secrets: %v", err)
+		}
+	}
+
+	// A kept secret may clash with a newly requested variable and vice versa
+	for key := range sealed {
+		if _, ok := env[key]; ok {
+			return nil, nil, fmt.Errorf("%s is set both as a variable and as a secret", key)
+		}
+	}
+	return env, sealed, nil
+}
+
+// openSecrets decrypts sealed secrets for the Pulumi program.
+func openSecrets(sealed map[string]secrets.Sealed) (map[string]string, error) {
+	values := make(map[string]string, len(sealed))
+	for key, value := range sealed {
+		plaintext, err := secrets.Open(value, secrets.MasterKey())
+		if err != nil {
+			return nil, fmt.Errorf("secret %s: %v", key, err)
+		}
+		values[key] = plaintext
+	}
+	return values, nil
+}
+
+// storeDeploymentEnv records the environment of a successful rollout.
+func (app *App) storeDeploymentEnv(ctx context.Context, name string, env map[string]string, sealed map[string]secrets.Sealed) error {
+	document, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	tx, err := app.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `UPDATE deployments SET env = $1 WHERE name = $2`, document, name); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM deployment_secrets WHERE deployment_name = $1`, name); err != nil {
+		return err
+	}
+	for key, value := range sealed {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO deployment_secrets (deployment_name, key, wrapped_key, ciphertext)
+			VALUES ($1, $2, $3, $4)
+		`, name, key, value.Key, value.Ciphertext)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// secretNames lists the names of sealed secrets, which is all the API
+// reveals about them.
+func secretNames(sealed map[string]secrets.Sealed) []string {
+	names := make([]string, 0, len(sealed))
+	for key := range sealed {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runtimeAccountID derives the id of a deployment's runtime service account
+// from its name. Ids are 6 to 30 characters, so long names are cut and a hash
+// of the full name keeps them distinct.
+func runtimeAccountID(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	prefix := name
+	if len(prefix) > 17 {
+		prefix = strings.TrimRight(prefix[:17], "-")
+	}
+	return fmt.Sprintf("run-%s-%s", prefix, hex.EncodeToString(sum[:4]))
+}
+
+// newRuntimeAccount creates the service account a deployment's service runs
+// as. Each deployment gets its own, so secrets granted to it are not
+// readable by the services of other users.
+func newRuntimeAccount(ctx *pulumi.Context, name string) (*serviceaccount.Account, error) {
+	return serviceaccount.NewAccount(ctx, "runtime-account", &serviceaccount.AccountArgs{
+		Project:     pulumi.String("local-first-476300"),
+		AccountId:   pulumi.String(runtimeAccountID(name)),
+		DisplayName: pulumi.String("Runtime account of " + name),
+	})
+}
+
+// containerEnv builds the container environment. Each secret becomes a
+// Secret Manager secret readable only by the service's runtime account, and
+// is referenced by version. The returned resources must exist before the
+// service is updated.
+func containerEnv(ctx *pulumi.Context, name string, runtimeAccount *serviceaccount.Account, config serviceConfig) (cloudrunv2.ServiceTemplateContainerEnvArray, []pulumi.Resource, error) {
+	envs := cloudrunv2.ServiceTemplateContainerEnvArray{}
+	for _, key := range sortedKeys(config.Env) {
+		envs = append(envs, &cloudrunv2.ServiceTemplateContainerEnvArgs{
+			Name:  pulumi.String(key),
+			Value: pulumi.String(config.Env[key]),
+		})
+	}
+	if len(config.Secrets) == 0 {
+		return envs, nil, nil
+	}
+
+	var dependencies []pulumi.Resource
+	for _, key := range sortedKeys(config.Secrets) {
+		secret, err := secretmanager.NewSecret(ctx, "secret-"+key, &secretmanager.SecretArgs{
+			Project:  pulumi.String("local-first-476300"),
+			SecretId: pulumi.String(name + "-" + key),
+			Replication: &secretmanager.SecretReplicationArgs{
+				Auto: &secretmanager.SecretReplicationAutoArgs{},
+			},
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		version, err := secretmanager.NewSecretVersion(ctx, "secret-"+key, &secretmanager.SecretVersionArgs{
+			Secret:     secret.ID(),
+			SecretData: pulumi.ToSecret(pulumi.String(config.Secrets[key])).(pulumi.StringOutput),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		access, err := secretmanager.NewSecretIamMember(ctx, "secret-access-"+key, &secretmanager.SecretIamMemberArgs{
+			Project:  pulumi.String("local-first-476300"),
+			SecretId: secret.SecretId,
+			Role:     pulumi.String("roles/secretmanager.secretAccessor"),
+			Member:   pulumi.Sprintf("serviceAccount:%s", runtimeAccount.Email),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		dependencies = append(dependencies, version, access)
+
+		envs = append(envs, &cloudrunv2.ServiceTemplateContainerEnvArgs{
+			Name: pulumi.String(key),
+			ValueSource: &cloudrunv2.ServiceTemplateContainerEnvValueSourceArgs{
+				SecretKeyRef: &cloudrunv2.ServiceTemplateContainerEnvValueSourceSecretKeyRefArgs{
+					Secret:  secret.SecretId,
+					Version: version.Version,
+				},
+			},
+		})
+	}
+	return envs, dependencies, nil
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,297 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/events"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optpreview"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+
+	"github.com/digizyne/lfcont/internal/imagecheck"
+	"github.com/digizyne/lfcont/internal/operations"
+	"github.com/digizyne/lfcont/internal/registry"
+	"github.com/digizyne/lfcont/tools"
+)
+
+const OperationPreview = "preview"
+
+type PreviewRequest struct {
+	ContainerImage string            `json:"container_image" binding:"required"`
+	Tier           string            `json:"tier"`
+	Env            map[string]string `json:"env"`
+	Secrets        map[string]string `json:"secrets"`
+}
+
+// PlannedChange is a resource the deployment would create, update, replace or
+// delete.
+type PlannedChange struct {
+	URN  string `json:"urn"`
+	Type string `json:"type"`
+	Op   string `json:"op"`
+	// ReplaceKeys are the properties that force a replacement.
+	ReplaceKeys []string         `json:"replace_keys,omitempty"`
+	Diffs       []PropertyChange `json:"diffs"`
+}
+
+// PropertyChange is the difference of a single property, e.g.
+// "template.containers[0].image".
+type PropertyChange struct {
+	Path string `json:"path"`
+	Kind string `json:"kind"`
+	Old  any    `json:"old,omitempty"`
+	New  any    `json:"new,omitempty"`
+}
+
+// previewDeployment queues a Pulumi preview of deploying container_image as
+// the deployment name. The operation result lists the planned changes; the
+// stack and the deployments table are left untouched.
+func (app *App) previewDeployment(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	var req PreviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{
+			"error":   "invalid request payload",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if !app.validateTier(c, userClaims.Username, req.Tier) {
+		return
+	}
+
+	deploymentName := c.Param("name")
+	ctx := c.Request.Context()
+
+	var owner string
+	err = app.Pool.QueryRow(ctx, `SELECT username FROM deployments WHERE name=$1`, deploymentName).Scan(&owner)
+	if err != nil && err != pgx.ErrNoRows {
+		log.Printf("DB query error: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to check existing deployments: %v", err),
+		})
+		return
+	}
+	if err == nil && owner != userClaims.Username {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error": "Deployment name already in use by another user",
+		})
+		return
+	}
+
+	payload := RequestBody{
+		Name:           deploymentName,
+		ContainerImage: req.ContainerImage,
+		Tier:           req.Tier,
+		Env:            req.Env,
+		Secrets:        req.Secrets,
+	}
+	if !sealRequestSecrets(c, &payload) {
+		return
+	}
+	op, err := app.Operations.Enqueue(ctx, OperationPreview, deploymentName, userClaims.Username, payload)
+	if err != nil {
+		log.Printf("Failed to queue preview: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to queue preview: %v", err),
+		})
+		return
+	}
+
+	log.Printf("Queued preview operation %s for %s", op.ID, deploymentName)
+	c.JSON(http.StatusAccepted, gin.H{
+		"operation_id": op.ID,
+		"status_url":   fmt.Sprintf("/api/v1/operations/%s", op.ID),
+		"events_url":   fmt.Sprintf("/api/v1/operations/%s/events", op.ID),
+	})
+}
+
+// runPreviewOperation is the queue handler for preview operations. It builds
+// the program rollout would run and collects the planned steps from the
+// engine events.
+func (app *App) runPreviewOperation(ctx context.Context, op operations.Operation) (operations.Result, error) {
+	var req RequestBody
+	if err := json.Unmarshal(op.Payload, &req); err != nil {
+		return operations.Result{}, fmt.Errorf("invalid preview operation: %v", err)
+	}
+	progress := app.Operations.Recorder(op.ID)
+
+	var owner, existingTier string
+	err := app.Pool.QueryRow(ctx, `SELECT username, tier FROM deployments WHERE name=$1`, req.Name).Scan(&owner, &existingTier)
+	if err != nil && err != pgx.ErrNoRows {
+		return operations.Result{}, fmt.Errorf("Failed to check existing deployments: %v", err)
+	}
+	exists := err == nil
+	if exists && owner != op.Username {
+		return operations.Result{}, fmt.Errorf("Deployment name already in use by another user")
+	}
+	tier, tierSettings, err := app.deploymentTier(ctx, op.Username, req.Tier, existingTier)
+	if err != nil {
+		return operations.Result{}, err
+	}
+	env, sealedSecrets, err := app.deploymentEnv(ctx, req)
+	if err != nil {
+		return operations.Result{}, err
+	}
+	secretValues, err := openSecrets(sealedSecrets)
+	if err != nil {
+		return operations.Result{}, fmt.Errorf("Failed to decrypt secrets: %v", err)
+	}
+
+	pinnedImage, err := app.pinImage(ctx, req.ContainerImage)
+	if err != nil {
+		return operations.Result{}, fmt.Errorf("Failed to resolve container image: %v", err)
+	}
+	runtimeImage, err := registry.PinPlatform(ctx, pinnedImage, imagecheck.PolicyFromEnv().Platform, app.Credentials.RemoteOptions(ctx)...)
+	if err != nil {
+		return operations.Result{}, fmt.Errorf("Failed to resolve container image for the runtime platform: %v", err)
+	}
+
+	s, err := app.deploymentStack(ctx, op.Username, req.Name, cloudRunProgram(req.Name, runtimeImage.String(), tierSettings, serviceConfig{Env: env, Secrets: secretValues}))
+	if err != nil {
+		return operations.Result{}, err
+	}
+	// A preview of a new deployment must not leave an empty stack behind
+	if !exists {
+		defer func() {
+			if err := s.Workspace().RemoveStack(ctx, s.Name()); err != nil {
+				log.Printf("Failed to remove preview stack %s: %v", s.Name(), err)
+			}
+		}()
+	}
+
+	planEvents := make(chan events.EngineEvent)
+	planned := make(chan []PlannedChange, 1)
+	go func() {
+		planned <- collectPlannedChanges(planEvents)
+	}()
+
+	engineEvents, waitForEvents := engineEventStream(progress)
+	result, err := s.Preview(ctx,
+		optpreview.Refresh(),
+		optpreview.Diff(),
+		optpreview.ProgressStreams(os.Stdout, progress),
+		optpreview.EventStreams(engineEvents, planEvents),
+	)
+	waitForEvents()
+	if err != nil {
+		log.Printf("Preview error: %v", err)
+		return operations.Result{}, fmt.Errorf("Failed to preview stack: %v", err)
+	}
+	// Pulumi closes the event channels once the preview has returned
+	changes := <-planned
+
+	summary := make(map[string]int, len(result.ChangeSummary))
+	for kind, count := range result.ChangeSummary {
+		summary[string(kind)] = count
+	}
+
+	return operations.Result{Data: gin.H{
+		"name":           req.Name,
+		"exists":         exists,
+		"tier":           tier,
+		"env":            env,
+		"secrets":        secretNames(sealedSecrets),
+		"image_digest":   pinnedImage.DigestStr(),
+		"runtime_digest": runtimeImage.DigestStr(),
+		"summary":        summary,
+		"changes":        changes,
+	}}, nil
+}
+
+// collectPlannedChanges reads engine events until the channel is closed and
+// returns the steps that change a resource, in the order they were planned.
+func collectPlannedChanges(engineEvents <-chan events.EngineEvent) []PlannedChange {
+	changes := []PlannedChange{}
+	for event := range engineEvents {
+		if event.ResourcePreEvent == nil {
+			continue
+		}
+		metadata := event.ResourcePreEvent.Metadata
+		if _, ok := stepStatus(metadata.Op); !ok {
+			continue
+		}
+		changes = append(changes, PlannedChange{
+			URN:         metadata.URN,
+			Type:        metadata.Type,
+			Op:          string(metadata.Op),
+			ReplaceKeys: metadata.Keys,
+			Diffs:       propertyChanges(metadata),
+		})
+	}
+	return changes
+}
+
+// propertyChanges lists the property-level diff of a step. Old values are
+// taken from the previous inputs, or from the outputs when the provider
+// compared against the live state.
+func propertyChanges(metadata apitype.StepEventMetadata) []PropertyChange {
+	var oldInputs, oldOutputs, newInputs map[string]any
+	if metadata.Old != nil {
+		oldInputs, oldOutputs = metadata.Old.Inputs, metadata.Old.Outputs
+	}
+	if metadata.New != nil {
+		newInputs = metadata.New.Inputs
+	}
+
+	paths := make([]string, 0, len(metadata.DetailedDiff))
+	for path := range metadata.DetailedDiff {
+		paths = append(paths, path)
+	}
+	// Providers without detailed diffs only report the changed keys
+	if len(paths) == 0 {
+		paths = append(paths, metadata.Diffs...)
+	}
+	sort.Strings(paths)
+
+	changes := make([]PropertyChange, 0, len(paths))
+	for _, path := range paths {
+		change := PropertyChange{Path: path, Kind: string(apitype.DiffUpdate)}
+		old := oldInputs
+		if diff, ok := metadata.DetailedDiff[path]; ok {
+			change.Kind = string(diff.Kind)
+			if !diff.InputDiff {
+				old = oldOutputs
+			}
+		}
+		change.Old = propertyValue(old, path)
+		change.New = propertyValue(newInputs, path)
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// propertyValue looks up a property path such as "template.containers[0].image"
+// in a resource's properties.
+func propertyValue(properties map[string]any, path string) any {
+	if properties == nil {
+		return nil
+	}
+	propertyPath, err := resource.ParsePropertyPath(path)
+	if err != nil {
+		return nil
+	}
+	value, ok := propertyPath.Get(resource.NewPropertyValue(properties))
+	if !ok || value.IsNull() {
+		return nil
+	}
+	return value.Mappable()
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/digizyne/lfcont/internal/data/models"
+	"github.com/digizyne/lfcont/internal/operations"
+	"github.com/digizyne/lfcont/tools"
+)
+
+const OperationRollback = "rollback"
+
+// RollbackRequest selects the revision to restore. Without a revision the
+// newest one running a different image than the current rollout is used.
+type RollbackRequest struct {
+	Name     string `json:"name"`
+	Revision int    `json:"revision"`
+}
+
+// recordRevision appends a revision for a successful rollout of spec and
+// returns its number.
+func (app *App) recordRevision(ctx context.Context, op operations.Operation, spec RequestBody, containerImage, imageDigest, runtimeDigest string, pulumiVersion int, rollbackOf *int) (int, error) {
+	document, err := json.Marshal(spec)
+	if err != nil {
+		return 0, err
+	}
+
+	var revision int
+	err = app.Pool.QueryRow(ctx, `
+		INSERT INTO deployment_revisions (deployment_name, revision, container_image, image_digest, runtime_digest,
+			spec, username, operation_id, pulumi_version, rollback_of)
+		SELECT $1, COALESCE(MAX(revision), 0) + 1, $2, $3, $4, $5, $6, $7, $8, $9
+		FROM deployment_revisions WHERE deployment_name = $1
+		RETURNING revision
+	`, spec.Name, containerImage, imageDigest, runtimeDigest, document, op.Username, op.ID, pulumiVersion, rollbackOf).Scan(&revision)
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("Recorded revision %d of %s", revision, spec.Name)
+	return revision, nil
+}
+
+// authorizeDeployment aborts the request unless the deployment exists and is
+// owned by username.
+func (app *App) authorizeDeployment(c *gin.Context, username, deploymentName string) bool {
+	var owner string
+	err := app.Pool.QueryRow(c.Request.Context(), "SELECT username FROM deployments WHERE name = $1", deploymentName).Scan(&owner)
+	if err == pgx.ErrNoRows {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "deployment not found",
+		})
+		return false
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to load deployment: %v", err),
+		})
+		return false
+	}
+	if owner != username {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": "access denied - deployment belongs to another user",
+		})
+		return false
+	}
+	return true
+}
+
+func (app *App) listDeploymentRevisions(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	deploymentName := c.Param("name")
+	if !app.authorizeDeployment(c, userClaims.Username, deploymentName) {
+		return
+	}
+
+	rows, err := app.Pool.Query(c.Request.Context(), `
+		SELECT revision, container_image, image_digest, runtime_digest, spec, username,
+			operation_id::text, pulumi_version, rollback_of, created_at
+		FROM deployment_revisions
+		WHERE deployment_name = $1
+		ORDER BY revision DESC
+	`, deploymentName)
+	if err != nil {
+		log.Printf("Error querying revisions of %s: %v", deploymentName, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to query deployment revisions",
+		})
+		return
+	}
+	revisions, err := pgx.CollectRows(rows, pgx.RowToStructByPos[models.DeploymentRevision])
+	if err != nil {
+		log.Printf("Error reading revisions of %s: %v", deploymentName, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to read deployment revisions",
+		})
+		return
+	}
+
+	// Specs carry the sealed secrets for rollbacks; only their names are shown
+	for i := range revisions {
+		var spec RequestBody
+		if err := json.Unmarshal(revisions[i].Spec, &spec); err != nil {
+			log.Printf("Error reading spec of revision %d of %s: %v", revisions[i].Revision, deploymentName, err)
+			continue
+		}
+		revisions[i].Spec, _ = json.Marshal(gin.H{
+			"name":            spec.Name,
+			"container_image": spec.ContainerImage,
+			"tier":            spec.Tier,
+			"env":             spec.Env,
+			"secrets":         secretNames(spec.SealedSecrets),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"revisions": revisions,
+	})
+}
+
+// rollbackDeployment queues a rollout of the spec recorded with an earlier
+// revision. The rollout is recorded as a new revision.
+func (app *App) rollbackDeployment(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	// The body is optional
+	var req RollbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(400, gin.H{
+			"error":   "invalid request payload",
+			"message": err.Error(),
+		})
+		return
+	}
+	req.Name = c.Param("name")
+	ctx := c.Request.Context()
+
+	if !app.authorizeDeployment(c, userClaims.Username, req.Name) {
+		return
+	}
+
+	active, err := app.Operations.Active(ctx, req.Name)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to check pending operations: %v", err),
+		})
+		return
+	}
+	if active {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error": "another operation on this deployment is queued or running",
+		})
+		return
+	}
+
+	if req.Revision == 0 {
+		err = app.Pool.QueryRow(ctx, `
+			SELECT r.revision
+			FROM deployment_revisions r
+			JOIN deployments d ON d.name = r.deployment_name
+			WHERE r.deployment_name = $1 AND r.image_digest IS DISTINCT FROM d.image_digest
+			ORDER BY r.revision DESC
+			LIMIT 1
+		`, req.Name).Scan(&req.Revision)
+	} else {
+		err = app.Pool.QueryRow(ctx, `
+			SELECT revision FROM deployment_revisions WHERE deployment_name = $1 AND revision = $2
+		`, req.Name, req.Revision).Scan(&req.Revision)
+	}
+	if err == pgx.ErrNoRows {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "no revision to roll back to",
+		})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to load revision: %v", err),
+		})
+		return
+	}
+
+	op, err := app.Operations.Enqueue(ctx, OperationRollback, req.Name, userClaims.Username, req)
+	if err != nil {
+		log.Printf("Failed to queue rollback: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to queue rollback: %v", err),
+		})
+		return
+	}
+
+	log.Printf("Queued rollback operation %s of %s to revision %d", op.ID, req.Name, req.Revision)
+	c.JSON(http.StatusAccepted, gin.H{
+		"operation_id": op.ID,
+		"revision":     req.Revision,
+		"status_url":   fmt.Sprintf("/api/v1/operations/%s", op.ID),
+		"events_url":   fmt.Sprintf("/api/v1/operations/%s/events", op.ID),
+	})
+}
+
+// runRollbackOperation is the queue handler for rollback operations.
+func (app *App) runRollbackOperation(ctx context.Context, op operations.Operation) (operations.Result, error) {
+	var req RollbackRequest
+	if err := json.Unmarshal(op.Payload, &req); err != nil {
+		return operations.Result{}, fmt.Errorf("invalid rollback operation: %v", err)
+	}
+
+	var document []byte
+	err := app.Pool.QueryRow(ctx, `
+		SELECT spec FROM deployment_revisions WHERE deployment_name = $1 AND revision = $2
+	`, req.Name, req.Revision).Scan(&document)
+	if err == pgx.ErrNoRows {
+		return operations.Result{}, fmt.Errorf("revision %d of %s not found", req.Revision, req.Name)
+	}
+	if err != nil {
+		return operations.Result{}, err
+	}
+	var spec RequestBody
+	if err := json.Unmarshal(document, &spec); err != nil {
+		return operations.Result{}, fmt.Errorf("invalid spec of revision %d: %v", req.Revision, err)
+	}
+	spec.Name = req.Name
+
+	result, err := app.rollout(ctx, op, spec, &req.Revision)
+	if err != nil {
+		return operations.Result{}, err
+	}
+	result["rollback_of"] = req.Revision
+	url, _ := result["service_url"].(string)
+	return operations.Result{URL: url, Data: result}, nil
+}
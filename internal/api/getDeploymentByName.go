@@ -12,9 +12,11 @@ import (
 	run "cloud.google.com/go/run/apiv2"
 	"cloud.google.com/go/run/apiv2/runpb"
 	"github.com/gin-gonic/gin"
+	"google.golang.org/api/option"
 	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
+	"github.com/digizyne/lfcont/internal/vuln"
 	"github.com/digizyne/lfcont/tools"
 )
 
@@ -28,6 +30,17 @@ type CloudRunServiceDetails struct {
 	UpdatedTime string         `json:"updated_time"`
 	Scaling     ServiceScaling `json:"scaling"`
 	Metrics     ServiceMetrics `json:"metrics"`
+
+	// RequestedImage is the reference the client deployed and ImageDigest the
+	// manifest digest it resolved to at deploy time.
+	RequestedImage string `json:"requested_image"`
+	ImageDigest    string `json:"image_digest"`
+
+	// Env are the plain variables; of secrets only the names are returned.
+	Env     map[string]string `json:"env"`
+	Secrets []string          `json:"secrets"`
+
+	Vulnerabilities *vuln.Counts `json:"vulnerabilities,omitempty"`
 }
 
 type ServiceScaling struct {
@@ -66,8 +79,14 @@ func (app *App) getDeploymentByName(c *gin.Context) {
 
 	// Verify the deployment belongs to the authenticated user
 	dbCtx := c.Request.Context()
-	var dbUsername string
-	err = app.Pool.QueryRow(dbCtx, "SELECT username FROM deployments WHERE name = $1", deploymentName).Scan(&dbUsername)
+	var dbUsername, dbContainerImage, dbImageDigest string
+	var dbEnv map[string]string
+	var dbSecrets []string
+	err = app.Pool.QueryRow(dbCtx, `
+		SELECT username, container_image, COALESCE(image_digest, ''), env,
+			ARRAY(SELECT s.key FROM deployment_secrets s WHERE s.deployment_name = d.name ORDER BY s.key)
+		FROM deployments d WHERE name = $1
+	`, deploymentName).Scan(&dbUsername, &dbContainerImage, &dbImageDigest, &dbEnv, &dbSecrets)
 	if err != nil {
 		log.Printf("Error finding deployment %s: %v", deploymentName, err)
 		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
@@ -84,7 +103,7 @@ func (app *App) getDeploymentByName(c *gin.Context) {
 	}
 
 	// Create Cloud Run client
-	runClient, err := run.NewServicesClient(ctx)
+	runClient, err := run.NewServicesClient(ctx, app.Credentials.ClientOptions()...)
 	if err != nil {
 		log.Printf("Failed to create Cloud Run client: %v", err)
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
@@ -131,7 +150,7 @@ func (app *App) getDeploymentByName(c *gin.Context) {
 	}
 
 	// Get metrics from Cloud Monitoring
-	metrics, err := getServiceMetrics(ctx, projectID, location, deploymentName)
+	metrics, err := getServiceMetrics(ctx, projectID, location, deploymentName, app.Credentials.ClientOptions()...)
 	if err != nil {
 		log.Printf("Failed to get metrics for %s: %v", deploymentName, err)
 		// Don't fail the request, just return empty metrics
@@ -153,8 +172,19 @@ func (app *App) getDeploymentByName(c *gin.Context) {
 			MinInstances: minInstances,
 			MaxInstances: maxInstances,
 		},
-		Metrics: metrics,
+		Metrics:        metrics,
+		RequestedImage: dbContainerImage,
+		ImageDigest:    dbImageDigest,
+		Env:            dbEnv,
+		Secrets:        dbSecrets,
+	}
+
+	// Attach known vulnerabilities of the deployed image
+	vulnerabilities, err := app.imageVulnerabilities(dbCtx, dbContainerImage)
+	if err != nil {
+		log.Printf("Failed to get vulnerabilities for %s: %v", dbContainerImage, err)
 	}
+	details.Vulnerabilities = vulnerabilities
 
 	// Determine status
 	if len(service.Conditions) > 0 {
@@ -178,9 +208,9 @@ func (app *App) getDeploymentByName(c *gin.Context) {
 	c.JSON(http.StatusOK, details)
 }
 
-func getServiceMetrics(ctx context.Context, projectID, location, serviceName string) (ServiceMetrics, error) {
+func getServiceMetrics(ctx context.Context, projectID, location, serviceName string, opts ...option.ClientOption) (ServiceMetrics, error) {
 	// Create monitoring client
-	monitoringClient, err := monitoring.NewMetricClient(ctx)
+	monitoringClient, err := monitoring.NewMetricClient(ctx, opts...)
 	if err != nil {
 		return ServiceMetrics{}, fmt.Errorf("failed to create monitoring client: %w", err)
 	}
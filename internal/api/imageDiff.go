@@ -0,0 +1,130 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/digizyne/lfcont/internal/imagediff"
+	"github.com/digizyne/lfcont/internal/imagefs"
+	"github.com/digizyne/lfcont/tools"
+)
+
+// maxFileChanges bounds the file list of a diff response.
+const maxFileChanges = 5000
+
+func (app *App) diffContainerImages(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	fromRef, toRef := c.Query("from"), c.Query("to")
+	if fromRef == "" || toRef == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "from and to are required",
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	from, err := app.ownedImage(ctx, userClaims.Username, fromRef)
+	if err != nil {
+		abortOnImageError(c, err)
+		return
+	}
+	to, err := app.ownedImage(ctx, userClaims.Username, toRef)
+	if err != nil {
+		abortOnImageError(c, err)
+		return
+	}
+
+	// Indexing reads every layer, so both images are indexed concurrently
+	var fromFS, toFS *imagefs.FS
+	g := new(errgroup.Group)
+	g.Go(func() (err error) {
+		fromFS, err = app.ImageFS.Index(from)
+		return err
+	})
+	g.Go(func() (err error) {
+		toFS, err = app.ImageFS.Index(to)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		log.Printf("Error indexing images for diff: %v", err)
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{
+			"error": fmt.Sprintf("Failed to read image layers: %v", err),
+		})
+		return
+	}
+
+	var toConfig *v1.ConfigFile
+	var toManifest *v1.Manifest
+	var toDigest v1.Hash
+	fromConfig, fromManifest, fromDigest, err := imageMetadata(from)
+	if err == nil {
+		toConfig, toManifest, toDigest, err = imageMetadata(to)
+	}
+	if err != nil {
+		log.Printf("Error reading image metadata for diff: %v", err)
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{
+			"error": fmt.Sprintf("Failed to read image metadata: %v", err),
+		})
+		return
+	}
+
+	files := imagediff.Files(fromFS, toFS)
+	filesTruncated := len(files) > maxFileChanges
+	if filesTruncated {
+		files = files[:maxFileChanges]
+	}
+
+	// Package differences are only available when both images have an SBOM
+	var packages []imagediff.PackageChange
+	fromPkgs, err := app.storedPackages(ctx, fromDigest.String())
+	if err != nil {
+		log.Printf("Error loading SBOM for %s: %v", fromRef, err)
+	}
+	toPkgs, err := app.storedPackages(ctx, toDigest.String())
+	if err != nil {
+		log.Printf("Error loading SBOM for %s: %v", toRef, err)
+	}
+	if fromPkgs != nil && toPkgs != nil {
+		packages = imagediff.Packages(fromPkgs, toPkgs)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":            gin.H{"fqin": fromRef, "digest": fromDigest.String()},
+		"to":              gin.H{"fqin": toRef, "digest": toDigest.String()},
+		"config":          imagediff.Config(fromConfig, toConfig),
+		"layers":          imagediff.Layers(fromManifest, toManifest),
+		"files":           files,
+		"files_truncated": filesTruncated,
+		"packages":        packages,
+	})
+}
+
+func imageMetadata(img v1.Image) (*v1.ConfigFile, *v1.Manifest, v1.Hash, error) {
+	config, err := img.ConfigFile()
+	if err != nil {
+		return nil, nil, v1.Hash{}, err
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, nil, v1.Hash{}, err
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return nil, nil, v1.Hash{}, err
+	}
+	return config, manifest, digest, nil
+}
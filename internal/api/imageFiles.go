@@ -0,0 +1,241 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"github.com/digizyne/lfcont/internal/imagefs"
+	"github.com/digizyne/lfcont/internal/registry"
+	"github.com/digizyne/lfcont/tools"
+)
+
+const (
+	defaultMaxBrowseFileSize = 1 << 20
+	maxListedEntries         = 1000
+)
+
+var (
+	errImageNotFound = errors.New("container image not found")
+	errImageIndex    = errors.New("container image is a multi-arch index; derived images can only be built from single-platform images")
+)
+
+// ownedImage fetches fqin from the registry after checking that username
+// pushed it.
+func (app *App) ownedImage(ctx context.Context, username, fqin string) (v1.Image, error) {
+	if err := app.checkImageOwner(ctx, username, fqin); err != nil {
+		return nil, err
+	}
+	ref, err := name.ParseReference(fqin)
+	if err != nil {
+		return nil, err
+	}
+	return registry.Resolve(ctx, ref, registry.DefaultPlatform, app.Credentials.RemoteOptions(ctx)...)
+}
+
+// ownedPlatformImage is ownedImage for endpoints that derive a new image from
+// fqin. A multi-arch index is rejected rather than narrowed to one platform,
+// which would silently drop the others from the derived image.
+func (app *App) ownedPlatformImage(ctx context.Context, username, fqin string) (v1.Image, error) {
+	if err := app.checkImageOwner(ctx, username, fqin); err != nil {
+		return nil, err
+	}
+	ref, err := name.ParseReference(fqin)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := remote.Get(ref, app.Credentials.RemoteOptions(ctx)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %v", ref, err)
+	}
+	if desc.MediaType.IsIndex() {
+		return nil, errImageIndex
+	}
+	return desc.Image()
+}
+
+// checkImageOwner returns errImageNotFound unless username pushed ref. A
+// digest reference is owned when one of the user's images in that repository
+// has the digest.
+func (app *App) checkImageOwner(ctx context.Context, username, ref string) error {
+	parsed, err := name.ParseReference(ref)
+	if err != nil {
+		return errImageNotFound
+	}
+	var owned bool
+	if digest, ok := parsed.(name.Digest); ok {
+		err = app.Pool.QueryRow(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM container_images
+				WHERE username = $1 AND digest = $2 AND starts_with(fqin, $3 || ':')
+			)
+		`, username, digest.DigestStr(), digest.Context().Name()).Scan(&owned)
+	} else {
+		err = app.Pool.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM container_images WHERE username = $1 AND fqin = $2)
+		`, username, ref).Scan(&owned)
+	}
+	if err != nil {
+		return err
+	}
+	if !owned {
+		return errImageNotFound
+	}
+	return nil
+}
+
+// abortOnImageError writes the response for an error from ownedImage.
+func abortOnImageError(c *gin.Context, err error) {
+	if errors.Is(err, errImageNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if errors.Is(err, errImageIndex) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	log.Printf("Error fetching container image: %v", err)
+	c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{
+		"error": fmt.Sprintf("Failed to fetch container image: %v", err),
+	})
+}
+
+func maxBrowseFileSize() int64 {
+	if limit, err := strconv.ParseInt(os.Getenv("BROWSE_MAX_FILE_SIZE"), 10, 64); err == nil && limit > 0 {
+		return limit
+	}
+	return defaultMaxBrowseFileSize
+}
+
+// listImageFiles lists a directory of the image's flattened filesystem. With
+// ?layers=true each entry also names the layer that introduced it.
+func (app *App) listImageFiles(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	fqin := c.Param("fqin")
+	dir := c.DefaultQuery("path", "/")
+	showLayers := c.Query("layers") == "true"
+
+	img, err := app.ownedImage(c.Request.Context(), userClaims.Username, fqin)
+	if err != nil {
+		abortOnImageError(c, err)
+		return
+	}
+	fsys, err := app.ImageFS.Index(img)
+	if err != nil {
+		log.Printf("Error indexing %s: %v", fqin, err)
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{
+			"error": fmt.Sprintf("Failed to read image layers: %v", err),
+		})
+		return
+	}
+
+	entries, err := fsys.List(dir)
+	switch {
+	case errors.Is(err, imagefs.ErrNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("%s: %v", dir, err)})
+		return
+	case errors.Is(err, imagefs.ErrNotDir):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s: %v", dir, err)})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	truncated := len(entries) > maxListedEntries
+	if truncated {
+		entries = entries[:maxListedEntries]
+	}
+	if !showLayers {
+		for i := range entries {
+			entries[i].Layer = ""
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"path":      dir,
+		"entries":   entries,
+		"truncated": truncated,
+	})
+}
+
+// getImageFile returns the contents of one file, following symlinks. The
+// layer that provided the file is reported in the X-Image-Layer header.
+func (app *App) getImageFile(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	fqin := c.Param("fqin")
+	file := c.Query("path")
+	if file == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "path is required"})
+		return
+	}
+
+	img, err := app.ownedImage(c.Request.Context(), userClaims.Username, fqin)
+	if err != nil {
+		abortOnImageError(c, err)
+		return
+	}
+	fsys, err := app.ImageFS.Index(img)
+	if err != nil {
+		log.Printf("Error indexing %s: %v", fqin, err)
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{
+			"error": fmt.Sprintf("Failed to read image layers: %v", err),
+		})
+		return
+	}
+
+	limit := maxBrowseFileSize()
+	data, entry, err := fsys.ReadFile(file, limit)
+	switch {
+	case errors.Is(err, imagefs.ErrNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("%s: %v", file, err)})
+		return
+	case errors.Is(err, imagefs.ErrNotFile):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s: %v", file, err)})
+		return
+	case errors.Is(err, imagefs.ErrFileTooBig):
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("%s is %d bytes; the limit is %d", file, entry.Size, limit),
+		})
+		return
+	case err != nil:
+		log.Printf("Error reading %s from %s: %v", file, fqin, err)
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("Failed to read file: %v", err)})
+		return
+	}
+
+	c.Header("X-Image-Layer", entry.Layer)
+	c.Header("X-Image-Path", entry.Path)
+	c.Data(http.StatusOK, "application/octet-stream", data)
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"github.com/digizyne/lfcont/internal/jobs"
+	"github.com/digizyne/lfcont/internal/quota"
+	"github.com/digizyne/lfcont/internal/registry"
+	"github.com/digizyne/lfcont/tools"
+)
+
+type ImportImageRequest struct {
+	Source   string `json:"source" binding:"required"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Platform string `json:"platform"`
+}
+
+func (app *App) importContainerImage(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	var req ImportImageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{
+			"error":   "invalid request payload",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	src, err := name.ParseReference(req.Source)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("invalid source reference: %v", err),
+		})
+		return
+	}
+
+	platform := registry.DefaultPlatform
+	if req.Platform != "" {
+		p, err := v1.ParsePlatform(req.Platform)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("invalid platform: %v", err),
+			})
+			return
+		}
+		platform = *p
+	}
+
+	if (req.Username == "") != (req.Password == "") {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "username and password must be provided together",
+		})
+		return
+	}
+	// The controller's credentials are never sent to a registry the caller
+	// picked; sources are read anonymously or with the given credentials.
+	srcOpts := []remote.Option{remote.WithAuth(authn.Anonymous)}
+	if req.Username != "" {
+		srcOpts = []remote.Option{remote.WithAuth(&authn.Basic{Username: req.Username, Password: req.Password})}
+	}
+	// Images in the controller's own repository are only readable by the
+	// user who pushed them
+	if inControllerRepo(src) {
+		if err := app.checkImageOwner(c.Request.Context(), userClaims.Username, src.String()); err != nil {
+			abortOnImageError(c, err)
+			return
+		}
+		srcOpts = app.Credentials.RemoteOptions(c.Request.Context())
+	}
+
+	dst, err := name.ParseReference(newTargetTag(path.Base(src.Context().RepositoryStr())))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to build target reference: %v", err),
+		})
+		return
+	}
+
+	// Only the manifests are fetched here; they give the size for the quota
+	// check and the digest the job copies, whatever the tag points at later
+	img, err := registry.Resolve(c.Request.Context(), src, platform, srcOpts...)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{
+			"error": fmt.Sprintf("import failed: %v", err),
+		})
+		return
+	}
+	size, err := quota.ImageSize(img)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{
+			"error": fmt.Sprintf("failed to compute image size: %v", err),
+		})
+		return
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{
+			"error": fmt.Sprintf("import failed: %v", err),
+		})
+		return
+	}
+	if abortOnQuota(c, app.checkStorageQuota(c.Request.Context(), userClaims.Username, size)) {
+		return
+	}
+	pinned := src.Context().Digest(digest.String())
+
+	username := userClaims.Username
+	job, err := app.Jobs.Submit("import", username, func(ctx context.Context, r *jobs.Reporter) (any, error) {
+		r.Stage("resolving source image")
+		img, err := registry.Resolve(ctx, pinned, platform, srcOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("import failed: %v", err)
+		}
+
+		result, err := app.publishImage(ctx, r, dst, img, username)
+		if err != nil {
+			return result, err
+		}
+
+		log.Printf("Imported %s as %s (%s)", src, dst, result["digest"])
+		result["source"] = src.String()
+		return result, nil
+	})
+	if abortOnSubmit(c, err) {
+		return
+	}
+
+	log.Printf("Queued import job %s for user %s", job.ID, username)
+	c.JSON(http.StatusAccepted, gin.H{
+		"job_id":     job.ID,
+		"status_url": fmt.Sprintf("/api/v1/container-images/jobs/%s", job.ID),
+		"events_url": fmt.Sprintf("/api/v1/container-images/jobs/%s/events", job.ID),
+	})
+}
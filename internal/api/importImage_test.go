@@ -0,0 +1,224 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"github.com/digizyne/lfcont/internal/credentials"
+	"github.com/digizyne/lfcont/internal/data"
+	"github.com/digizyne/lfcont/internal/jobs"
+	"github.com/digizyne/lfcont/tools"
+)
+
+// authRecorder remembers the Authorization headers sent to a registry.
+type authRecorder struct {
+	next    http.Handler
+	mu      sync.Mutex
+	headers []string
+}
+
+func (a *authRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		a.mu.Lock()
+		a.headers = append(a.headers, header)
+		a.mu.Unlock()
+	}
+	a.next.ServeHTTP(w, r)
+}
+
+var quietRegistry = ggcrregistry.Logger(log.New(io.Discard, "", 0))
+
+// TestImportContainerImage runs the import handler against two in-process
+// registries: a third-party source and the controller's own repository. It
+// needs a scratch database in TEST_POSTGRES_CONNECTION_STRING.
+func TestImportContainerImage(t *testing.T) {
+	connectionString := os.Getenv("TEST_POSTGRES_CONNECTION_STRING")
+	if connectionString == "" {
+		t.Skip("TEST_POSTGRES_CONNECTION_STRING is not set")
+	}
+	t.Setenv("POSTGRES_CONNECTION_STRING", connectionString)
+	pool, err := data.InitializeDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	source := &authRecorder{next: ggcrregistry.New(quietRegistry)}
+	sourceServer := httptest.NewServer(source)
+	defer sourceServer.Close()
+	controllerServer := httptest.NewServer(ggcrregistry.New(quietRegistry))
+	defer controllerServer.Close()
+	sourceHost := strings.TrimPrefix(sourceServer.URL, "http://")
+	controllerHost := strings.TrimPrefix(controllerServer.URL, "http://")
+
+	repoURL := controllerHost + "/images"
+	t.Setenv("AR_REPO_URL", repoURL)
+	t.Setenv("JWT_SECRET", "import-test")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := &App{
+		Pool:        pool,
+		Credentials: credentials.NewRegistryProvider(credentials.SourceBasic, controllerHost, &authn.Basic{Username: "controller", Password: "secret"}),
+		Jobs:        jobs.NewManager(ctx, 1, time.Hour),
+	}
+	router := gin.New()
+	router.POST("/import", app.importContainerImage)
+
+	username := fmt.Sprintf("import-test-%d", time.Now().UnixNano())
+	if _, err := pool.Exec(ctx, `INSERT INTO users (username, password_hash) VALUES ($1, '')`, username); err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Exec(context.Background(), `DELETE FROM container_images WHERE username = $1`, username)
+	defer pool.Exec(context.Background(), `DELETE FROM users WHERE username = $1`, username)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, tools.UserClaims{
+		Username: username,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}).SignedString([]byte("import-test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	importImage := func(source string) (int, gin.H) {
+		body := strings.NewReader(fmt.Sprintf(`{"source": %q}`, source))
+		req := httptest.NewRequest(http.MethodPost, "/import", body)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var response gin.H
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+		}
+		return w.Code, response
+	}
+	waitForJob := func(id string) jobs.Job {
+		deadline := time.Now().Add(30 * time.Second)
+		for time.Now().Before(deadline) {
+			if job, ok := app.Jobs.Get(id); ok && job.Finished() {
+				return job
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("job %s did not finish", id)
+		return jobs.Job{}
+	}
+
+	img := testImage(t)
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := name.ParseReference(sourceHost + "/library/app:1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(third, img); err != nil {
+		t.Fatal(err)
+	}
+
+	// A third-party image is copied without the controller's credentials
+	status, response := importImage(third.String())
+	if status != http.StatusAccepted {
+		t.Fatalf("import of %s: status %d, %v", third, status, response)
+	}
+	job := waitForJob(response["job_id"].(string))
+	if job.State != jobs.StateSucceeded {
+		t.Fatalf("import of %s failed: %s", third, job.Error)
+	}
+	result := job.Result.(gin.H)
+	imported, err := name.ParseReference(result["fqin"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(imported.String(), repoURL+"/app:") {
+		t.Errorf("imported as %s, want a tag in %s/app", imported, repoURL)
+	}
+	desc, err := remote.Head(imported)
+	if err != nil {
+		t.Fatalf("imported image is missing: %v", err)
+	}
+	if desc.Digest != digest {
+		t.Errorf("imported digest %s, want %s", desc.Digest, digest)
+	}
+	if len(source.headers) > 0 {
+		t.Errorf("source registry received credentials: %v", source.headers)
+	}
+
+	// Images in the controller's repository need an owner
+	foreign, err := name.ParseReference(repoURL + "/other:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(foreign, img); err != nil {
+		t.Fatal(err)
+	}
+	if status, response := importImage(foreign.String()); status != http.StatusNotFound {
+		t.Errorf("import of an image pushed by someone else: status %d, %v", status, response)
+	}
+
+	status, response = importImage(imported.String())
+	if status != http.StatusAccepted {
+		t.Fatalf("import of an owned image: status %d, %v", status, response)
+	}
+	if job := waitForJob(response["job_id"].(string)); job.State != jobs.StateSucceeded {
+		t.Errorf("import of an owned image failed: %s", job.Error)
+	}
+
+	// An owned image can also be named by digest
+	byDigest := imported.Context().Digest(digest.String())
+	status, response = importImage(byDigest.String())
+	if status != http.StatusAccepted {
+		t.Fatalf("import of an owned image by digest: status %d, %v", status, response)
+	}
+	if job := waitForJob(response["job_id"].(string)); job.State != jobs.StateSucceeded {
+		t.Errorf("import of an owned image by digest failed: %s", job.Error)
+	}
+	if status, response := importImage(foreign.Context().Digest(digest.String()).String()); status != http.StatusNotFound {
+		t.Errorf("import by digest from a repository of someone else: status %d, %v", status, response)
+	}
+}
+
+// testImage returns a random image that passes the runtime checks.
+func testImage(t *testing.T) v1.Image {
+	t.Helper()
+	img, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg = cfg.DeepCopy()
+	cfg.OS, cfg.Architecture = "linux", "amd64"
+	cfg.Config.Cmd = []string{"/app"}
+	cfg.Config.User = "65532"
+	img, err = mutate.ConfigFile(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
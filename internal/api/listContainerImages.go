@@ -0,0 +1,155 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/digizyne/lfcont/internal/data/models"
+	"github.com/digizyne/lfcont/internal/vuln"
+	"github.com/digizyne/lfcont/tools"
+)
+
+type ContainerImageResponse struct {
+	Fqin            string       `json:"fqin"`
+	Digest          string       `json:"digest"`
+	Username        string       `json:"username"`
+	Vulnerabilities *vuln.Counts `json:"vulnerabilities"`
+
+	Platforms []models.ImagePlatform `json:"platforms,omitempty"`
+}
+
+type PaginatedContainerImagesResponse struct {
+	ContainerImages []ContainerImageResponse `json:"container_images"`
+	Total           int                      `json:"total"`
+	Page            int                      `json:"page"`
+	Limit           int                      `json:"limit"`
+	TotalPages      int                      `json:"total_pages"`
+}
+
+func (app *App) listContainerImages(c *gin.Context) {
+	// Extract user claims for authentication and filtering
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	// Parse pagination parameters
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 10 // Default limit with max of 100
+	}
+
+	offset := (page - 1) * limit
+
+	// Users can only see their own images
+	whereConditions := []string{"username = $1"}
+	args := []interface{}{userClaims.Username}
+	argIndex := 2
+
+	if search := c.Query("search"); search != "" {
+		whereConditions = append(whereConditions, fmt.Sprintf("LOWER(fqin) LIKE $%d", argIndex))
+		args = append(args, "%"+strings.ToLower(search)+"%")
+		argIndex++
+	}
+
+	whereClause := "WHERE " + strings.Join(whereConditions, " AND ")
+
+	// Get total count for pagination
+	var totalCount int
+	err = app.Pool.QueryRow(ctx, fmt.Sprintf("SELECT COUNT(*) FROM container_images %s", whereClause), args...).Scan(&totalCount)
+	if err != nil {
+		log.Printf("Error counting container images: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to count container images",
+		})
+		return
+	}
+
+	query := fmt.Sprintf(`
+		SELECT fqin, COALESCE(digest, ''), username, platforms
+		FROM container_images
+		%s
+		ORDER BY fqin ASC
+		LIMIT $%d OFFSET $%d
+	`, whereClause, argIndex, argIndex+1)
+	args = append(args, limit, offset)
+
+	rows, err := app.Pool.Query(ctx, query, args...)
+	if err != nil {
+		log.Printf("Error querying container images: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to query container images",
+		})
+		return
+	}
+	defer rows.Close()
+
+	images := []ContainerImageResponse{}
+	var digests []string
+	for rows.Next() {
+		var image ContainerImageResponse
+		if err := rows.Scan(&image.Fqin, &image.Digest, &image.Username, &image.Platforms); err != nil {
+			log.Printf("Error scanning container image row: %v", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to parse container image data",
+			})
+			return
+		}
+		images = append(images, image)
+		if image.Digest != "" {
+			digests = append(digests, image.Digest)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating container image rows: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to read container image data",
+		})
+		return
+	}
+
+	counts, err := vuln.CountsByDigest(ctx, app.Pool, digests)
+	if err != nil {
+		log.Printf("Error counting vulnerabilities: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to count vulnerabilities",
+		})
+		return
+	}
+	for i := range images {
+		if images[i].Digest == "" {
+			continue
+		}
+		imageCounts := counts[images[i].Digest]
+		images[i].Vulnerabilities = &imageCounts
+	}
+
+	totalPages := (totalCount + limit - 1) / limit // Ceiling division
+
+	log.Printf("User %s retrieved %d container images (page %d/%d)", userClaims.Username, len(images), page, totalPages)
+
+	c.JSON(http.StatusOK, PaginatedContainerImagesResponse{
+		ContainerImages: images,
+		Total:           totalCount,
+		Page:            page,
+		Limit:           limit,
+		TotalPages:      totalPages,
+	})
+}
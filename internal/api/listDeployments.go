@@ -17,7 +17,11 @@ type DeploymentResponse struct {
 	URL            string `json:"url"`
 	Tier           string `json:"tier"`
 	ContainerImage string `json:"container_image"`
+	ImageDigest    string `json:"image_digest"`
 	Username       string `json:"username"`
+	// Env are the plain variables; of secrets only the names are listed.
+	Env     map[string]string `json:"env"`
+	Secrets []string          `json:"secrets"`
 }
 
 type PaginatedDeploymentsResponse struct {
@@ -115,7 +119,8 @@ func (app *App) listDeployments(c *gin.Context) {
 
 	// Get deployments with pagination
 	query := fmt.Sprintf(`
-		SELECT name, url, tier, container_image, username 
+		SELECT name, url, tier, container_image, COALESCE(image_digest, ''), username, env,
+			ARRAY(SELECT s.key FROM deployment_secrets s WHERE s.deployment_name = deployments.name ORDER BY s.key)
 		FROM deployments 
 		%s 
 		ORDER BY name ASC 
@@ -143,7 +148,10 @@ func (app *App) listDeployments(c *gin.Context) {
 			&deployment.URL,
 			&deployment.Tier,
 			&deployment.ContainerImage,
+			&deployment.ImageDigest,
 			&deployment.Username,
+			&deployment.Env,
+			&deployment.Secrets,
 		)
 		if err != nil {
 			log.Printf("Error scanning deployment row: %v", err)
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/digizyne/lfcont/internal/data/models"
+	"github.com/digizyne/lfcont/internal/imageconfig"
+	"github.com/digizyne/lfcont/internal/jobs"
+	"github.com/digizyne/lfcont/tools"
+)
+
+const DerivationConfig = "config"
+
+type MutateImageRequest struct {
+	// Tag is the new tag in the source image's repository.
+	Tag string `json:"tag" binding:"required"`
+	imageconfig.Changes
+}
+
+// recordLineage links a derived image to the image it was created from.
+func (app *App) recordLineage(ctx context.Context, fqin, parentFqin, parentDigest, derivation string) error {
+	_, err := app.Pool.Exec(ctx, `
+		UPDATE container_images
+		SET parent_fqin = $2, parent_digest = $3, derivation = $4
+		WHERE fqin = $1
+	`, fqin, parentFqin, parentDigest, derivation)
+	if err != nil {
+		return fmt.Errorf("failed to record image lineage: %v", err)
+	}
+	return nil
+}
+
+// derivedTag resolves the tag for an image derived from fqin and makes sure it
+// is not taken yet.
+func (app *App) derivedTag(ctx context.Context, fqin, tag string) (name.Tag, error) {
+	ref, err := name.ParseReference(fqin)
+	if err != nil {
+		return name.Tag{}, err
+	}
+	dst, err := name.NewTag(ref.Context().String()+":"+tag, name.StrictValidation)
+	if err != nil {
+		return name.Tag{}, fmt.Errorf("invalid tag %q: %v", tag, err)
+	}
+	var exists bool
+	err = app.Pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM container_images WHERE fqin = $1)", dst.String()).Scan(&exists)
+	if err != nil {
+		return name.Tag{}, err
+	}
+	if exists {
+		return name.Tag{}, fmt.Errorf("%s already exists", dst)
+	}
+	return dst, nil
+}
+
+// mutateContainerImage derives a new image from an owned one by changing its
+// config or manifest annotations. The layers are shared with the source, so
+// only the new config and manifest are uploaded.
+func (app *App) mutateContainerImage(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	var req MutateImageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{
+			"error":   "invalid request payload",
+			"message": err.Error(),
+		})
+		return
+	}
+	if req.Changes.Empty() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "no changes requested",
+		})
+		return
+	}
+	if err := req.Changes.Validate(); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	fqin := c.Param("fqin")
+	ctx := c.Request.Context()
+	source, err := app.ownedPlatformImage(ctx, userClaims.Username, fqin)
+	if err != nil {
+		abortOnImageError(c, err)
+		return
+	}
+	sourceDigest, err := source.Digest()
+	if err != nil {
+		abortOnImageError(c, err)
+		return
+	}
+
+	dst, err := app.derivedTag(ctx, fqin, req.Tag)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	username := userClaims.Username
+	changes := req.Changes
+	job, err := app.Jobs.Submit("mutate", username, func(ctx context.Context, r *jobs.Reporter) (any, error) {
+		r.Stage("mutating config")
+		img, err := imageconfig.Apply(source, changes)
+		if err != nil {
+			return nil, fmt.Errorf("mutation failed: %v", err)
+		}
+
+		result, err := app.publishImage(ctx, r, dst, img, username)
+		if err != nil {
+			return result, err
+		}
+		if err := app.recordLineage(ctx, dst.String(), fqin, sourceDigest.String(), DerivationConfig); err != nil {
+			return nil, err
+		}
+		if err := app.inheritBase(ctx, dst.String(), fqin); err != nil {
+			return nil, err
+		}
+
+		log.Printf("Derived %s from %s for %s", dst, fqin, username)
+		result["parent_fqin"] = fqin
+		result["parent_digest"] = sourceDigest.String()
+		return result, nil
+	})
+	if abortOnSubmit(c, err) {
+		return
+	}
+
+	log.Printf("Queued mutate job %s for user %s", job.ID, username)
+	c.JSON(http.StatusAccepted, gin.H{
+		"job_id":     job.ID,
+		"status_url": fmt.Sprintf("/api/v1/container-images/jobs/%s", job.ID),
+		"events_url": fmt.Sprintf("/api/v1/container-images/jobs/%s/events", job.ID),
+	})
+}
+
+// getContainerImageLineage lists fqin followed by the images it was derived
+// from, nearest first.
+func (app *App) getContainerImageLineage(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	fqin := c.Param("fqin")
+	rows, err := app.Pool.Query(c.Request.Context(), `
+		WITH RECURSIVE lineage AS (
+			SELECT fqin, digest, username, size_bytes, created_at, parent_fqin, parent_digest, derivation, platforms, 0 AS depth
+			FROM container_images
+			WHERE fqin = $1 AND username = $2
+			UNION ALL
+			SELECT ci.fqin, ci.digest, ci.username, ci.size_bytes, ci.created_at, ci.parent_fqin, ci.parent_digest, ci.derivation, ci.platforms, l.depth + 1
+			FROM container_images ci
+			JOIN lineage l ON ci.fqin = l.parent_fqin
+			WHERE ci.username = $2 AND l.depth < 100
+		)
+		SELECT fqin, COALESCE(digest, ''), username, size_bytes, created_at, parent_fqin, parent_digest, derivation, platforms
+		FROM lineage
+		ORDER BY depth
+	`, fqin, userClaims.Username)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to load lineage: %v", err),
+		})
+		return
+	}
+	images, err := pgx.CollectRows(rows, pgx.RowToStructByPos[models.ContainerImage])
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to load lineage: %v", err),
+		})
+		return
+	}
+	if len(images) == 0 {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": errImageNotFound.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"lineage": images,
+	})
+}
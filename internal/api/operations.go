@@ -0,0 +1,112 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+
+	"github.com/digizyne/lfcont/internal/operations"
+	"github.com/digizyne/lfcont/tools"
+)
+
+func (app *App) getOperation(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	op, err := app.Operations.Get(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, operations.ErrNotFound) || (err == nil && op.Username != userClaims.Username) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "operation not found",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading operation: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to load operation",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, op)
+}
+
+// streamOperationEvents sends the operation's progress events as Server-Sent
+// Events, then a final event named after the terminal state carrying the
+// operation. Clients resume after a reconnect with the Last-Event-ID header.
+func (app *App) streamOperationEvents(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	op, err := app.Operations.Get(ctx, id)
+	if err != nil || op.Username != userClaims.Username {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "operation not found",
+		})
+		return
+	}
+
+	var after int64
+	if lastEventID := c.GetHeader("Last-Event-ID"); lastEventID != "" {
+		after, _ = strconv.ParseInt(lastEventID, 10, 64)
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Stream(func(w io.Writer) bool {
+		// Load the state first so that no event recorded before the
+		// operation finished is missed
+		op, err := app.Operations.Get(ctx, id)
+		if err != nil {
+			return false
+		}
+		events, err := app.Operations.Events(ctx, id, after)
+		if err != nil {
+			return false
+		}
+		for _, event := range events {
+			c.Render(-1, sse.Event{
+				Id:    strconv.FormatInt(event.ID, 10),
+				Event: event.Type,
+				Data:  event,
+			})
+			after = event.ID
+		}
+		if len(events) > 0 {
+			return true
+		}
+		if op.Finished() {
+			c.SSEvent(string(op.State), op)
+			return false
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(time.Second):
+			return true
+		}
+	})
+}
@@ -0,0 +1,564 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/digizyne/lfcont/internal/data/models"
+	"github.com/digizyne/lfcont/internal/jobs"
+	"github.com/digizyne/lfcont/internal/secrets"
+	"github.com/digizyne/lfcont/tools"
+)
+
+const (
+	SecretScanWarn   = "warn"
+	SecretScanReject = "reject"
+)
+
+// Admins of an organization manage its members, policy and signing keys.
+const (
+	OrganizationRoleAdmin  = "admin"
+	OrganizationRoleMember = "member"
+)
+
+type CreateOrganizationRequest struct {
+	Name string `json:"name" binding:"required,min=3,max=64"`
+}
+
+type InviteMemberRequest struct {
+	Username string `json:"username" binding:"required"`
+	// Role defaults to member.
+	Role string `json:"role" binding:"omitempty,oneof=admin member"`
+}
+
+// defaultOrganizationPolicy applies to users without an organization and
+// fills in settings an organization has not set.
+func defaultOrganizationPolicy() models.OrganizationPolicy {
+	return models.OrganizationPolicy{
+		SecretScanMode: SecretScanWarn,
+	}
+}
+
+func validateOrganizationPolicy(policy models.OrganizationPolicy) error {
+	switch policy.SecretScanMode {
+	case "", SecretScanWarn, SecretScanReject:
+	default:
+		return fmt.Errorf("secret_scan_mode must be %q or %q", SecretScanWarn, SecretScanReject)
+	}
+	if policy.StorageQuotaBytes < 0 {
+		return fmt.Errorf("storage_quota_bytes must not be negative")
+	}
+	if policy.Admission != nil {
+		if err := policy.Admission.Validate(); err != nil {
+			return fmt.Errorf("admission: %v", err)
+		}
+	}
+	return nil
+}
+
+// userOrganization returns the organization of username, or "" if the user is
+// not a member of one.
+func (app *App) userOrganization(ctx context.Context, username string) (string, error) {
+	var organization *string
+	err := app.Pool.QueryRow(ctx, "SELECT organization FROM users WHERE username = $1", username).Scan(&organization)
+	if err != nil {
+		return "", err
+	}
+	if organization == nil {
+		return "", nil
+	}
+	return *organization, nil
+}
+
+// organizationAdmin returns the organization administered by username. It
+// aborts the request unless the user is an admin of an organization.
+func (app *App) organizationAdmin(c *gin.Context, username string) (string, bool) {
+	var organization, role *string
+	err := app.Pool.QueryRow(c.Request.Context(), "SELECT organization, organization_role FROM users WHERE username = $1", username).Scan(&organization, &role)
+	if err != nil && err != pgx.ErrNoRows {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to load organization: %v", err),
+		})
+		return "", false
+	}
+	if err == pgx.ErrNoRows || organization == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "user does not belong to an organization",
+		})
+		return "", false
+	}
+	if role == nil || *role != OrganizationRoleAdmin {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": "access denied - requires the organization admin role",
+		})
+		return "", false
+	}
+	return *organization, true
+}
+
+// organizationPolicy returns the effective policy for username.
+func (app *App) organizationPolicy(ctx context.Context, username string) (models.OrganizationPolicy, error) {
+	policy := defaultOrganizationPolicy()
+
+	var document []byte
+	err := app.Pool.QueryRow(ctx, `
+		SELECT o.policy
+		FROM users u
+		JOIN organizations o ON o.name = u.organization
+		WHERE u.username = $1
+	`, username).Scan(&document)
+	if err == pgx.ErrNoRows {
+		return policy, nil
+	}
+	if err != nil {
+		return policy, err
+	}
+
+	var stored models.OrganizationPolicy
+	if err := json.Unmarshal(document, &stored); err != nil {
+		return policy, fmt.Errorf("invalid organization policy: %v", err)
+	}
+	if stored.SecretScanMode != "" {
+		policy.SecretScanMode = stored.SecretScanMode
+	}
+	policy.Admission = stored.Admission
+	policy.StorageQuotaBytes = stored.StorageQuotaBytes
+	return policy, nil
+}
+
+func (app *App) createOrganization(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	var req CreateOrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{
+			"error":   "invalid request payload",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tx, err := app.Pool.Begin(ctx)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to start transaction: %v", err),
+		})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var existing *string
+	if err := tx.QueryRow(ctx, "SELECT organization FROM users WHERE username = $1 FOR UPDATE", userClaims.Username).Scan(&existing); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to load user: %v", err),
+		})
+		return
+	}
+	if existing != nil {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error": "user already belongs to an organization",
+		})
+		return
+	}
+
+	tag, err := tx.Exec(ctx, "INSERT INTO organizations (name) VALUES ($1) ON CONFLICT DO NOTHING", req.Name)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to create organization: %v", err),
+		})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error": "organization name already in use",
+		})
+		return
+	}
+	if _, err := tx.Exec(ctx, "UPDATE users SET organization = $1, organization_role = $2 WHERE username = $3", req.Name, OrganizationRoleAdmin, userClaims.Username); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to join organization: %v", err),
+		})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to create organization: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"name": req.Name,
+	})
+}
+
+func (app *App) getCurrentOrganization(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	organization, err := app.userOrganization(ctx, userClaims.Username)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to load organization: %v", err),
+		})
+		return
+	}
+	if organization == "" {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "user does not belong to an organization",
+		})
+		return
+	}
+
+	policy, err := app.organizationPolicy(ctx, userClaims.Username)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to load organization policy: %v", err),
+		})
+		return
+	}
+
+	rows, err := app.Pool.Query(ctx, "SELECT username, organization_role FROM users WHERE organization = $1 ORDER BY username", organization)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to load members: %v", err),
+		})
+		return
+	}
+	members, admins := []string{}, []string{}
+	var role string
+	for rows.Next() {
+		var member string
+		var memberRole *string
+		if err := rows.Scan(&member, &memberRole); err != nil {
+			rows.Close()
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to load members: %v", err),
+			})
+			return
+		}
+		members = append(members, member)
+		if memberRole != nil && *memberRole == OrganizationRoleAdmin {
+			admins = append(admins, member)
+		}
+		if member == userClaims.Username && memberRole != nil {
+			role = *memberRole
+		}
+	}
+	if err := rows.Err(); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to load members: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"name":    organization,
+		"role":    role,
+		"members": members,
+		"admins":  admins,
+		"policy":  policy,
+	})
+}
+
+// inviteOrganizationMember invites a user without an organization to join
+// the caller's. The user becomes a member once they accept the invite.
+func (app *App) inviteOrganizationMember(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	var req InviteMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{
+			"error":   "invalid request payload",
+			"message": err.Error(),
+		})
+		return
+	}
+	if req.Role == "" {
+		req.Role = OrganizationRoleMember
+	}
+
+	organization, ok := app.organizationAdmin(c, userClaims.Username)
+	if !ok {
+		return
+	}
+
+	var invite models.OrganizationInvite
+	err = app.Pool.QueryRow(c.Request.Context(), `
+		INSERT INTO organization_invites (organization, username, role, invited_by)
+		SELECT $1, username, $3, $4 FROM users WHERE username = $2 AND organization IS NULL
+		ON CONFLICT (organization, username) DO UPDATE
+			SET role = EXCLUDED.role, invited_by = EXCLUDED.invited_by, created_at = now()
+		RETURNING organization, username, role, invited_by, created_at
+	`, organization, req.Username, req.Role, userClaims.Username).Scan(&invite.Organization, &invite.Username, &invite.Role, &invite.InvitedBy, &invite.CreatedAt)
+	if err == pgx.ErrNoRows {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error": "user does not exist or already belongs to an organization",
+		})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to invite member: %v", err),
+		})
+		return
+	}
+
+	log.Printf("User %s invited %s to organization %s as %s", userClaims.Username, req.Username, organization, req.Role)
+	c.JSON(http.StatusCreated, invite)
+}
+
+// listOrganizationInvites lists the invites addressed to the caller.
+func (app *App) listOrganizationInvites(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	rows, err := app.Pool.Query(c.Request.Context(), `
+		SELECT organization, username, role, invited_by, created_at
+		FROM organization_invites
+		WHERE username = $1
+		ORDER BY created_at DESC
+	`, userClaims.Username)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to load invites: %v", err),
+		})
+		return
+	}
+	invites, err := pgx.CollectRows(rows, pgx.RowToStructByPos[models.OrganizationInvite])
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to load invites: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"invites": invites,
+	})
+}
+
+// acceptOrganizationInvite makes the caller a member of the organization that
+// invited them. Their other invites are dropped.
+func (app *App) acceptOrganizationInvite(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	organization := c.Param("organization")
+	ctx := c.Request.Context()
+	tx, err := app.Pool.Begin(ctx)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to start transaction: %v", err),
+		})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var existing *string
+	if err := tx.QueryRow(ctx, "SELECT organization FROM users WHERE username = $1 FOR UPDATE", userClaims.Username).Scan(&existing); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to load user: %v", err),
+		})
+		return
+	}
+	if existing != nil {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error": "user already belongs to an organization",
+		})
+		return
+	}
+
+	var role string
+	err = tx.QueryRow(ctx, `
+		DELETE FROM organization_invites WHERE organization = $1 AND username = $2 RETURNING role
+	`, organization, userClaims.Username).Scan(&role)
+	if err == pgx.ErrNoRows {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "invite not found",
+		})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to accept invite: %v", err),
+		})
+		return
+	}
+	if _, err := tx.Exec(ctx, "UPDATE users SET organization = $1, organization_role = $2 WHERE username = $3", organization, role, userClaims.Username); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to join organization: %v", err),
+		})
+		return
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM organization_invites WHERE username = $1", userClaims.Username); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to accept invite: %v", err),
+		})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to accept invite: %v", err),
+		})
+		return
+	}
+
+	log.Printf("User %s joined organization %s as %s", userClaims.Username, organization, role)
+	c.JSON(http.StatusOK, gin.H{
+		"name": organization,
+		"role": role,
+	})
+}
+
+// declineOrganizationInvite deletes an invite addressed to the caller.
+func (app *App) declineOrganizationInvite(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	organization := c.Param("organization")
+	tag, err := app.Pool.Exec(c.Request.Context(), `
+		DELETE FROM organization_invites WHERE organization = $1 AND username = $2
+	`, organization, userClaims.Username)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to decline invite: %v", err),
+		})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "invite not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("invite to %s declined", organization),
+	})
+}
+
+func (app *App) updateOrganizationPolicy(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	var policy models.OrganizationPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(400, gin.H{
+			"error":   "invalid request payload",
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := validateOrganizationPolicy(policy); err != nil {
+		c.JSON(400, gin.H{
+			"error":   "invalid policy",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	organization, ok := app.organizationAdmin(c, userClaims.Username)
+	if !ok {
+		return
+	}
+
+	document, err := json.Marshal(policy)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to encode policy: %v", err),
+		})
+		return
+	}
+	if _, err := app.Pool.Exec(ctx, "UPDATE organizations SET policy = $1 WHERE name = $2", document, organization); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to update policy: %v", err),
+		})
+		return
+	}
+
+	log.Printf("User %s updated policy of organization %s", userClaims.Username, organization)
+	c.JSON(http.StatusOK, gin.H{
+		"name":   organization,
+		"policy": policy,
+	})
+}
+
+// scanForSecrets searches the image layers for leaked credentials. Findings
+// fail the job when the user's organization policy is set to reject them.
+func (app *App) scanForSecrets(ctx context.Context, r *jobs.Reporter, img v1.Image, username string) ([]secrets.Finding, error) {
+	r.Stage("scanning for secrets")
+	policy, err := app.organizationPolicy(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load organization policy: %v", err)
+	}
+	findings, err := secrets.Scan(img)
+	if err != nil {
+		return nil, fmt.Errorf("secret scan failed: %v", err)
+	}
+	if len(findings) > 0 && policy.SecretScanMode == SecretScanReject {
+		return findings, fmt.Errorf("image contains %d likely secret(s)", len(findings))
+	}
+	return findings, nil
+}
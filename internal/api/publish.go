@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+
+	"github.com/digizyne/lfcont/internal/data/models"
+	"github.com/digizyne/lfcont/internal/imagecheck"
+	"github.com/digizyne/lfcont/internal/jobs"
+	"github.com/digizyne/lfcont/internal/quota"
+	"github.com/digizyne/lfcont/internal/rebase"
+	"github.com/digizyne/lfcont/internal/registry"
+	"github.com/digizyne/lfcont/internal/secrets"
+)
+
+// publishImage runs the checks every new image goes through, uploads it to
+// dst and records it for username. The returned job result carries the
+// findings even when publishing is rejected.
+func (app *App) publishImage(ctx context.Context, r *jobs.Reporter, dst name.Reference, img v1.Image, username string) (gin.H, error) {
+	// Reject images that cannot run on Cloud Run before uploading anything
+	r.Stage("checking image")
+	findings, err := imagecheck.Inspect(img, imagecheck.PolicyFromEnv())
+	if err != nil {
+		return nil, err
+	}
+	if imagecheck.HasErrors(findings) {
+		return gin.H{"findings": findings}, fmt.Errorf("image is not compatible with the runtime")
+	}
+
+	leaks, err := app.scanForSecrets(ctx, r, img, username)
+	if err != nil {
+		return gin.H{"findings": findings, "secrets": leaks}, err
+	}
+
+	// Enforce storage quotas before uploading any layers
+	size, err := quota.ImageSize(img)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute image size: %v", err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return nil, err
+	}
+	r.Stage("recording image")
+	if err := app.reserveContainerImage(ctx, dst.String(), digest.String(), username, size); err != nil {
+		return gin.H{"findings": findings, "secrets": leaks}, err
+	}
+
+	if err := registry.Upload(ctx, r, dst, img, app.Credentials.RemoteOptions(ctx)...); err != nil {
+		app.releaseContainerImage(ctx, dst.String())
+		return nil, fmt.Errorf("image push failed: %v", err)
+	}
+
+	// Track the base image for rebasing when the image declares it
+	if base, ok, err := rebase.FromAnnotations(img); err != nil {
+		log.Printf("Failed to read base annotations of %s: %v", dst, err)
+	} else if ok {
+		if err := app.recordBase(ctx, dst.String(), base, rebase.SourceAnnotation); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+
+	app.generateSBOM(ctx, r, dst.String(), digest, img)
+
+	return gin.H{"fqin": dst.String(), "digest": digest.String(), "findings": findings, "secrets": leaks}, nil
+}
+
+// publishIndex is publishImage for a multi-arch index. The image for the
+// runtime platform must pass the runtime checks; every child is scanned for
+// secrets.
+func (app *App) publishIndex(ctx context.Context, r *jobs.Reporter, dst name.Reference, idx v1.ImageIndex, username string) (gin.H, error) {
+	r.Stage("checking image")
+	children, err := registry.PlatformImages(idx)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image index: %v", err)
+	}
+	policy := imagecheck.PolicyFromEnv()
+	runtime, ok := registry.SelectPlatform(children, policy.Platform)
+	if !ok {
+		return nil, fmt.Errorf("image index has no image for %s", policy.Platform)
+	}
+	findings, err := imagecheck.Inspect(runtime.Image, policy)
+	if err != nil {
+		return nil, err
+	}
+	if imagecheck.HasErrors(findings) {
+		return gin.H{"findings": findings}, fmt.Errorf("image is not compatible with the runtime")
+	}
+
+	var leaks []secrets.Finding
+	platforms := make([]models.ImagePlatform, 0, len(children))
+	for _, child := range children {
+		childLeaks, err := app.scanForSecrets(ctx, r, child.Image, username)
+		leaks = append(leaks, childLeaks...)
+		if err != nil {
+			return gin.H{"findings": findings, "secrets": leaks}, fmt.Errorf("%s: %v", child.Platform, err)
+		}
+		size, err := quota.ImageSize(child.Image)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute image size: %v", err)
+		}
+		platforms = append(platforms, models.ImagePlatform{
+			Platform:  child.Platform.String(),
+			Digest:    child.Digest.String(),
+			SizeBytes: size,
+		})
+	}
+
+	size, err := quota.IndexSize(idx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute image size: %v", err)
+	}
+	digest, err := idx.Digest()
+	if err != nil {
+		return nil, err
+	}
+	r.Stage("recording image")
+	if err := app.reserveContainerImage(ctx, dst.String(), digest.String(), username, size); err != nil {
+		return gin.H{"findings": findings, "secrets": leaks}, err
+	}
+	if _, err := app.Pool.Exec(ctx, "UPDATE container_images SET platforms = $1 WHERE fqin = $2", platforms, dst.String()); err != nil {
+		app.releaseContainerImage(ctx, dst.String())
+		return nil, fmt.Errorf("failed to record image platforms: %v", err)
+	}
+
+	if err := registry.UploadIndex(ctx, r, dst, idx, app.Credentials.RemoteOptions(ctx)...); err != nil {
+		app.releaseContainerImage(ctx, dst.String())
+		return nil, fmt.Errorf("image push failed: %v", err)
+	}
+
+	if base, ok, err := rebase.FromAnnotations(runtime.Image); err != nil {
+		log.Printf("Failed to read base annotations of %s: %v", dst, err)
+	} else if ok {
+		if err := app.recordBase(ctx, dst.String(), base, rebase.SourceAnnotation); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+
+	app.generateSBOM(ctx, r, dst.String(), digest, runtime.Image)
+
+	return gin.H{"fqin": dst.String(), "digest": digest.String(), "platforms": platforms, "findings": findings, "secrets": leaks}, nil
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/digizyne/lfcont/tools"
+)
+
+func (app *App) getPushJob(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	job, ok := app.Jobs.Get(c.Param("id"))
+	if !ok || job.Username != userClaims.Username {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "job not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+func (app *App) streamPushJob(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	jobID := c.Param("id")
+	job, ok := app.Jobs.Get(jobID)
+	if !ok || job.Username != userClaims.Username {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "job not found",
+		})
+		return
+	}
+
+	updates, cancel, ok := app.Jobs.Subscribe(jobID)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "job not found",
+		})
+		return
+	}
+	defer cancel()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case job, open := <-updates:
+			if !open {
+				return false
+			}
+			if job.Finished() {
+				c.SSEvent(string(job.State), job)
+				return false
+			}
+			c.SSEvent("progress", job)
+			return true
+		}
+	})
+}
+
+// abortOnSubmit aborts the request when the job could not be queued.
+func abortOnSubmit(c *gin.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+	log.Printf("Failed to queue job: %v", err)
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+		"error": err.Error(),
+	})
+	return true
+}
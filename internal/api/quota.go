@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/digizyne/lfcont/internal/quota"
+	"github.com/digizyne/lfcont/tools"
+)
+
+type RepositoryUsage struct {
+	Repository string `json:"repository"`
+	Images     int    `json:"images"`
+	Bytes      int64  `json:"bytes"`
+}
+
+// rowQuerier is implemented by the pool and by transactions.
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// storageUsage returns the quotas that apply to username: their own, limited
+// by their tier, and their organization's if it sets one.
+func (app *App) storageUsage(ctx context.Context, q rowQuerier, username string) ([]quota.Usage, error) {
+	limits, err := quota.TierLimits()
+	if err != nil {
+		return nil, err
+	}
+
+	var tier string
+	var organization *string
+	user := quota.Usage{Scope: quota.ScopeUser, Name: username}
+	err = q.QueryRow(ctx, `
+		SELECT u.tier, u.organization, COALESCE(SUM(ci.size_bytes), 0)
+		FROM users u
+		LEFT JOIN container_images ci ON ci.username = u.username
+		WHERE u.username = $1
+		GROUP BY u.username
+	`, username).Scan(&tier, &organization, &user.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	user.Limit = limits[tier]
+	usages := []quota.Usage{user}
+
+	if organization == nil {
+		return usages, nil
+	}
+	policy, err := app.organizationPolicy(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	org := quota.Usage{Scope: quota.ScopeOrganization, Name: *organization, Limit: policy.StorageQuotaBytes}
+	err = q.QueryRow(ctx, `
+		SELECT COALESCE(SUM(ci.size_bytes), 0)
+		FROM container_images ci
+		JOIN users u ON u.username = ci.username
+		WHERE u.organization = $1
+	`, *organization).Scan(&org.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return append(usages, org), nil
+}
+
+// checkStorageQuota returns a *quota.ExceededError when username cannot store
+// additional more bytes.
+func (app *App) checkStorageQuota(ctx context.Context, username string, additional int64) error {
+	usages, err := app.storageUsage(ctx, app.Pool, username)
+	if err != nil {
+		return err
+	}
+	return quota.Check(additional, usages...)
+}
+
+// abortOnQuota rejects a request that would exceed a quota: 403 when the
+// quota is already used up, 413 when the upload itself does not fit. It
+// reports whether the request was aborted.
+func abortOnQuota(c *gin.Context, err error) bool {
+	var exceeded *quota.ExceededError
+	if !errors.As(err, &exceeded) {
+		if err != nil {
+			log.Printf("Storage quota error: %v", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to check storage quota",
+			})
+			return true
+		}
+		return false
+	}
+	status := http.StatusRequestEntityTooLarge
+	if exceeded.Full() {
+		status = http.StatusForbidden
+	}
+	c.AbortWithStatusJSON(status, gin.H{
+		"error":     exceeded.Error(),
+		"usage":     exceeded.Usage,
+		"requested": exceeded.Requested,
+	})
+	return true
+}
+
+func (app *App) getStorageUsage(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	usages, err := app.storageUsage(ctx, app.Pool, userClaims.Username)
+	if err != nil {
+		log.Printf("Error loading storage usage: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to load storage usage",
+		})
+		return
+	}
+
+	// The repository is the fqin without its tag
+	rows, err := app.Pool.Query(ctx, `
+		SELECT regexp_replace(fqin, ':[^:/]*$', '') AS repository, COUNT(*), COALESCE(SUM(size_bytes), 0)
+		FROM container_images
+		WHERE username = $1
+		GROUP BY repository
+		ORDER BY repository
+	`, userClaims.Username)
+	if err != nil {
+		log.Printf("Error loading repository usage: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to load storage usage",
+		})
+		return
+	}
+	defer rows.Close()
+
+	repositories := []RepositoryUsage{}
+	for rows.Next() {
+		var usage RepositoryUsage
+		if err := rows.Scan(&usage.Repository, &usage.Images, &usage.Bytes); err != nil {
+			log.Printf("Error scanning repository usage: %v", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to load storage usage",
+			})
+			return
+		}
+		repositories = append(repositories, usage)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating repository usage: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to load storage usage",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"quotas":       usages,
+		"repositories": repositories,
+	})
+}
@@ -0,0 +1,389 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/digizyne/lfcont/internal/data/models"
+	"github.com/digizyne/lfcont/internal/jobs"
+	"github.com/digizyne/lfcont/internal/rebase"
+	"github.com/digizyne/lfcont/internal/registry"
+	"github.com/digizyne/lfcont/tools"
+)
+
+const DerivationRebase = "rebase"
+
+type DeclareBaseRequest struct {
+	BaseImage string `json:"base_image" binding:"required"`
+	// BaseDigest defaults to what BaseImage points at now.
+	BaseDigest string `json:"base_digest"`
+}
+
+type RebaseImageRequest struct {
+	// Tag is the tag for the rebased image; a random one is used if empty.
+	Tag string `json:"tag"`
+	// Redeploy rolls every deployment running the original image over to the
+	// rebased one.
+	Redeploy bool `json:"redeploy"`
+}
+
+type OutdatedImage struct {
+	models.ImageBase
+	Deployments []string `json:"deployments"`
+}
+
+// recordBase stores the base fqin was built on, replacing any earlier record.
+func (app *App) recordBase(ctx context.Context, fqin string, base rebase.Base, source string) error {
+	_, err := app.Pool.Exec(ctx, `
+		INSERT INTO image_bases (fqin, base_name, base_digest, source)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (fqin) DO UPDATE
+		SET base_name = $2, base_digest = $3, source = $4, latest_digest = NULL, outdated = false, checked_at = NULL
+	`, fqin, base.Name, base.Digest, source)
+	if err != nil {
+		return fmt.Errorf("failed to record base image: %v", err)
+	}
+	return nil
+}
+
+// inheritBase copies the base record of parent to a derived image that kept
+// its layers.
+func (app *App) inheritBase(ctx context.Context, fqin, parent string) error {
+	_, err := app.Pool.Exec(ctx, `
+		INSERT INTO image_bases (fqin, base_name, base_digest, source, latest_digest, outdated, checked_at)
+		SELECT $1, base_name, base_digest, source, latest_digest, outdated, checked_at
+		FROM image_bases WHERE fqin = $2
+		ON CONFLICT (fqin) DO NOTHING
+	`, fqin, parent)
+	if err != nil {
+		return fmt.Errorf("failed to record base image: %v", err)
+	}
+	return nil
+}
+
+// imageBase loads the base record of an image owned by username.
+func (app *App) imageBase(ctx context.Context, username, fqin string) (models.ImageBase, error) {
+	rows, err := app.Pool.Query(ctx, `
+		SELECT b.fqin, b.base_name, b.base_digest, b.source, b.latest_digest, b.outdated, b.checked_at
+		FROM image_bases b
+		JOIN container_images ci ON ci.fqin = b.fqin
+		WHERE b.fqin = $1 AND ci.username = $2
+	`, fqin, username)
+	if err != nil {
+		return models.ImageBase{}, err
+	}
+	return pgx.CollectExactlyOneRow(rows, pgx.RowToStructByPos[models.ImageBase])
+}
+
+func (app *App) getImageBase(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	base, err := app.imageBase(c.Request.Context(), userClaims.Username, c.Param("fqin"))
+	if err == pgx.ErrNoRows {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "no base image recorded for this image",
+		})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to load base image: %v", err),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, base)
+}
+
+// declareImageBase records the base of an image that carries no base
+// annotations. The base layers must be the bottom layers of the image.
+func (app *App) declareImageBase(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	var req DeclareBaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{
+			"error":   "invalid request payload",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	fqin := c.Param("fqin")
+	ctx := c.Request.Context()
+	img, err := app.ownedPlatformImage(ctx, userClaims.Username, fqin)
+	if err != nil {
+		abortOnImageError(c, err)
+		return
+	}
+
+	opts := app.Credentials.RemoteOptions(ctx)
+	base := rebase.Base{Name: req.BaseImage, Digest: req.BaseDigest}
+	if base.Digest == "" {
+		base, err = rebase.Latest(ctx, req.BaseImage, opts...)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Failed to resolve base image: %v", err),
+			})
+			return
+		}
+	}
+	baseImage, err := base.Image(ctx, registry.DefaultPlatform, opts...)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Failed to fetch base image: %v", err),
+		})
+		return
+	}
+	builtOn, err := rebase.BuiltOn(img, baseImage)
+	if err != nil {
+		abortOnImageError(c, err)
+		return
+	}
+	if !builtOn {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+			"error": fmt.Sprintf("%s is not built on %s@%s", fqin, base.Name, base.Digest),
+		})
+		return
+	}
+
+	if err := app.recordBase(ctx, fqin, base, rebase.SourceDeclared); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	log.Printf("User %s declared %s@%s as base of %s", userClaims.Username, base.Name, base.Digest, fqin)
+	c.JSON(http.StatusOK, gin.H{
+		"fqin":        fqin,
+		"base_name":   base.Name,
+		"base_digest": base.Digest,
+		"source":      rebase.SourceDeclared,
+	})
+}
+
+// listOutdatedImages lists the user's images whose base tag has moved since
+// they were built, with the deployments still running them.
+func (app *App) listOutdatedImages(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	rows, err := app.Pool.Query(c.Request.Context(), `
+		SELECT b.fqin, b.base_name, b.base_digest, b.source, b.latest_digest, b.outdated, b.checked_at,
+			ARRAY(
+				SELECT d.name FROM deployments d
+				WHERE d.username = ci.username
+					AND (d.container_image = ci.fqin OR (ci.digest IS NOT NULL AND d.image_digest = ci.digest))
+				ORDER BY d.name
+			)
+		FROM image_bases b
+		JOIN container_images ci ON ci.fqin = b.fqin
+		WHERE ci.username = $1 AND b.outdated
+		ORDER BY b.fqin
+	`, userClaims.Username)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to load outdated images: %v", err),
+		})
+		return
+	}
+	images, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (OutdatedImage, error) {
+		var image OutdatedImage
+		err := row.Scan(&image.Fqin, &image.BaseName, &image.BaseDigest, &image.Source,
+			&image.LatestDigest, &image.Outdated, &image.CheckedAt, &image.Deployments)
+		return image, err
+	})
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to load outdated images: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"images": images,
+	})
+}
+
+// rebaseContainerImage swaps the base layers of an owned image for the
+// latest digest of its base tag and publishes the result under a new tag.
+func (app *App) rebaseContainerImage(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	var req RebaseImageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{
+			"error":   "invalid request payload",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	fqin := c.Param("fqin")
+	ctx := c.Request.Context()
+	record, err := app.imageBase(ctx, userClaims.Username, fqin)
+	if err == pgx.ErrNoRows {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "no base image recorded for this image, declare one first",
+		})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to load base image: %v", err),
+		})
+		return
+	}
+	img, err := app.ownedPlatformImage(ctx, userClaims.Username, fqin)
+	if err != nil {
+		abortOnImageError(c, err)
+		return
+	}
+	imgDigest, err := img.Digest()
+	if err != nil {
+		abortOnImageError(c, err)
+		return
+	}
+
+	tag := req.Tag
+	if tag == "" {
+		tag = uuid.New().String()[:8]
+	}
+	dst, err := app.derivedTag(ctx, fqin, tag)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	username := userClaims.Username
+	oldBase := rebase.Base{Name: record.BaseName, Digest: record.BaseDigest}
+	job, err := app.Jobs.Submit("rebase", username, func(ctx context.Context, r *jobs.Reporter) (any, error) {
+		r.Stage("resolving base images")
+		opts := app.Credentials.RemoteOptions(ctx)
+		latest, err := rebase.Latest(ctx, oldBase.Name, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %v", oldBase.Name, err)
+		}
+		if latest.Digest == oldBase.Digest {
+			return nil, fmt.Errorf("%s is already built on the latest %s", fqin, oldBase.Name)
+		}
+		oldImage, err := oldBase.Image(ctx, registry.DefaultPlatform, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s@%s: %v", oldBase.Name, oldBase.Digest, err)
+		}
+		newImage, err := latest.Image(ctx, registry.DefaultPlatform, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s@%s: %v", latest.Name, latest.Digest, err)
+		}
+
+		r.Stage("rebasing")
+		rebased, err := rebase.Rebase(img, oldImage, newImage, latest)
+		if err != nil {
+			return nil, fmt.Errorf("rebase failed: %v", err)
+		}
+
+		result, err := app.publishImage(ctx, r, dst, rebased, username)
+		if err != nil {
+			return result, err
+		}
+		if err := app.recordLineage(ctx, dst.String(), fqin, imgDigest.String(), DerivationRebase); err != nil {
+			return nil, err
+		}
+		if err := app.recordBase(ctx, dst.String(), latest, record.Source); err != nil {
+			return nil, err
+		}
+		log.Printf("Rebased %s onto %s@%s as %s for %s", fqin, latest.Name, latest.Digest, dst, username)
+		result["parent_fqin"] = fqin
+		result["base_name"] = latest.Name
+		result["base_digest"] = latest.Digest
+
+		if !req.Redeploy {
+			return result, nil
+		}
+		redeployments, err := app.redeploy(ctx, r, username, fqin, imgDigest.String(), dst.String())
+		result["redeployments"] = redeployments
+		return result, err
+	})
+	if abortOnSubmit(c, err) {
+		return
+	}
+
+	log.Printf("Queued rebase job %s for user %s", job.ID, username)
+	c.JSON(http.StatusAccepted, gin.H{
+		"job_id":     job.ID,
+		"status_url": fmt.Sprintf("/api/v1/container-images/jobs/%s", job.ID),
+		"events_url": fmt.Sprintf("/api/v1/container-images/jobs/%s/events", job.ID),
+	})
+}
+
+// redeploy queues a deploy operation for every deployment of username
+// running fqin (or its digest), moving it to image. Each goes through the
+// regular deploy checks when it runs. No tier is requested, so deployments
+// keep their tier even if the user's plan no longer includes it.
+func (app *App) redeploy(ctx context.Context, r *jobs.Reporter, username, fqin, digest, image string) ([]gin.H, error) {
+	r.Stage("queueing redeployments")
+	rows, err := app.Pool.Query(ctx, `
+		SELECT name FROM deployments
+		WHERE username = $1 AND (container_image = $2 OR image_digest = $3)
+		ORDER BY name
+	`, username, fqin, digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load deployments: %v", err)
+	}
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("failed to load deployments: %v", err)
+	}
+
+	results := []gin.H{}
+	for _, name := range names {
+		op, err := app.Operations.Enqueue(ctx, OperationDeploy, name, username, RequestBody{
+			Name:           name,
+			ContainerImage: image,
+		})
+		if err != nil {
+			return results, fmt.Errorf("failed to queue redeployment of %s: %v", name, err)
+		}
+		results = append(results, gin.H{"name": name, "operation_id": op.ID})
+	}
+	return results, nil
+}
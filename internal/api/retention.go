@@ -0,0 +1,162 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+
+	"github.com/digizyne/lfcont/internal/retention"
+	"github.com/digizyne/lfcont/tools"
+)
+
+type RetentionPolicyRequest struct {
+	KeepLast int `json:"keep_last"`
+	KeepDays int `json:"keep_days"`
+}
+
+type RetentionMetrics struct {
+	Runs           int        `json:"runs"`
+	ImagesDeleted  int        `json:"images_deleted"`
+	BytesReclaimed int64      `json:"bytes_reclaimed"`
+	LastReclaimAt  *time.Time `json:"last_reclaim_at"`
+}
+
+func (app *App) listRetentionPolicies(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	policies, err := retention.Policies(c.Request.Context(), app.Pool, userClaims.Username)
+	if err != nil {
+		log.Printf("Error loading retention policies: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to load retention policies",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"policies": policies,
+	})
+}
+
+func (app *App) putRetentionPolicy(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	var req RetentionPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{
+			"error":   "invalid request payload",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	repo, err := name.NewRepository(c.Param("repository"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("invalid repository: %v", err),
+		})
+		return
+	}
+	policy := retention.Policy{
+		Username:   userClaims.Username,
+		Repository: repo.Name(),
+		KeepLast:   req.KeepLast,
+		KeepDays:   req.KeepDays,
+	}
+	if err := policy.Validate(); err != nil {
+		c.JSON(400, gin.H{
+			"error":   "invalid retention policy",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	err = app.Pool.QueryRow(c.Request.Context(), `
+		INSERT INTO retention_policies (username, repository, keep_last, keep_days)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (username, repository) DO UPDATE SET
+			keep_last = EXCLUDED.keep_last,
+			keep_days = EXCLUDED.keep_days,
+			updated_at = now()
+		RETURNING updated_at
+	`, policy.Username, policy.Repository, policy.KeepLast, policy.KeepDays).Scan(&policy.UpdatedAt)
+	if err != nil {
+		log.Printf("Error storing retention policy: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to store retention policy",
+		})
+		return
+	}
+
+	log.Printf("User %s set retention policy for %s (keep_last=%d, keep_days=%d)", policy.Username, policy.Repository, policy.KeepLast, policy.KeepDays)
+	c.JSON(http.StatusOK, policy)
+}
+
+// getRetentionReport is a dry run of the collector for the caller's policies.
+func (app *App) getRetentionReport(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	report, err := app.Retention.Collect(c.Request.Context(), userClaims.Username, true)
+	if err != nil {
+		log.Printf("Error building retention report: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to build retention report: %v", err),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func (app *App) getRetentionMetrics(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	var metrics RetentionMetrics
+	err = app.Pool.QueryRow(c.Request.Context(), `
+		SELECT COUNT(*), COALESCE(SUM(images_deleted), 0), COALESCE(SUM(bytes_reclaimed), 0), MAX(created_at)
+		FROM retention_reclaims
+		WHERE username = $1
+	`, userClaims.Username).Scan(&metrics.Runs, &metrics.ImagesDeleted, &metrics.BytesReclaimed, &metrics.LastReclaimAt)
+	if err != nil {
+		log.Printf("Error loading retention metrics: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to load retention metrics",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, metrics)
+}
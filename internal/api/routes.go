@@ -1,16 +1,100 @@
 package api
 
 import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/digizyne/lfcont/internal/credentials"
+	"github.com/digizyne/lfcont/internal/imagefs"
+	"github.com/digizyne/lfcont/internal/jobs"
+	"github.com/digizyne/lfcont/internal/operations"
+	"github.com/digizyne/lfcont/internal/rebase"
+	"github.com/digizyne/lfcont/internal/retention"
+	"github.com/digizyne/lfcont/internal/tiers"
 )
 
 type App struct {
-	Pool *pgxpool.Pool
+	Pool        *pgxpool.Pool
+	Credentials *credentials.Provider
+	Jobs        *jobs.Manager
+	Retention   *retention.Collector
+	BaseWatch   *rebase.Watcher
+	Operations  *operations.Queue
+	ImageFS     *imagefs.Cache
+	Tiers       tiers.Catalog
 }
 
-func InitializeApp(router *gin.Engine, pool *pgxpool.Pool) {
-	app := &App{Pool: pool}
+func InitializeApp(router *gin.Engine, pool *pgxpool.Pool, creds *credentials.Provider) {
+	pushWorkers, err := strconv.Atoi(os.Getenv("PUSH_WORKERS"))
+	if err != nil || pushWorkers < 1 {
+		pushWorkers = 2
+	}
+	app := &App{
+		Pool:        pool,
+		Credentials: creds,
+		Jobs:        jobs.NewManager(context.Background(), pushWorkers, time.Hour),
+		ImageFS:     imagefs.NewCache(8),
+	}
+	// Deployment tiers come from DEPLOY_TIERS_FILE; a broken catalog would
+	// reject every deployment, so it stops the controller instead.
+	app.Tiers, err = tiers.Load()
+	if err != nil {
+		log.Fatalf("Invalid tier catalog: %v", err)
+	}
+	app.Retention = &retention.Collector{
+		Pool:          pool,
+		RemoteOptions: creds.RemoteOptions,
+	}
+
+	// Expired images are collected in the background; RETENTION_INTERVAL=0
+	// disables the collector.
+	retentionInterval := 24 * time.Hour
+	if value := os.Getenv("RETENTION_INTERVAL"); value != "" {
+		if parsed, err := time.ParseDuration(value); err == nil {
+			retentionInterval = parsed
+		}
+	}
+	if retentionInterval > 0 {
+		go app.Retention.Run(context.Background(), retentionInterval)
+	}
+
+	// Base image tags are checked for security updates; BASE_CHECK_INTERVAL=0
+	// disables the watcher.
+	app.BaseWatch = &rebase.Watcher{
+		Pool:          pool,
+		RemoteOptions: creds.RemoteOptions,
+	}
+	baseCheckInterval := 6 * time.Hour
+	if value := os.Getenv("BASE_CHECK_INTERVAL"); value != "" {
+		if parsed, err := time.ParseDuration(value); err == nil {
+			baseCheckInterval = parsed
+		}
+	}
+	if baseCheckInterval > 0 {
+		go app.BaseWatch.Run(context.Background(), baseCheckInterval)
+	}
+
+	// Deployments run from a queue stored in Postgres
+	deployWorkers, err := strconv.Atoi(os.Getenv("DEPLOY_WORKERS"))
+	if err != nil || deployWorkers < 1 {
+		deployWorkers = 2
+	}
+	app.Operations = operations.NewQueue(pool)
+	app.Operations.Register(OperationDeploy, app.runDeployOperation)
+	app.Operations.Register(OperationDelete, app.runDeleteOperation)
+	app.Operations.Register(OperationPreview, app.runPreviewOperation)
+	app.Operations.Register(OperationRollback, app.runRollbackOperation)
+	app.Operations.Start(context.Background(), deployWorkers)
+
+	// Image references contain slashes, so they are passed URL-encoded in
+	// path parameters.
+	router.UseRawPath = true
 
 	router.GET("/health", app.CheckHealth)
 
@@ -20,11 +104,54 @@ func InitializeApp(router *gin.Engine, pool *pgxpool.Pool) {
 	auth.POST("/register", app.register)
 	auth.POST("/login", app.login)
 
+	organizations := apiv1.Group("/organizations")
+	organizations.POST("", app.createOrganization)
+	organizations.GET("/current", app.getCurrentOrganization)
+	organizations.POST("/current/invites", app.inviteOrganizationMember)
+	organizations.GET("/invites", app.listOrganizationInvites)
+	organizations.POST("/invites/:organization/accept", app.acceptOrganizationInvite)
+	organizations.DELETE("/invites/:organization", app.declineOrganizationInvite)
+	organizations.PUT("/current/policy", app.updateOrganizationPolicy)
+	organizations.GET("/current/keys", app.listSigningKeys)
+	organizations.POST("/current/keys", app.createSigningKey)
+
 	containerImages := apiv1.Group("/container-images")
+	containerImages.GET("", app.listContainerImages)
 	containerImages.POST("", app.pushToContainerRegistry)
+	containerImages.POST("/import", app.importContainerImage)
+	containerImages.POST("/build", app.buildContainerImage)
+	containerImages.GET("/bases", app.listBuildBases)
+	containerImages.GET("/jobs/:id", app.getPushJob)
+	containerImages.GET("/jobs/:id/events", app.streamPushJob)
+	containerImages.GET("/usage", app.getStorageUsage)
+	containerImages.GET("/diff", app.diffContainerImages)
+	containerImages.GET("/retention/policies", app.listRetentionPolicies)
+	containerImages.PUT("/retention/policies/:repository", app.putRetentionPolicy)
+	containerImages.GET("/retention/report", app.getRetentionReport)
+	containerImages.GET("/retention/metrics", app.getRetentionMetrics)
+	containerImages.GET("/rebase/outdated", app.listOutdatedImages)
+	containerImages.GET("/:fqin/sbom", app.getContainerImageSBOM)
+	containerImages.POST("/:fqin/sign", app.signContainerImage)
+	containerImages.POST("/:fqin/mutate", app.mutateContainerImage)
+	containerImages.GET("/:fqin/lineage", app.getContainerImageLineage)
+	containerImages.GET("/:fqin/base", app.getImageBase)
+	containerImages.PUT("/:fqin/base", app.declareImageBase)
+	containerImages.POST("/:fqin/rebase", app.rebaseContainerImage)
+	containerImages.GET("/:fqin/files", app.listImageFiles)
+	containerImages.GET("/:fqin/files/content", app.getImageFile)
 
 	deployments := apiv1.Group("/deployments")
 	deployments.GET("/:name", app.getDeploymentByName)
 	deployments.GET("", app.listDeployments)
 	deployments.POST("", app.deploy)
+	deployments.DELETE("/:name", app.deleteDeployment)
+	deployments.POST("/:name/preview", app.previewDeployment)
+	deployments.GET("/:name/revisions", app.listDeploymentRevisions)
+	deployments.POST("/:name/rollback", app.rollbackDeployment)
+	deployments.POST("/admission", app.checkAdmission)
+
+	apiv1.GET("/tiers", app.listTiers)
+
+	apiv1.GET("/operations/:id", app.getOperation)
+	apiv1.GET("/operations/:id/events", app.streamOperationEvents)
 }
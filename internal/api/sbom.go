@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/digizyne/lfcont/internal/jobs"
+	"github.com/digizyne/lfcont/internal/sbom"
+	"github.com/digizyne/lfcont/internal/vuln"
+	"github.com/digizyne/lfcont/tools"
+)
+
+// generateSBOM scans the image layers, stores a CycloneDX SBOM keyed by the
+// recorded digest of fqin and matches it against the advisory database. For a
+// multi-arch index img is the child that runs on Cloud Run. Failures are
+// logged but do not fail the push.
+func (app *App) generateSBOM(ctx context.Context, r *jobs.Reporter, fqin string, digest v1.Hash, img v1.Image) {
+	r.Stage("generating sbom")
+	pkgs, err := sbom.Scan(img)
+	if err != nil {
+		log.Printf("Warning: failed to scan %s for SBOM: %v", fqin, err)
+		return
+	}
+
+	document, err := json.Marshal(sbom.NewCycloneDX(fqin, digest.String(), pkgs))
+	if err != nil {
+		log.Printf("Warning: failed to encode SBOM for %s: %v", fqin, err)
+		return
+	}
+
+	_, err = app.Pool.Exec(ctx, `
+		INSERT INTO sboms (digest, format, document)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (digest) DO UPDATE SET format = EXCLUDED.format, document = EXCLUDED.document, created_at = now()
+	`, digest.String(), sbom.FormatCycloneDX, document)
+	if err != nil {
+		log.Printf("Warning: failed to store SBOM for %s: %v", fqin, err)
+		return
+	}
+	log.Printf("Stored SBOM for %s with %d packages", fqin, len(pkgs))
+
+	r.Stage("matching vulnerabilities")
+	findings, err := vuln.Scan(ctx, app.Pool, digest.String(), pkgs)
+	if err != nil {
+		log.Printf("Warning: failed to match vulnerabilities for %s: %v", fqin, err)
+		return
+	}
+	log.Printf("Found %d known vulnerabilities in %s", len(findings), fqin)
+}
+
+func (app *App) getContainerImageSBOM(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	fqin := c.Param("fqin")
+	ctx := c.Request.Context()
+
+	var document []byte
+	err = app.Pool.QueryRow(ctx, `
+		SELECT s.document
+		FROM container_images ci
+		JOIN sboms s ON s.digest = ci.digest
+		WHERE ci.fqin = $1 AND ci.username = $2
+	`, fqin, userClaims.Username).Scan(&document)
+	if err == pgx.ErrNoRows {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "no SBOM found for container image",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading SBOM for %s: %v", fqin, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to load SBOM",
+		})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/vnd.cyclonedx+json", document)
+}
+
+// imageVulnerabilities returns the finding counts for a recorded image, or nil
+// when the image was not pushed through the controller and has no inventory.
+func (app *App) imageVulnerabilities(ctx context.Context, fqin string) (*vuln.Counts, error) {
+	var digest string
+	err := app.Pool.QueryRow(ctx, `
+		SELECT ci.digest
+		FROM container_images ci
+		JOIN sboms s ON s.digest = ci.digest
+		WHERE ci.fqin = $1
+	`, fqin).Scan(&digest)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	counts, err := vuln.CountsByDigest(ctx, app.Pool, []string{digest})
+	if err != nil {
+		return nil, err
+	}
+	imageCounts := counts[digest]
+	return &imageCounts, nil
+}
+
+// storedPackages returns the inventory recorded for an image digest, or nil
+// when no SBOM was generated for it.
+func (app *App) storedPackages(ctx context.Context, digest string) ([]sbom.Package, error) {
+	var document []byte
+	err := app.Pool.QueryRow(ctx, `SELECT document FROM sboms WHERE digest = $1`, digest).Scan(&document)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc sbom.Document
+	if err := json.Unmarshal(document, &doc); err != nil {
+		return nil, err
+	}
+	return doc.Packages(), nil
+}
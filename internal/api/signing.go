@@ -0,0 +1,319 @@
+package api
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/digizyne/lfcont/internal/data/models"
+	"github.com/digizyne/lfcont/internal/signing"
+	"github.com/digizyne/lfcont/tools"
+)
+
+type CreateSigningKeyRequest struct {
+	Name string `json:"name" binding:"required,min=1,max=64"`
+	// PublicKey registers an externally held key (e.g. from 'cosign
+	// generate-key-pair'). When empty, the controller generates a key pair and
+	// keeps the private key for the sign endpoint.
+	PublicKey string `json:"public_key"`
+}
+
+type SignImageRequest struct {
+	Key string `json:"key" binding:"required"`
+}
+
+func (app *App) createSigningKey(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	var req CreateSigningKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{
+			"error":   "invalid request payload",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	organization, ok := app.organizationAdmin(c, userClaims.Username)
+	if !ok {
+		return
+	}
+
+	var publicKey string
+	var sealed []byte
+	if req.PublicKey != "" {
+		if _, err := signing.ParsePublicKey(req.PublicKey); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("invalid public key: %v", err),
+			})
+			return
+		}
+		publicKey = req.PublicKey
+	} else {
+		secret := os.Getenv("SIGNING_KEY_SECRET")
+		if secret == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "server-side key generation is disabled; register a public key instead",
+			})
+			return
+		}
+		key, encoded, err := signing.GenerateKey()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to generate key: %v", err),
+			})
+			return
+		}
+		sealed, err = signing.SealPrivateKey(key, secret)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to store key: %v", err),
+			})
+			return
+		}
+		publicKey = encoded
+	}
+
+	tag, err := app.Pool.Exec(ctx, `
+		INSERT INTO signing_keys (organization, name, public_key, private_key)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING
+	`, organization, req.Name, publicKey, sealed)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to store key: %v", err),
+		})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error": "a key with this name already exists",
+		})
+		return
+	}
+
+	log.Printf("User %s added signing key %s to organization %s", userClaims.Username, req.Name, organization)
+	c.JSON(http.StatusCreated, gin.H{
+		"name":       req.Name,
+		"public_key": publicKey,
+		"can_sign":   sealed != nil,
+	})
+}
+
+func (app *App) listSigningKeys(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	organization, err := app.userOrganization(ctx, userClaims.Username)
+	if err != nil || organization == "" {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "user does not belong to an organization",
+		})
+		return
+	}
+
+	keys, err := app.signingKeys(ctx, organization)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to load keys: %v", err),
+		})
+		return
+	}
+
+	response := []gin.H{}
+	for _, key := range keys {
+		response = append(response, gin.H{
+			"name":       key.Name,
+			"public_key": key.PublicKey,
+			"can_sign":   key.PrivateKey != nil,
+			"created_at": key.CreatedAt,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"keys": response,
+	})
+}
+
+func (app *App) signContainerImage(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	userClaims, err := tools.GetUserClaims(authHeader)
+	if err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	var req SignImageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{
+			"error":   "invalid request payload",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	fqin := c.Param("fqin")
+	ctx := c.Request.Context()
+
+	var digest string
+	err = app.Pool.QueryRow(ctx, `
+		SELECT COALESCE(digest, '') FROM container_images WHERE fqin = $1 AND username = $2
+	`, fqin, userClaims.Username).Scan(&digest)
+	if err == pgx.ErrNoRows {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "container image not found",
+		})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to load container image: %v", err),
+		})
+		return
+	}
+
+	organization, err := app.userOrganization(ctx, userClaims.Username)
+	if err != nil || organization == "" {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "user does not belong to an organization",
+		})
+		return
+	}
+
+	var sealed []byte
+	err = app.Pool.QueryRow(ctx, `
+		SELECT private_key FROM signing_keys WHERE organization = $1 AND name = $2
+	`, organization, req.Key).Scan(&sealed)
+	if err == pgx.ErrNoRows {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "signing key not found",
+		})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to load signing key: %v", err),
+		})
+		return
+	}
+	if sealed == nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "signing key has no private key on the server; sign with cosign instead",
+		})
+		return
+	}
+	key, err := signing.OpenPrivateKey(sealed, os.Getenv("SIGNING_KEY_SECRET"))
+	if err != nil {
+		log.Printf("Signing key error: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to unlock signing key",
+		})
+		return
+	}
+
+	ref, err := name.ParseReference(fqin)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("invalid image reference: %v", err),
+		})
+		return
+	}
+	opts := app.Credentials.RemoteOptions(ctx)
+	if digest == "" {
+		desc, err := remote.Head(ref, opts...)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{
+				"error": fmt.Sprintf("Failed to resolve image digest: %v", err),
+			})
+			return
+		}
+		digest = desc.Digest.String()
+	}
+	signed := ref.Context().Digest(digest)
+
+	if err := signing.Sign(ctx, signed, key, opts...); err != nil {
+		log.Printf("Signing error: %v", err)
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{
+			"error": fmt.Sprintf("Failed to sign image: %v", err),
+		})
+		return
+	}
+
+	log.Printf("User %s signed %s with key %s", userClaims.Username, signed, req.Key)
+	c.JSON(http.StatusOK, gin.H{
+		"fqin":   fqin,
+		"digest": digest,
+		"key":    req.Key,
+	})
+}
+
+func (app *App) signingKeys(ctx context.Context, organization string) ([]models.SigningKey, error) {
+	rows, err := app.Pool.Query(ctx, `
+		SELECT organization, name, public_key, private_key, created_at
+		FROM signing_keys
+		WHERE organization = $1
+		ORDER BY name
+	`, organization)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[models.SigningKey])
+}
+
+// imageSignedBy returns the name of the organization key that signed digest,
+// or "" if none of its keys did.
+func (app *App) imageSignedBy(ctx context.Context, organization string, digest name.Digest) (string, error) {
+	if organization == "" {
+		return "", nil
+	}
+	stored, err := app.signingKeys(ctx, organization)
+	if err != nil {
+		return "", err
+	}
+	trusted := map[string]crypto.PublicKey{}
+	for _, key := range stored {
+		publicKey, err := signing.ParsePublicKey(key.PublicKey)
+		if err != nil {
+			log.Printf("Warning: skipping unreadable signing key %s/%s: %v", organization, key.Name, err)
+			continue
+		}
+		trusted[key.Name] = publicKey
+	}
+	if len(trusted) == 0 {
+		return "", nil
+	}
+
+	keyName, err := signing.Verify(ctx, digest, trusted, app.Credentials.RemoteOptions(ctx)...)
+	if errors.Is(err, signing.ErrNoSignature) {
+		return "", nil
+	}
+	return keyName, err
+}
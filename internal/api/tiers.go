@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/digizyne/lfcont/internal/tiers"
+	"github.com/digizyne/lfcont/tools"
+)
+
+// errTierNotAllowed is returned when a tier is not part of the user's plan.
+var errTierNotAllowed = errors.New("tier not allowed")
+
+// deploymentTier resolves the tier a rollout uses: the requested one, else
+// the tier the deployment already has, else the catalog's default. Only a
+// requested tier is checked against the user's plan, so a deployment keeps
+// its tier when the plan changes. A stored tier that is no longer in the
+// catalog, such as one recorded before tiers were applied, falls back to the
+// default.
+func (app *App) deploymentTier(ctx context.Context, username, requested, existing string) (string, tiers.Tier, error) {
+	if requested == "" {
+		name, settings, err := app.Tiers.Resolve(existing)
+		if err != nil {
+			log.Printf("Deployment tier %q is not in the catalog, using %s", existing, app.Tiers.Default)
+			return app.Tiers.Resolve("")
+		}
+		return name, settings, nil
+	}
+	name, settings, err := app.Tiers.Resolve(requested)
+	if err != nil {
+		return "", tiers.Tier{}, err
+	}
+	if err := app.checkTierEntitlement(ctx, username, name, settings); err != nil {
+		return "", tiers.Tier{}, err
+	}
+	return name, settings, nil
+}
+
+// checkTierEntitlement returns errTierNotAllowed unless the plan of username
+// (users.tier) may deploy on the tier.
+func (app *App) checkTierEntitlement(ctx context.Context, username, name string, settings tiers.Tier) error {
+	var plan string
+	if err := app.Pool.QueryRow(ctx, `SELECT tier FROM users WHERE username = $1`, username).Scan(&plan); err != nil {
+		return fmt.Errorf("Failed to load plan: %v", err)
+	}
+	if !settings.Allows(plan) {
+		return fmt.Errorf("%w: %s is not available on the %s plan", errTierNotAllowed, name, plan)
+	}
+	return nil
+}
+
+func (app *App) listTiers(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	if _, err := tools.GetUserClaims(authHeader); err != nil {
+		log.Printf("Authentication error: %v", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, app.Tiers)
+}
+
+// validateTier aborts the request when it names a tier that is not in the
+// catalog or not part of the user's plan. An empty tier is valid and
+// resolved by the worker.
+func (app *App) validateTier(c *gin.Context, username, tier string) bool {
+	if tier == "" {
+		return true
+	}
+	name, settings, err := app.Tiers.Resolve(tier)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Invalid tier: %v", err),
+		})
+		return false
+	}
+	err = app.checkTierEntitlement(c.Request.Context(), username, name, settings)
+	if errors.Is(err, errTierNotAllowed) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": "access denied - " + err.Error(),
+		})
+		return false
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return false
+	}
+	return true
+}
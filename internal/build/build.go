@@ -0,0 +1,271 @@
+package build
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// Base is an image artifacts can be layered onto.
+type Base struct {
+	Name        string `json:"name"`
+	Image       string `json:"image"`
+	Description string `json:"description"`
+	// Dir is where the artifact is unpacked.
+	Dir string `json:"dir"`
+	// UID and GID own the unpacked files, matching the user the base runs as.
+	UID int `json:"-"`
+	GID int `json:"-"`
+	// NeedsEntrypoint is set for bases without a useful entrypoint of their
+	// own; the artifact must provide the program to run.
+	NeedsEntrypoint bool `json:"needs_entrypoint"`
+}
+
+// Bases are the supported base images. All run as non-root.
+var Bases = map[string]Base{
+	"distroless-static": {
+		Name:            "distroless-static",
+		Image:           "gcr.io/distroless/static-debian12:nonroot",
+		Description:     "Statically linked binaries (Go with CGO_ENABLED=0, Rust musl)",
+		Dir:             "/app",
+		UID:             65532,
+		GID:             65532,
+		NeedsEntrypoint: true,
+	},
+	"distroless-base": {
+		Name:            "distroless-base",
+		Image:           "gcr.io/distroless/base-debian12:nonroot",
+		Description:     "Dynamically linked binaries that need glibc and OpenSSL",
+		Dir:             "/app",
+		UID:             65532,
+		GID:             65532,
+		NeedsEntrypoint: true,
+	},
+	"nginx-static": {
+		Name:        "nginx-static",
+		Image:       "nginxinc/nginx-unprivileged:stable-alpine",
+		Description: "Static websites served by nginx on port 8080",
+		Dir:         "/usr/share/nginx/html",
+		UID:         101,
+		GID:         101,
+	},
+}
+
+// SortedBases returns Bases ordered by name.
+func SortedBases() []Base {
+	list := make([]Base, 0, len(Bases))
+	for _, base := range Bases {
+		list = append(list, base)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Options are the runtime settings applied on top of the base config.
+type Options struct {
+	Entrypoint []string
+	Env        []string
+	Port       string
+}
+
+// ArtifactLayer rewrites the tar (optionally gzipped) archive at src so that
+// its files live under dir and belong to uid:gid, and returns it as a layer.
+// The layer reads the rewritten archive from a temporary file that the caller
+// removes once the layer has been uploaded.
+func ArtifactLayer(src, dir string, uid, gid int) (v1.Layer, string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, "", err
+	}
+	defer in.Close()
+
+	buffered := bufio.NewReader(in)
+	var r io.Reader = buffered
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzr, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid gzip data: %v", err)
+		}
+		defer gzr.Close()
+		r = gzr
+	}
+
+	out, err := os.CreateTemp("", "artifact-*.tar")
+	if err != nil {
+		return nil, "", err
+	}
+	if err := rewrite(tar.NewReader(r), tar.NewWriter(out), dir, uid, gid); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return nil, "", err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return nil, "", err
+	}
+
+	layer, err := tarball.LayerFromFile(out.Name())
+	if err != nil {
+		os.Remove(out.Name())
+		return nil, "", err
+	}
+	return layer, out.Name(), nil
+}
+
+func rewrite(tr *tar.Reader, tw *tar.Writer, dir string, uid, gid int) error {
+	dir = path.Clean("/" + dir)
+	written := map[string]bool{}
+	mkdir := func(name string, modTime time.Time) error {
+		var parents []string
+		for d := name; d != "/" && !written[d]; d = path.Dir(d) {
+			parents = append(parents, d)
+		}
+		for i := len(parents) - 1; i >= 0; i-- {
+			written[parents[i]] = true
+			hdr := &tar.Header{
+				Typeflag: tar.TypeDir,
+				Name:     strings.TrimPrefix(parents[i], "/") + "/",
+				Mode:     0o755,
+				Uid:      uid,
+				Gid:      gid,
+				ModTime:  modTime,
+			}
+			// Directories above dir, such as /usr, belong to the base image
+			// and stay owned by root
+			if strings.HasPrefix(dir, parents[i]+"/") {
+				hdr.Uid, hdr.Gid = 0, 0
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	files := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid tar archive: %v", err)
+		}
+
+		name := targetPath(dir, hdr.Name)
+		if err := mkdir(path.Dir(name), hdr.ModTime); err != nil {
+			return err
+		}
+
+		out := &tar.Header{
+			Name:    strings.TrimPrefix(name, "/"),
+			Mode:    hdr.Mode & 0o777,
+			Uid:     uid,
+			Gid:     gid,
+			ModTime: hdr.ModTime,
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if written[name] {
+				continue
+			}
+			written[name] = true
+			out.Typeflag = tar.TypeDir
+			out.Name += "/"
+		case tar.TypeReg:
+			out.Typeflag = tar.TypeReg
+			out.Size = hdr.Size
+			files++
+		case tar.TypeSymlink:
+			// Relative links stay inside the artifact; absolute ones are
+			// kept as-is so links into the base image work.
+			out.Typeflag = tar.TypeSymlink
+			out.Linkname = hdr.Linkname
+		case tar.TypeLink:
+			out.Typeflag = tar.TypeLink
+			out.Linkname = strings.TrimPrefix(targetPath(dir, hdr.Linkname), "/")
+		default:
+			return fmt.Errorf("unsupported entry %s in artifact", hdr.Name)
+		}
+
+		if err := tw.WriteHeader(out); err != nil {
+			return err
+		}
+		if out.Typeflag == tar.TypeReg {
+			if _, err := io.Copy(tw, tr); err != nil {
+				return err
+			}
+		}
+	}
+	if files == 0 {
+		return fmt.Errorf("artifact contains no files")
+	}
+	return tw.Close()
+}
+
+// targetPath places an archive path under dir. Cleaning the path as an
+// absolute one drops any leading "..", so entries cannot escape dir.
+func targetPath(dir, name string) string {
+	return path.Join(dir, path.Clean("/"+name))
+}
+
+// Image appends layer to base and applies opts to the config.
+func Image(base v1.Image, layer v1.Layer, dir string, opts Options) (v1.Image, error) {
+	img, err := mutate.Append(base, mutate.Addendum{
+		Layer: layer,
+		History: v1.History{
+			CreatedBy: "lfcont build: artifact in " + dir,
+			Created:   v1.Time{Time: time.Now()},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	config := *cfg.Config.DeepCopy()
+	if len(opts.Entrypoint) > 0 {
+		config.Entrypoint = opts.Entrypoint
+		config.Cmd = nil
+		config.WorkingDir = dir
+	}
+	config.Env = mergeEnv(config.Env, opts.Env)
+	if opts.Port != "" {
+		config.ExposedPorts = map[string]struct{}{opts.Port + "/tcp": {}}
+	}
+	return mutate.Config(img, config)
+}
+
+// mergeEnv overrides variables of base with those in extra.
+func mergeEnv(base, extra []string) []string {
+	merged := append([]string(nil), base...)
+	for _, kv := range extra {
+		key, _, _ := strings.Cut(kv, "=")
+		replaced := false
+		for i, existing := range merged {
+			if k, _, _ := strings.Cut(existing, "="); k == key {
+				merged[i] = kv
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, kv)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,157 @@
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// entry is a tar entry of an artifact or of a rewritten layer.
+type entry struct {
+	name     string
+	typeflag byte
+	linkname string
+	uid, gid int
+}
+
+func artifact(t *testing.T, entries ...entry) *tar.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: 0o4755, Uid: 1000, Gid: 1000}
+		var body string
+		if e.typeflag == tar.TypeReg {
+			body = "contents of " + e.name
+			hdr.Size = int64(len(body))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return tar.NewReader(&buf)
+}
+
+func TestRewrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		dir     string
+		in      []entry
+		want    []entry
+		wantErr string
+	}{
+		{
+			name: "files are owned by the runtime user",
+			dir:  "/app",
+			in: []entry{
+				{name: "server", typeflag: tar.TypeReg},
+				{name: "static/", typeflag: tar.TypeDir},
+				{name: "static/index.html", typeflag: tar.TypeReg},
+			},
+			want: []entry{
+				{name: "app/", typeflag: tar.TypeDir, uid: 65532, gid: 65532},
+				{name: "app/server", typeflag: tar.TypeReg, uid: 65532, gid: 65532},
+				{name: "app/static/", typeflag: tar.TypeDir, uid: 65532, gid: 65532},
+				{name: "app/static/index.html", typeflag: tar.TypeReg, uid: 65532, gid: 65532},
+			},
+		},
+		{
+			name: "directories above dir stay owned by root",
+			dir:  "/usr/share/nginx/html",
+			in:   []entry{{name: "css/site.css", typeflag: tar.TypeReg}},
+			want: []entry{
+				{name: "usr/", typeflag: tar.TypeDir},
+				{name: "usr/share/", typeflag: tar.TypeDir},
+				{name: "usr/share/nginx/", typeflag: tar.TypeDir},
+				{name: "usr/share/nginx/html/", typeflag: tar.TypeDir, uid: 65532, gid: 65532},
+				{name: "usr/share/nginx/html/css/", typeflag: tar.TypeDir, uid: 65532, gid: 65532},
+				{name: "usr/share/nginx/html/css/site.css", typeflag: tar.TypeReg, uid: 65532, gid: 65532},
+			},
+		},
+		{
+			name: "paths cannot escape dir",
+			dir:  "/app",
+			in: []entry{
+				{name: "../../etc/passwd", typeflag: tar.TypeReg},
+				{name: "/bin/sh", typeflag: tar.TypeLink, linkname: "../etc/passwd"},
+			},
+			want: []entry{
+				{name: "app/", typeflag: tar.TypeDir, uid: 65532, gid: 65532},
+				{name: "app/etc/", typeflag: tar.TypeDir, uid: 65532, gid: 65532},
+				{name: "app/etc/passwd", typeflag: tar.TypeReg, uid: 65532, gid: 65532},
+				{name: "app/bin/", typeflag: tar.TypeDir, uid: 65532, gid: 65532},
+				{name: "app/bin/sh", typeflag: tar.TypeLink, linkname: "app/etc/passwd", uid: 65532, gid: 65532},
+			},
+		},
+		{
+			name: "symlinks keep their targets",
+			dir:  "/app",
+			in: []entry{
+				{name: "server", typeflag: tar.TypeReg},
+				{name: "current", typeflag: tar.TypeSymlink, linkname: "server"},
+				{name: "certs", typeflag: tar.TypeSymlink, linkname: "/etc/ssl/certs"},
+			},
+			want: []entry{
+				{name: "app/", typeflag: tar.TypeDir, uid: 65532, gid: 65532},
+				{name: "app/server", typeflag: tar.TypeReg, uid: 65532, gid: 65532},
+				{name: "app/current", typeflag: tar.TypeSymlink, linkname: "server", uid: 65532, gid: 65532},
+				{name: "app/certs", typeflag: tar.TypeSymlink, linkname: "/etc/ssl/certs", uid: 65532, gid: 65532},
+			},
+		},
+		{
+			name:    "devices are rejected",
+			dir:     "/app",
+			in:      []entry{{name: "null", typeflag: tar.TypeChar}},
+			wantErr: "unsupported entry",
+		},
+		{
+			name:    "an artifact without files is rejected",
+			dir:     "/app",
+			in:      []entry{{name: "empty/", typeflag: tar.TypeDir}},
+			wantErr: "no files",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := rewrite(artifact(t, tt.in...), tar.NewWriter(&out), tt.dir, 65532, 65532)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("rewrite() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []entry
+			tr := tar.NewReader(&out)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if hdr.Mode&^0o777 != 0 {
+					t.Errorf("%s: mode %o keeps special bits", hdr.Name, hdr.Mode)
+				}
+				got = append(got, entry{name: hdr.Name, typeflag: hdr.Typeflag, linkname: hdr.Linkname, uid: hdr.Uid, gid: hdr.Gid})
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("rewrite() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrgoogle "github.com/google/go-containerregistry/pkg/v1/google"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/option"
+)
+
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// Source selects how the controller authenticates to the container registry.
+type Source string
+
+const (
+	SourceADC          Source = "adc"
+	SourceDockerConfig Source = "docker-config"
+	SourceBasic        Source = "basic"
+	SourceKeyFile      Source = "key-file"
+)
+
+// Config describes where credentials come from. It is normally populated from
+// the environment with ConfigFromEnv.
+type Config struct {
+	RegistrySource   Source
+	RegistryHost     string
+	RegistryUsername string
+	RegistryPassword string
+	KeyFile          string
+}
+
+// Provider hands out credentials for the registry and the Google Cloud API
+// clients (Cloud Run, Monitoring, Logging). It is built and validated once at
+// startup so that a misconfiguration fails fast instead of on the first push.
+type Provider struct {
+	source        Source
+	keyFile       string
+	keychain      authn.Keychain
+	googleCreds   *google.Credentials
+	clientOptions []option.ClientOption
+}
+
+// ConfigFromEnv reads the credential configuration from the environment.
+//
+//	REGISTRY_AUTH       adc (default), docker-config, basic or key-file
+//	REGISTRY_USERNAME   username for basic auth
+//	REGISTRY_PASSWORD   password for basic auth
+//	CREDENTIALS_FILE    mounted service account key, used for key-file and
+//	                    for the Google Cloud clients when set
+//	AR_REPO_URL         registry repository the controller pushes to
+func ConfigFromEnv() Config {
+	source := Source(os.Getenv("REGISTRY_AUTH"))
+	if source == "" {
+		source = SourceADC
+	}
+	return Config{
+		RegistrySource:   source,
+		RegistryHost:     registryHost(os.Getenv("AR_REPO_URL")),
+		RegistryUsername: os.Getenv("REGISTRY_USERNAME"),
+		RegistryPassword: os.Getenv("REGISTRY_PASSWORD"),
+		KeyFile:          os.Getenv("CREDENTIALS_FILE"),
+	}
+}
+
+// NewProvider resolves and validates the configured credentials. Google
+// credentials are exercised by fetching a token, so an expired or malformed
+// key is reported here rather than by the first API call that needs it.
+func NewProvider(ctx context.Context, cfg Config) (*Provider, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if cfg.RegistryHost == "" {
+		return nil, fmt.Errorf("AR_REPO_URL must name the registry repository the controller pushes to")
+	}
+	p := &Provider{source: cfg.RegistrySource, keyFile: cfg.KeyFile}
+
+	if cfg.KeyFile != "" || cfg.RegistrySource == SourceKeyFile {
+		if cfg.KeyFile == "" {
+			return nil, fmt.Errorf("REGISTRY_AUTH=%s requires CREDENTIALS_FILE to point at a service account key", SourceKeyFile)
+		}
+		key, err := readServiceAccountKey(cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		creds, err := google.CredentialsFromJSON(ctx, key, cloudPlatformScope)
+		if err != nil {
+			return nil, fmt.Errorf("invalid service account key %s: %v", cfg.KeyFile, err)
+		}
+		p.googleCreds = creds
+	} else {
+		creds, err := google.FindDefaultCredentials(ctx, cloudPlatformScope)
+		if err != nil {
+			return nil, fmt.Errorf("no application default credentials found (set GOOGLE_APPLICATION_CREDENTIALS or CREDENTIALS_FILE): %v", err)
+		}
+		p.googleCreds = creds
+	}
+	if _, err := p.googleCreds.TokenSource.Token(); err != nil {
+		return nil, fmt.Errorf("google credentials could not produce an access token: %v", err)
+	}
+	p.clientOptions = []option.ClientOption{option.WithCredentials(p.googleCreds)}
+
+	var registryAuth authn.Authenticator
+	switch cfg.RegistrySource {
+	case SourceADC:
+		registryAuth = ggcrgoogle.NewTokenSourceAuthenticator(p.googleCreds.TokenSource)
+	case SourceKeyFile:
+		registryAuth = ggcrgoogle.NewJSONKeyAuthenticator(string(p.googleCreds.JSON))
+	case SourceBasic:
+		if cfg.RegistryUsername == "" || cfg.RegistryPassword == "" {
+			return nil, fmt.Errorf("REGISTRY_AUTH=%s requires REGISTRY_USERNAME and REGISTRY_PASSWORD", SourceBasic)
+		}
+		registryAuth = &authn.Basic{Username: cfg.RegistryUsername, Password: cfg.RegistryPassword}
+	case SourceDockerConfig:
+		registry, err := name.NewRegistry(cfg.RegistryHost)
+		if err != nil {
+			return nil, fmt.Errorf("invalid registry host %q: %v", cfg.RegistryHost, err)
+		}
+		auth, err := authn.Resolve(ctx, authn.DefaultKeychain, registry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read docker config.json: %v", err)
+		}
+		if auth == authn.Anonymous {
+			return nil, fmt.Errorf("docker config.json has no credentials for %s", cfg.RegistryHost)
+		}
+		p.keychain = authn.DefaultKeychain
+		return p, nil
+	default:
+		return nil, fmt.Errorf("unknown REGISTRY_AUTH %q (expected %s, %s, %s or %s)", cfg.RegistrySource, SourceADC, SourceDockerConfig, SourceBasic, SourceKeyFile)
+	}
+
+	p.keychain = &hostKeychain{host: cfg.RegistryHost, auth: registryAuth}
+	return p, nil
+}
+
+// NewRegistryProvider returns a Provider that authenticates to registry host
+// with auth and has no Google credentials, for registries outside Google
+// Cloud such as an in-process one.
+func NewRegistryProvider(source Source, host string, auth authn.Authenticator) *Provider {
+	return &Provider{source: source, keychain: &hostKeychain{host: host, auth: auth}}
+}
+
+// Source reports which registry credential source is active.
+func (p *Provider) Source() Source {
+	return p.source
+}
+
+// Keychain returns the keychain used for registry operations.
+func (p *Provider) Keychain() authn.Keychain {
+	return p.keychain
+}
+
+// RemoteOptions returns the go-containerregistry options for talking to the
+// backing registry.
+func (p *Provider) RemoteOptions(ctx context.Context) []remote.Option {
+	return []remote.Option{
+		remote.WithAuthFromKeychain(p.keychain),
+		remote.WithContext(ctx),
+	}
+}
+
+// ClientOptions returns the options for Google Cloud API clients.
+func (p *Provider) ClientOptions() []option.ClientOption {
+	return p.clientOptions
+}
+
+// KeyFile returns the mounted service account key path, if one is configured.
+func (p *Provider) KeyFile() string {
+	return p.keyFile
+}
+
+// hostKeychain returns a fixed authenticator for a single registry host and
+// anonymous access for everything else. Without a host the credentials are
+// never sent, since references that users supply can name any registry.
+type hostKeychain struct {
+	host string
+	auth authn.Authenticator
+}
+
+func (k *hostKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	if k.host != "" && target.RegistryStr() == k.host {
+		return k.auth, nil
+	}
+	return authn.Anonymous, nil
+}
+
+func readServiceAccountKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account key %s: %v", path, err)
+	}
+	var parsed struct {
+		Type        string `json:"type"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+	}
+	if err := json.Unmarshal(key, &parsed); err != nil {
+		return nil, fmt.Errorf("service account key %s is not valid JSON: %v", path, err)
+	}
+	if parsed.Type != "service_account" || parsed.ClientEmail == "" || parsed.PrivateKey == "" {
+		return nil, fmt.Errorf("service account key %s is missing type, client_email or private_key", path)
+	}
+	return key, nil
+}
+
+func registryHost(repoURL string) string {
+	repoURL = strings.TrimPrefix(strings.TrimPrefix(repoURL, "https://"), "http://")
+	host, _, _ := strings.Cut(repoURL, "/")
+	return host
+}
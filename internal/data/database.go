@@ -26,8 +26,18 @@ func InitializeDatabase() (*pgxpool.Pool, error) {
 		fn   func(*pgxpool.Pool) error
 	}{
 		{"users", models.MigrateUserTable},
+		{"organizations", models.MigrateOrganizationTable},
+		{"signing_keys", models.MigrateSigningKeyTable},
 		{"container_images", models.MigrateContainerImageTable},
 		{"deployments", models.MigrateDeploymentTable},
+		{"deployment_secrets", models.MigrateDeploymentSecretTable},
+		{"sboms", models.MigrateSbomTable},
+		{"advisories", models.MigrateAdvisoryTables},
+		{"vulnerability_findings", models.MigrateVulnerabilityFindingTable},
+		{"retention", models.MigrateRetentionTables},
+		{"image_bases", models.MigrateImageBaseTable},
+		{"operations", models.MigrateOperationTable},
+		{"deployment_revisions", models.MigrateDeploymentRevisionTable},
 	}
 
 	for _, migration := range migrations {
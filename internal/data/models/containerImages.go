@@ -2,13 +2,33 @@ package models
 
 import (
 	"context"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type ContainerImage struct {
-	Fqin     string `json:"fqin"`
-	Username string `json:"username"`
+	Fqin      string    `json:"fqin"`
+	Digest    string    `json:"digest"`
+	Username  string    `json:"username"`
+	SizeBytes int64     `json:"size_bytes"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Images derived server-side from another image record where they came
+	// from and how.
+	ParentFqin   *string `json:"parent_fqin,omitempty"`
+	ParentDigest *string `json:"parent_digest,omitempty"`
+	Derivation   *string `json:"derivation,omitempty"`
+
+	// Platforms lists the per-platform child manifests of a multi-arch
+	// index; it is empty for single-platform images.
+	Platforms []ImagePlatform `json:"platforms,omitempty"`
+}
+
+type ImagePlatform struct {
+	Platform  string `json:"platform"`
+	Digest    string `json:"digest"`
+	SizeBytes int64  `json:"size_bytes"`
 }
 
 func MigrateContainerImageTable(pool *pgxpool.Pool) error {
@@ -18,6 +38,13 @@ func MigrateContainerImageTable(pool *pgxpool.Pool) error {
 			fqin TEXT PRIMARY KEY,
 			username TEXT NOT NULL REFERENCES users(username)
 		);
+		ALTER TABLE container_images ADD COLUMN IF NOT EXISTS digest TEXT;
+		ALTER TABLE container_images ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+		ALTER TABLE container_images ADD COLUMN IF NOT EXISTS size_bytes BIGINT NOT NULL DEFAULT 0;
+		ALTER TABLE container_images ADD COLUMN IF NOT EXISTS parent_fqin TEXT;
+		ALTER TABLE container_images ADD COLUMN IF NOT EXISTS parent_digest TEXT;
+		ALTER TABLE container_images ADD COLUMN IF NOT EXISTS derivation TEXT;
+		ALTER TABLE container_images ADD COLUMN IF NOT EXISTS platforms JSONB;
 	`)
 	return err
 }
@@ -11,6 +11,7 @@ type Deployment struct {
 	Url            string `json:"url"`
 	Tier           string `json:"tier"`
 	ContainerImage string `json:"container_image"`
+	ImageDigest    string `json:"image_digest"`
 	Username       string `json:"username"`
 }
 
@@ -24,6 +25,8 @@ func MigrateDeploymentTable(pool *pgxpool.Pool) error {
 			container_image TEXT NOT NULL REFERENCES container_images(fqin),
 			username TEXT NOT NULL REFERENCES users(username)
 		);
+		ALTER TABLE deployments ADD COLUMN IF NOT EXISTS image_digest TEXT;
+		ALTER TABLE deployments ADD COLUMN IF NOT EXISTS env JSONB NOT NULL DEFAULT '{}';
 	`)
 	return err
 }
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DeploymentRevision is a successful rollout of a deployment. Spec is the
+// deploy request that produced it, pinned to ImageDigest, so it can be
+// deployed again by a rollback.
+type DeploymentRevision struct {
+	Revision       int             `json:"revision"`
+	ContainerImage string          `json:"container_image"`
+	ImageDigest    string          `json:"image_digest"`
+	RuntimeDigest  string          `json:"runtime_digest"`
+	Spec           json.RawMessage `json:"spec"`
+	Username       string          `json:"username"`
+	OperationID    *string         `json:"operation_id,omitempty"`
+	PulumiVersion  *int            `json:"pulumi_update_version,omitempty"`
+	RollbackOf     *int            `json:"rollback_of,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// MigrateDeploymentRevisionTable creates the history of rollouts of each
+// deployment. Revisions are removed together with their deployment.
+func MigrateDeploymentRevisionTable(pool *pgxpool.Pool) error {
+	ctx := context.Background()
+	_, err := pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS deployment_revisions (
+			deployment_name TEXT NOT NULL REFERENCES deployments(name) ON DELETE CASCADE,
+			revision INTEGER NOT NULL,
+			container_image TEXT NOT NULL,
+			image_digest TEXT NOT NULL,
+			runtime_digest TEXT NOT NULL,
+			spec JSONB NOT NULL,
+			username TEXT NOT NULL REFERENCES users(username),
+			operation_id UUID REFERENCES operations(id) ON DELETE SET NULL,
+			pulumi_version INTEGER,
+			rollback_of INTEGER,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (deployment_name, revision)
+		);
+		CREATE INDEX IF NOT EXISTS deployment_revisions_digest_idx ON deployment_revisions (image_digest);
+	`)
+	return err
+}
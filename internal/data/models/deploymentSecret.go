@@ -0,0 +1,26 @@
+package models
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// MigrateDeploymentSecretTable creates the secrets injected into each
+// deployment. Values are envelope-encrypted: ciphertext is encrypted under a
+// per-secret data key, stored in wrapped_key encrypted under the controller's
+// DEPLOY_SECRETS_KEY.
+func MigrateDeploymentSecretTable(pool *pgxpool.Pool) error {
+	ctx := context.Background()
+	_, err := pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS deployment_secrets (
+			deployment_name TEXT NOT NULL REFERENCES deployments(name) ON DELETE CASCADE,
+			key TEXT NOT NULL,
+			wrapped_key BYTEA NOT NULL,
+			ciphertext BYTEA NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (deployment_name, key)
+		);
+	`)
+	return err
+}
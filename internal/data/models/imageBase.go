@@ -0,0 +1,37 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ImageBase records the base image a stored image was built on, and what the
+// base's tag pointed at when it was last checked.
+type ImageBase struct {
+	Fqin         string     `json:"fqin"`
+	BaseName     string     `json:"base_name"`
+	BaseDigest   string     `json:"base_digest"`
+	Source       string     `json:"source"`
+	LatestDigest *string    `json:"latest_digest"`
+	Outdated     bool       `json:"outdated"`
+	CheckedAt    *time.Time `json:"checked_at"`
+}
+
+func MigrateImageBaseTable(pool *pgxpool.Pool) error {
+	ctx := context.Background()
+	_, err := pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS image_bases (
+			fqin TEXT PRIMARY KEY REFERENCES container_images(fqin) ON DELETE CASCADE,
+			base_name TEXT NOT NULL,
+			base_digest TEXT NOT NULL,
+			source TEXT NOT NULL,
+			latest_digest TEXT,
+			outdated BOOLEAN NOT NULL DEFAULT false,
+			checked_at TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS image_bases_base_name_idx ON image_bases (base_name);
+	`)
+	return err
+}
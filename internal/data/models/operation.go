@@ -0,0 +1,46 @@
+package models
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// MigrateOperationTable creates the durable queue of long-running
+// operations such as deployments, and the progress events they emit.
+func MigrateOperationTable(pool *pgxpool.Pool) error {
+	ctx := context.Background()
+	_, err := pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS operations (
+			id UUID PRIMARY KEY,
+			kind TEXT NOT NULL,
+			key TEXT NOT NULL,
+			username TEXT NOT NULL REFERENCES users(username),
+			state TEXT NOT NULL DEFAULT 'queued',
+			payload JSONB NOT NULL,
+			result JSONB,
+			result_url TEXT,
+			error TEXT,
+			error_details JSONB,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			started_at TIMESTAMPTZ,
+			heartbeat_at TIMESTAMPTZ,
+			finished_at TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS operations_pending_idx ON operations (created_at) WHERE state IN ('queued', 'running');
+		CREATE TABLE IF NOT EXISTS operation_events (
+			id BIGSERIAL PRIMARY KEY,
+			operation_id UUID NOT NULL REFERENCES operations(id) ON DELETE CASCADE,
+			type TEXT NOT NULL,
+			resource TEXT,
+			resource_type TEXT,
+			op TEXT,
+			status TEXT,
+			message TEXT,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+		CREATE INDEX IF NOT EXISTS operation_events_operation_idx ON operation_events (operation_id, id);
+	`)
+	return err
+}
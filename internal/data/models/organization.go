@@ -0,0 +1,57 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/digizyne/lfcont/internal/admission"
+)
+
+type Organization struct {
+	Name   string             `json:"name"`
+	Policy OrganizationPolicy `json:"policy"`
+}
+
+// OrganizationInvite lets Username join Organization with Role once they
+// accept it.
+type OrganizationInvite struct {
+	Organization string    `json:"organization"`
+	Username     string    `json:"username"`
+	Role         string    `json:"role"`
+	InvitedBy    string    `json:"invited_by"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// OrganizationPolicy is stored as JSONB so that new settings do not need a
+// migration. Empty fields fall back to the controller defaults.
+type OrganizationPolicy struct {
+	SecretScanMode string            `json:"secret_scan_mode,omitempty"`
+	Admission      *admission.Config `json:"admission,omitempty"`
+	// StorageQuotaBytes caps the compressed image bytes of all members
+	// together. Zero means unlimited.
+	StorageQuotaBytes int64 `json:"storage_quota_bytes,omitempty"`
+}
+
+func MigrateOrganizationTable(pool *pgxpool.Pool) error {
+	ctx := context.Background()
+	_, err := pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS organizations (
+			name TEXT PRIMARY KEY
+		);
+		ALTER TABLE organizations ADD COLUMN IF NOT EXISTS policy JSONB NOT NULL DEFAULT '{}';
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS organization TEXT REFERENCES organizations(name);
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS organization_role TEXT CHECK (organization_role IN ('admin', 'member'));
+
+		CREATE TABLE IF NOT EXISTS organization_invites (
+			organization TEXT NOT NULL REFERENCES organizations(name) ON DELETE CASCADE,
+			username TEXT NOT NULL REFERENCES users(username) ON DELETE CASCADE,
+			role TEXT NOT NULL CHECK (role IN ('admin', 'member')),
+			invited_by TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (organization, username)
+		);
+	`)
+	return err
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// MigrateRetentionTables creates the per-repository retention policies and
+// the log of what the collector reclaimed.
+func MigrateRetentionTables(pool *pgxpool.Pool) error {
+	ctx := context.Background()
+	_, err := pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS retention_policies (
+			username TEXT NOT NULL REFERENCES users(username),
+			repository TEXT NOT NULL,
+			keep_last INTEGER NOT NULL DEFAULT 0,
+			keep_days INTEGER NOT NULL DEFAULT 0,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (username, repository)
+		);
+		CREATE TABLE IF NOT EXISTS retention_reclaims (
+			id BIGSERIAL PRIMARY KEY,
+			username TEXT NOT NULL REFERENCES users(username),
+			repository TEXT NOT NULL,
+			images_deleted INTEGER NOT NULL,
+			bytes_reclaimed BIGINT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+	`)
+	return err
+}
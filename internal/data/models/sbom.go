@@ -0,0 +1,29 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Sbom struct {
+	Digest    string          `json:"digest"`
+	Format    string          `json:"format"`
+	Document  json.RawMessage `json:"document"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+func MigrateSbomTable(pool *pgxpool.Pool) error {
+	ctx := context.Background()
+	_, err := pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS sboms (
+			digest TEXT PRIMARY KEY,
+			format TEXT NOT NULL,
+			document JSONB NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+	`)
+	return err
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// SigningKey is a key trusted to sign an organization's images. Keys
+// generated by the controller also keep the encrypted private key so images
+// can be signed server-side; registered keys only have the public half.
+type SigningKey struct {
+	Organization string    `json:"organization"`
+	Name         string    `json:"name"`
+	PublicKey    string    `json:"public_key"`
+	PrivateKey   []byte    `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func MigrateSigningKeyTable(pool *pgxpool.Pool) error {
+	ctx := context.Background()
+	_, err := pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS signing_keys (
+			organization TEXT NOT NULL REFERENCES organizations(name),
+			name TEXT NOT NULL,
+			public_key TEXT NOT NULL,
+			private_key BYTEA,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (organization, name)
+		);
+	`)
+	return err
+}
@@ -7,12 +7,17 @@ import (
 )
 
 type User struct {
-	Username     string `json:"username"`
-	PasswordHash string `json:"password_hash"`
+	Username     string  `json:"username"`
+	PasswordHash string  `json:"password_hash"`
+	Organization *string `json:"organization"`
+	Tier         string  `json:"tier"`
 }
 
 func MigrateUserTable(pool *pgxpool.Pool) error {
 	ctx := context.Background()
-	_, err := pool.Exec(ctx, "CREATE TABLE IF NOT EXISTS users (username TEXT PRIMARY KEY, password_hash TEXT NOT NULL);")
+	_, err := pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS users (username TEXT PRIMARY KEY, password_hash TEXT NOT NULL);
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS tier TEXT NOT NULL DEFAULT 'free';
+	`)
 	return err
 }
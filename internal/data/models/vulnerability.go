@@ -0,0 +1,68 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Advisory struct {
+	ID       string    `json:"id"`
+	Summary  string    `json:"summary"`
+	Aliases  []string  `json:"aliases"`
+	Severity string    `json:"severity"`
+	Modified time.Time `json:"modified"`
+}
+
+type VulnerabilityFinding struct {
+	Digest       string `json:"digest"`
+	AdvisoryID   string `json:"advisory_id"`
+	Package      string `json:"package"`
+	Version      string `json:"version"`
+	Type         string `json:"type"`
+	Path         string `json:"path"`
+	FixedVersion string `json:"fixed_version"`
+	Severity     string `json:"severity"`
+}
+
+func MigrateAdvisoryTables(pool *pgxpool.Pool) error {
+	ctx := context.Background()
+	_, err := pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS advisories (
+			id TEXT PRIMARY KEY,
+			summary TEXT NOT NULL DEFAULT '',
+			aliases TEXT[] NOT NULL DEFAULT '{}',
+			severity TEXT NOT NULL,
+			modified TIMESTAMPTZ NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS advisory_affected (
+			advisory_id TEXT NOT NULL REFERENCES advisories(id) ON DELETE CASCADE,
+			ecosystem TEXT NOT NULL,
+			name TEXT NOT NULL,
+			ranges JSONB NOT NULL DEFAULT '[]',
+			versions JSONB NOT NULL DEFAULT '[]',
+			severity TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS advisory_affected_name_idx ON advisory_affected (name);
+	`)
+	return err
+}
+
+func MigrateVulnerabilityFindingTable(pool *pgxpool.Pool) error {
+	ctx := context.Background()
+	_, err := pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS vulnerability_findings (
+			digest TEXT NOT NULL,
+			advisory_id TEXT NOT NULL,
+			package TEXT NOT NULL,
+			version TEXT NOT NULL,
+			type TEXT NOT NULL,
+			path TEXT NOT NULL,
+			fixed_version TEXT NOT NULL DEFAULT '',
+			severity TEXT NOT NULL,
+			PRIMARY KEY (digest, advisory_id, package, path)
+		);
+	`)
+	return err
+}
@@ -0,0 +1,129 @@
+package imagecheck
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+
+	"github.com/digizyne/lfcont/internal/registry"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Finding is a single compatibility problem found in an image.
+type Finding struct {
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+// Policy controls how strict the compatibility checks are.
+type Policy struct {
+	Platform   v1.Platform
+	ForbidRoot bool
+	Port       string
+}
+
+// PolicyFromEnv builds the policy for Cloud Run from the environment.
+//
+//	IMAGE_POLICY_FORBID_ROOT  reject images that run as root when "true"
+func PolicyFromEnv() Policy {
+	return Policy{
+		Platform:   registry.DefaultPlatform,
+		ForbidRoot: os.Getenv("IMAGE_POLICY_FORBID_ROOT") == "true",
+		Port:       "8080",
+	}
+}
+
+// Inspect checks the image config against the runtime requirements and
+// returns every finding. An image is deployable when no finding has
+// SeverityError.
+func Inspect(img v1.Image, policy Policy) ([]Finding, error) {
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image config: %v", err)
+	}
+	return InspectConfig(cfg, policy), nil
+}
+
+// InspectConfig is Inspect for an already loaded config file.
+func InspectConfig(cfg *v1.ConfigFile, policy Policy) []Finding {
+	findings := []Finding{}
+
+	platform := v1.Platform{OS: cfg.OS, Architecture: cfg.Architecture, Variant: cfg.Variant}
+	if platform.OS != policy.Platform.OS || platform.Architecture != policy.Platform.Architecture {
+		findings = append(findings, Finding{
+			Rule:     "platform",
+			Severity: SeverityError,
+			Message:  fmt.Sprintf("image is built for %s but the runtime only supports %s", platform, policy.Platform),
+		})
+	}
+
+	if len(cfg.Config.Entrypoint) == 0 && len(cfg.Config.Cmd) == 0 {
+		findings = append(findings, Finding{
+			Rule:     "entrypoint",
+			Severity: SeverityError,
+			Message:  "image has neither an entrypoint nor a cmd, so the container has nothing to run",
+		})
+	}
+
+	if runsAsRoot(cfg.Config.User) {
+		severity := SeverityWarning
+		if policy.ForbidRoot {
+			severity = SeverityError
+		}
+		findings = append(findings, Finding{
+			Rule:     "user",
+			Severity: severity,
+			Message:  "image runs as root; set USER to an unprivileged user",
+		})
+	}
+
+	if len(cfg.Config.ExposedPorts) == 0 {
+		findings = append(findings, Finding{
+			Rule:     "port",
+			Severity: SeverityWarning,
+			Message:  fmt.Sprintf("image does not expose any port; the container must listen on $PORT (%s)", policy.Port),
+		})
+	} else if _, ok := cfg.Config.ExposedPorts[policy.Port+"/tcp"]; !ok {
+		findings = append(findings, Finding{
+			Rule:     "port",
+			Severity: SeverityWarning,
+			Message:  fmt.Sprintf("image exposes %s but the runtime sends traffic to port %s", strings.Join(exposedPorts(cfg), ", "), policy.Port),
+		})
+	}
+
+	return findings
+}
+
+// HasErrors reports whether any finding blocks the image.
+func HasErrors(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func runsAsRoot(user string) bool {
+	user, _, _ = strings.Cut(user, ":")
+	return user == "" || user == "root" || user == "0"
+}
+
+func exposedPorts(cfg *v1.ConfigFile) []string {
+	ports := make([]string, 0, len(cfg.Config.ExposedPorts))
+	for port := range cfg.Config.ExposedPorts {
+		ports = append(ports, port)
+	}
+	sort.Strings(ports)
+	return ports
+}
@@ -0,0 +1,165 @@
+// Package imageconfig derives images that differ from their source only in
+// the config file or manifest annotations, so the layers are reused as-is.
+package imageconfig
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// Changes describes a config mutation. Nil fields are left unchanged. In Env
+// and Labels a null value removes the key.
+type Changes struct {
+	Entrypoint   *[]string          `json:"entrypoint"`
+	Cmd          *[]string          `json:"cmd"`
+	Env          map[string]*string `json:"env"`
+	WorkingDir   *string            `json:"working_dir"`
+	User         *string            `json:"user"`
+	ExposedPorts *[]string          `json:"exposed_ports"`
+	Labels       map[string]*string `json:"labels"`
+	Annotations  map[string]string  `json:"annotations"`
+}
+
+// Empty reports whether c changes nothing.
+func (c Changes) Empty() bool {
+	return c.Entrypoint == nil && c.Cmd == nil && len(c.Env) == 0 && c.WorkingDir == nil &&
+		c.User == nil && c.ExposedPorts == nil && len(c.Labels) == 0 && len(c.Annotations) == 0
+}
+
+// Validate checks the changes without looking at an image.
+func (c Changes) Validate() error {
+	for key := range c.Env {
+		if key == "" || strings.ContainsAny(key, "= \t\n") {
+			return fmt.Errorf("invalid environment variable name %q", key)
+		}
+	}
+	if c.WorkingDir != nil && *c.WorkingDir != "" && !strings.HasPrefix(*c.WorkingDir, "/") {
+		return fmt.Errorf("working_dir must be an absolute path")
+	}
+	if c.ExposedPorts != nil {
+		for _, port := range *c.ExposedPorts {
+			if _, err := normalizePort(port); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Apply returns img with the changes applied.
+func Apply(img v1.Image, c Changes) (v1.Image, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	configFile, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	config := *configFile.Config.DeepCopy()
+
+	if c.Entrypoint != nil {
+		config.Entrypoint = *c.Entrypoint
+	}
+	if c.Cmd != nil {
+		config.Cmd = *c.Cmd
+	}
+	if len(c.Env) > 0 {
+		config.Env = applyEnv(config.Env, c.Env)
+	}
+	if c.WorkingDir != nil {
+		config.WorkingDir = *c.WorkingDir
+	}
+	if c.User != nil {
+		config.User = *c.User
+	}
+	if c.ExposedPorts != nil {
+		config.ExposedPorts = map[string]struct{}{}
+		for _, port := range *c.ExposedPorts {
+			normalized, _ := normalizePort(port)
+			config.ExposedPorts[normalized] = struct{}{}
+		}
+	}
+	if len(c.Labels) > 0 {
+		labels := maps.Clone(config.Labels)
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		for key, value := range c.Labels {
+			if value == nil {
+				delete(labels, key)
+			} else {
+				labels[key] = *value
+			}
+		}
+		config.Labels = labels
+	}
+
+	derived, err := mutate.Config(img, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(c.Annotations) > 0 {
+		mediaType, err := img.MediaType()
+		if err != nil {
+			return nil, err
+		}
+		if mediaType != types.OCIManifestSchema1 {
+			return nil, fmt.Errorf("annotations require an OCI image manifest, image is %s", mediaType)
+		}
+		derived = mutate.Annotations(derived, c.Annotations).(v1.Image)
+	}
+	return derived, nil
+}
+
+// applyEnv sets or removes variables while keeping the original order.
+func applyEnv(env []string, changes map[string]*string) []string {
+	var result []string
+	seen := map[string]bool{}
+	for _, kv := range env {
+		key, _, _ := strings.Cut(kv, "=")
+		value, changed := changes[key]
+		switch {
+		case !changed:
+			result = append(result, kv)
+		case value != nil:
+			result = append(result, key+"="+*value)
+		}
+		seen[key] = true
+	}
+
+	var added []string
+	for key, value := range changes {
+		if !seen[key] && value != nil {
+			added = append(added, key)
+		}
+	}
+	// Map order is random; keep the derived config deterministic.
+	slices.Sort(added)
+	for _, key := range added {
+		result = append(result, key+"="+*changes[key])
+	}
+	return result
+}
+
+// normalizePort accepts "8080", "8080/tcp" or "53/udp".
+func normalizePort(port string) (string, error) {
+	number, protocol, found := strings.Cut(port, "/")
+	if !found {
+		protocol = "tcp"
+	}
+	if n, err := strconv.Atoi(number); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q", port)
+	}
+	if protocol != "tcp" && protocol != "udp" && protocol != "sctp" {
+		return "", fmt.Errorf("invalid port protocol %q", port)
+	}
+	return number + "/" + protocol, nil
+}
@@ -0,0 +1,179 @@
+package imageconfig
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+func TestApply(t *testing.T) {
+	img, err := random.Image(256, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err := mutate.Config(img, v1.Config{
+		Entrypoint:   []string{"/server"},
+		Cmd:          []string{"--serve"},
+		Env:          []string{"PATH=/bin", "DEBUG=1", "MODE=dev"},
+		WorkingDir:   "/app",
+		User:         "nonroot",
+		ExposedPorts: map[string]struct{}{"8080/tcp": {}},
+		Labels:       map[string]string{"team": "web", "stale": "yes"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oci := mutate.MediaType(base, types.OCIManifestSchema1)
+
+	tests := []struct {
+		name    string
+		img     v1.Image
+		changes Changes
+		want    func(cfg v1.Config) v1.Config
+		wantErr string
+	}{
+		{
+			name:    "nothing changed",
+			img:     base,
+			changes: Changes{},
+			want:    func(cfg v1.Config) v1.Config { return cfg },
+		},
+		{
+			name:    "entrypoint and cmd",
+			img:     base,
+			changes: Changes{Entrypoint: &[]string{"/bin/app"}, Cmd: &[]string{}},
+			want: func(cfg v1.Config) v1.Config {
+				cfg.Entrypoint, cfg.Cmd = []string{"/bin/app"}, []string{}
+				return cfg
+			},
+		},
+		{
+			name:    "env keeps order, removes and appends sorted",
+			img:     base,
+			changes: Changes{Env: map[string]*string{"MODE": ptr("prod"), "DEBUG": nil, "ZONE": ptr("eu"), "APP": ptr("1"), "UNSET": nil}},
+			want: func(cfg v1.Config) v1.Config {
+				cfg.Env = []string{"PATH=/bin", "MODE=prod", "APP=1", "ZONE=eu"}
+				return cfg
+			},
+		},
+		{
+			name:    "working dir and user",
+			img:     base,
+			changes: Changes{WorkingDir: ptr("/srv"), User: ptr("1000:1000")},
+			want: func(cfg v1.Config) v1.Config {
+				cfg.WorkingDir, cfg.User = "/srv", "1000:1000"
+				return cfg
+			},
+		},
+		{
+			name:    "exposed ports are replaced",
+			img:     base,
+			changes: Changes{ExposedPorts: &[]string{"9090", "53/udp"}},
+			want: func(cfg v1.Config) v1.Config {
+				cfg.ExposedPorts = map[string]struct{}{"9090/tcp": {}, "53/udp": {}}
+				return cfg
+			},
+		},
+		{
+			name:    "labels",
+			img:     base,
+			changes: Changes{Labels: map[string]*string{"team": ptr("api"), "stale": nil, "tier": ptr("pro")}},
+			want: func(cfg v1.Config) v1.Config {
+				cfg.Labels = map[string]string{"team": "api", "tier": "pro"}
+				return cfg
+			},
+		},
+		{
+			name:    "annotations on an OCI manifest",
+			img:     oci,
+			changes: Changes{Annotations: map[string]string{"org.opencontainers.image.source": "https://example.com/app"}},
+			want:    func(cfg v1.Config) v1.Config { return cfg },
+		},
+		{
+			name:    "annotations on a Docker manifest",
+			img:     base,
+			changes: Changes{Annotations: map[string]string{"a": "b"}},
+			wantErr: "require an OCI image manifest",
+		},
+		{
+			name:    "invalid env name",
+			img:     base,
+			changes: Changes{Env: map[string]*string{"A=B": ptr("c")}},
+			wantErr: "invalid environment variable name",
+		},
+		{
+			name:    "relative working dir",
+			img:     base,
+			changes: Changes{WorkingDir: ptr("srv")},
+			wantErr: "absolute path",
+		},
+		{
+			name:    "invalid port",
+			img:     base,
+			changes: Changes{ExposedPorts: &[]string{"70000"}},
+			wantErr: "invalid port",
+		},
+		{
+			name:    "invalid protocol",
+			img:     base,
+			changes: Changes{ExposedPorts: &[]string{"80/http"}},
+			wantErr: "invalid port protocol",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			derived, err := Apply(tt.img, tt.changes)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Apply() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			before, err := tt.img.ConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			after, err := derived.ConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.want(*before.Config.DeepCopy())
+			if !configEqual(after.Config, want) {
+				t.Errorf("config = %+v, want %+v", after.Config, want)
+			}
+
+			beforeManifest, err := tt.img.Manifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			afterManifest, err := derived.Manifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.EqualFunc(beforeManifest.Layers, afterManifest.Layers, func(a, b v1.Descriptor) bool { return a.Digest == b.Digest }) {
+				t.Error("layers changed")
+			}
+			if !maps.Equal(afterManifest.Annotations, tt.changes.Annotations) {
+				t.Errorf("annotations = %v, want %v", afterManifest.Annotations, tt.changes.Annotations)
+			}
+		})
+	}
+}
+
+func configEqual(a, b v1.Config) bool {
+	return slices.Equal(a.Entrypoint, b.Entrypoint) && slices.Equal(a.Cmd, b.Cmd) &&
+		slices.Equal(a.Env, b.Env) && a.WorkingDir == b.WorkingDir && a.User == b.User &&
+		maps.Equal(a.ExposedPorts, b.ExposedPorts) && maps.Equal(a.Labels, b.Labels)
+}
@@ -0,0 +1,246 @@
+package imagediff
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+
+	"github.com/digizyne/lfcont/internal/imagefs"
+	"github.com/digizyne/lfcont/internal/sbom"
+)
+
+const (
+	ChangeAdded    = "added"
+	ChangeRemoved  = "removed"
+	ChangeModified = "modified"
+)
+
+// ConfigChange is a difference in the runtime configuration. Key names the
+// variable, port or label for map-like fields.
+type ConfigChange struct {
+	Field  string `json:"field"`
+	Key    string `json:"key,omitempty"`
+	Change string `json:"change"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+
+// Config compares the runtime settings of two image configs.
+func Config(from, to *v1.ConfigFile) []ConfigChange {
+	changes := []ConfigChange{}
+	scalar := func(field, a, b string) {
+		if a != b {
+			changes = append(changes, ConfigChange{Field: field, Change: changeKind(a != "", b != ""), From: a, To: b})
+		}
+	}
+	list := func(field string, a, b []string) {
+		if !slices.Equal(a, b) {
+			changes = append(changes, ConfigChange{Field: field, Change: changeKind(len(a) > 0, len(b) > 0), From: strings.Join(a, " "), To: strings.Join(b, " ")})
+		}
+	}
+	keyed := func(field string, a, b map[string]string) {
+		keys := map[string]struct{}{}
+		for k := range a {
+			keys[k] = struct{}{}
+		}
+		for k := range b {
+			keys[k] = struct{}{}
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			av, aok := a[k]
+			bv, bok := b[k]
+			if aok && bok && av == bv {
+				continue
+			}
+			changes = append(changes, ConfigChange{Field: field, Key: k, Change: changeKind(aok, bok), From: av, To: bv})
+		}
+	}
+
+	scalar("platform", platform(from), platform(to))
+	list("entrypoint", from.Config.Entrypoint, to.Config.Entrypoint)
+	list("cmd", from.Config.Cmd, to.Config.Cmd)
+	scalar("user", from.Config.User, to.Config.User)
+	scalar("working_dir", from.Config.WorkingDir, to.Config.WorkingDir)
+	keyed("env", envMap(from.Config.Env), envMap(to.Config.Env))
+	keyed("exposed_ports", portMap(from.Config.ExposedPorts), portMap(to.Config.ExposedPorts))
+	keyed("labels", from.Config.Labels, to.Config.Labels)
+	return changes
+}
+
+func changeKind(before, after bool) string {
+	switch {
+	case !before && after:
+		return ChangeAdded
+	case before && !after:
+		return ChangeRemoved
+	default:
+		return ChangeModified
+	}
+}
+
+func platform(cfg *v1.ConfigFile) string {
+	p := cfg.OS + "/" + cfg.Architecture
+	if cfg.Variant != "" {
+		p += "/" + cfg.Variant
+	}
+	return p
+}
+
+func envMap(env []string) map[string]string {
+	m := map[string]string{}
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		m[k] = v
+	}
+	return m
+}
+
+func portMap(ports map[string]struct{}) map[string]string {
+	m := map[string]string{}
+	for port := range ports {
+		m[port] = port
+	}
+	return m
+}
+
+// Layer is a layer by compressed digest and size.
+type Layer struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+}
+
+type LayerDiff struct {
+	Shared  []Layer `json:"shared"`
+	Added   []Layer `json:"added"`
+	Removed []Layer `json:"removed"`
+	// AddedBytes is what pulling the new image costs when the old one is
+	// already present.
+	AddedBytes int64 `json:"added_bytes"`
+}
+
+// Layers compares the layers of two manifests.
+func Layers(from, to *v1.Manifest) LayerDiff {
+	diff := LayerDiff{Shared: []Layer{}, Added: []Layer{}, Removed: []Layer{}}
+	inFrom := map[v1.Hash]bool{}
+	for _, l := range from.Layers {
+		inFrom[l.Digest] = true
+	}
+	inTo := map[v1.Hash]bool{}
+	for _, l := range to.Layers {
+		inTo[l.Digest] = true
+		layer := Layer{Digest: l.Digest.String(), Size: l.Size}
+		if inFrom[l.Digest] {
+			diff.Shared = append(diff.Shared, layer)
+		} else {
+			diff.Added = append(diff.Added, layer)
+			diff.AddedBytes += l.Size
+		}
+	}
+	for _, l := range from.Layers {
+		if !inTo[l.Digest] {
+			diff.Removed = append(diff.Removed, Layer{Digest: l.Digest.String(), Size: l.Size})
+		}
+	}
+	return diff
+}
+
+type FileChange struct {
+	Path     string `json:"path"`
+	Change   string `json:"change"`
+	Type     string `json:"type"`
+	FromSize int64  `json:"from_size"`
+	ToSize   int64  `json:"to_size"`
+}
+
+// Files compares two flattened filesystems. Directories are left out. A file
+// written by a shared layer is unchanged; otherwise a difference in type,
+// mode, link target or contents marks it modified, so a file rewritten with
+// the same contents by a rebuilt layer is not reported.
+func Files(from, to *imagefs.FS) []FileChange {
+	changes := []FileChange{}
+	before := map[string]imagefs.Entry{}
+	for _, e := range from.Entries() {
+		if e.Type != imagefs.TypeDir {
+			before[e.Path] = e
+		}
+	}
+	for _, e := range to.Entries() {
+		if e.Type == imagefs.TypeDir {
+			continue
+		}
+		old, ok := before[e.Path]
+		delete(before, e.Path)
+		switch {
+		case !ok:
+			changes = append(changes, FileChange{Path: e.Path, Change: ChangeAdded, Type: e.Type, ToSize: e.Size})
+		case modified(old, e):
+			changes = append(changes, FileChange{Path: e.Path, Change: ChangeModified, Type: e.Type, FromSize: old.Size, ToSize: e.Size})
+		}
+	}
+	for _, e := range before {
+		changes = append(changes, FileChange{Path: e.Path, Change: ChangeRemoved, Type: e.Type, FromSize: e.Size})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+func modified(a, b imagefs.Entry) bool {
+	if a.Layer == b.Layer {
+		return false
+	}
+	return a.Type != b.Type || a.Size != b.Size || a.Mode != b.Mode ||
+		a.Linkname != b.Linkname || a.Digest != b.Digest
+}
+
+type PackageChange struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Path   string `json:"path,omitempty"`
+	Change string `json:"change"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+
+// Packages compares two SBOM inventories by package type, name and location.
+func Packages(from, to []sbom.Package) []PackageChange {
+	key := func(p sbom.Package) string {
+		return fmt.Sprintf("%s|%s|%s|%s", p.Type, p.Name, p.Arch, p.Path)
+	}
+	before := map[string]sbom.Package{}
+	for _, p := range from {
+		before[key(p)] = p
+	}
+
+	changes := []PackageChange{}
+	for _, p := range to {
+		old, ok := before[key(p)]
+		delete(before, key(p))
+		switch {
+		case !ok:
+			changes = append(changes, PackageChange{Name: p.Name, Type: p.Type, Path: p.Path, Change: ChangeAdded, To: p.Version})
+		case old.Version != p.Version:
+			changes = append(changes, PackageChange{Name: p.Name, Type: p.Type, Path: p.Path, Change: ChangeModified, From: old.Version, To: p.Version})
+		}
+	}
+	for _, p := range before {
+		changes = append(changes, PackageChange{Name: p.Name, Type: p.Type, Path: p.Path, Change: ChangeRemoved, From: p.Version})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Type != changes[j].Type {
+			return changes[i].Type < changes[j].Type
+		}
+		if changes[i].Name != changes[j].Name {
+			return changes[i].Name < changes[j].Name
+		}
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
@@ -0,0 +1,134 @@
+package imagediff
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+
+	"github.com/digizyne/lfcont/internal/imagefs"
+)
+
+// file is one tar entry of a test layer; a non-empty linkname makes it a
+// symlink.
+type file struct {
+	name     string
+	body     string
+	mode     int64
+	linkname string
+}
+
+// testLayer builds an uncompressed layer in memory. Layers with the same
+// files and modTime have the same digest.
+func testLayer(t *testing.T, modTime time.Time, files ...file) v1.Layer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Typeflag: tar.TypeReg, Mode: f.mode, Size: int64(len(f.body)), ModTime: modTime}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0o644
+		}
+		if f.linkname != "" {
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, f.linkname, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, f.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return layer
+}
+
+func testFS(t *testing.T, layers ...v1.Layer) *imagefs.FS {
+	t.Helper()
+	img, err := mutate.AppendLayers(empty.Image, layers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := imagefs.Index(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fsys
+}
+
+func TestFiles(t *testing.T) {
+	built := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	rebuilt := built.Add(time.Hour)
+	base := testLayer(t, built, file{name: "etc/os-release", body: "debian"}, file{name: "bin/sh", body: "shell"})
+
+	from := testFS(t, base, testLayer(t, built,
+		file{name: "app/server", body: "v1"},
+		file{name: "app/config.yaml", body: "port: 80"},
+		file{name: "app/run.sh", body: "#!/bin/sh", mode: 0o644},
+		file{name: "app/current", linkname: "server"},
+		file{name: "app/old.txt", body: "gone soon"},
+	))
+	to := testFS(t, base, testLayer(t, rebuilt,
+		file{name: "app/server", body: "v2"},
+		file{name: "app/config.yaml", body: "port: 80"},
+		file{name: "app/run.sh", body: "#!/bin/sh", mode: 0o755},
+		file{name: "app/current", linkname: "server-v2"},
+		file{name: "app/new.txt", body: "hello"},
+	))
+
+	want := []FileChange{
+		{Path: "/app/current", Change: ChangeModified, Type: imagefs.TypeSymlink},
+		{Path: "/app/new.txt", Change: ChangeAdded, Type: imagefs.TypeFile, ToSize: 5},
+		{Path: "/app/old.txt", Change: ChangeRemoved, Type: imagefs.TypeFile, FromSize: 9},
+		{Path: "/app/run.sh", Change: ChangeModified, Type: imagefs.TypeFile, FromSize: 9, ToSize: 9},
+		{Path: "/app/server", Change: ChangeModified, Type: imagefs.TypeFile, FromSize: 2, ToSize: 2},
+	}
+	if got := Files(from, to); !slices.Equal(got, want) {
+		t.Errorf("Files() =\n%+v\nwant\n%+v", got, want)
+	}
+	if got := Files(to, to); len(got) != 0 {
+		t.Errorf("Files() of an image with itself = %+v, want no changes", got)
+	}
+}
+
+func TestModified(t *testing.T) {
+	entry := imagefs.Entry{Path: "/bin/app", Type: imagefs.TypeFile, Size: 3, Mode: "-rwxr-xr-x", Digest: "sha256:aaa", Layer: "sha256:l1"}
+	tests := []struct {
+		name   string
+		change func(e *imagefs.Entry)
+		want   bool
+	}{
+		{"same layer", func(e *imagefs.Entry) { e.Digest = "sha256:bbb" }, false},
+		{"rewritten with the same contents", func(e *imagefs.Entry) { e.Layer = "sha256:l2"; e.ModTime = time.Now() }, false},
+		{"contents", func(e *imagefs.Entry) { e.Layer = "sha256:l2"; e.Digest = "sha256:bbb" }, true},
+		{"size", func(e *imagefs.Entry) { e.Layer = "sha256:l2"; e.Size = 4 }, true},
+		{"mode", func(e *imagefs.Entry) { e.Layer = "sha256:l2"; e.Mode = "-rw-r--r--" }, true},
+		{"type", func(e *imagefs.Entry) { e.Layer = "sha256:l2"; e.Type = imagefs.TypeSymlink }, true},
+		{"link target", func(e *imagefs.Entry) { e.Layer = "sha256:l2"; e.Linkname = "/bin/other" }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other := entry
+			tt.change(&other)
+			if got := modified(entry, other); got != tt.want {
+				t.Errorf("modified() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,409 @@
+package imagefs
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+const (
+	TypeFile     = "file"
+	TypeDir      = "dir"
+	TypeSymlink  = "symlink"
+	TypeHardlink = "hardlink"
+	TypeOther    = "other"
+)
+
+// maxSymlinkHops bounds symlink resolution when reading files.
+const maxSymlinkHops = 16
+
+var (
+	ErrNotFound    = errors.New("no such file or directory")
+	ErrNotDir      = errors.New("not a directory")
+	ErrNotFile     = errors.New("not a regular file")
+	ErrFileTooBig  = errors.New("file exceeds the size limit")
+	errSymlinkLoop = errors.New("too many levels of symbolic links")
+)
+
+// Entry is a file in the flattened image filesystem. Layer is the digest of
+// the layer that last wrote it and LayerIndex its position, base layer first.
+type Entry struct {
+	Path     string    `json:"path"`
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Size     int64     `json:"size"`
+	Mode     string    `json:"mode"`
+	ModTime  time.Time `json:"mod_time"`
+	Linkname string    `json:"linkname,omitempty"`
+	// Digest is the sha256 of a regular file's contents.
+	Digest     string `json:"digest,omitempty"`
+	Layer      string `json:"layer,omitempty"`
+	LayerIndex int    `json:"layer_index"`
+}
+
+// FS is an index of an image's flattened filesystem. File contents are not
+// kept; ReadFile fetches them from the layer that provided the file.
+type FS struct {
+	img     v1.Image
+	layers  []v1.Layer
+	entries map[string]Entry
+}
+
+// Index walks every layer of img and applies whiteouts to build the
+// flattened view.
+func Index(img v1.Image) (*FS, error) {
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+	fsys := &FS{
+		img:     img,
+		layers:  layers,
+		entries: map[string]Entry{"/": {Path: "/", Name: "/", Type: TypeDir, Mode: "drwxr-xr-x", LayerIndex: -1}},
+	}
+	for i, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return nil, err
+		}
+		if err := fsys.indexLayer(layer, digest.String(), i); err != nil {
+			return nil, fmt.Errorf("layer %s: %v", digest, err)
+		}
+	}
+	return fsys, nil
+}
+
+func (fsys *FS) indexLayer(layer v1.Layer, digest string, index int) error {
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	// Whiteouts only hide files from lower layers, so they are applied
+	// before this layer's own entries are added.
+	var added []Entry
+	var whiteouts, opaque []string
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean("/" + hdr.Name)
+		dir, base := path.Split(name)
+		dir = path.Clean(dir)
+		if base == ".wh..wh..opq" {
+			opaque = append(opaque, dir)
+			continue
+		}
+		if strings.HasPrefix(base, ".wh.") {
+			whiteouts = append(whiteouts, path.Join(dir, strings.TrimPrefix(base, ".wh.")))
+			continue
+		}
+		entry := newEntry(hdr, name, digest, index)
+		if entry.Type == TypeFile {
+			h := sha256.New()
+			if _, err := io.Copy(h, tr); err != nil {
+				return err
+			}
+			entry.Digest = "sha256:" + hex.EncodeToString(h.Sum(nil))
+		}
+		added = append(added, entry)
+	}
+
+	for _, dir := range opaque {
+		fsys.removeUnder(dir)
+	}
+	for _, removed := range whiteouts {
+		delete(fsys.entries, removed)
+		fsys.removeUnder(removed)
+	}
+	for _, entry := range added {
+		// A file replacing a directory hides everything below it.
+		if existing, ok := fsys.entries[entry.Path]; ok && existing.Type == TypeDir && entry.Type != TypeDir {
+			fsys.removeUnder(entry.Path)
+		}
+		fsys.entries[entry.Path] = entry
+	}
+	return nil
+}
+
+func newEntry(hdr *tar.Header, name, digest string, index int) Entry {
+	entry := Entry{
+		Path:       name,
+		Name:       path.Base(name),
+		Size:       hdr.Size,
+		Mode:       hdr.FileInfo().Mode().String(),
+		ModTime:    hdr.ModTime,
+		Layer:      digest,
+		LayerIndex: index,
+	}
+	switch hdr.Typeflag {
+	case tar.TypeReg:
+		entry.Type = TypeFile
+	case tar.TypeDir:
+		entry.Type = TypeDir
+		entry.Size = 0
+	case tar.TypeSymlink:
+		entry.Type = TypeSymlink
+		entry.Linkname = hdr.Linkname
+	case tar.TypeLink:
+		entry.Type = TypeHardlink
+		entry.Linkname = path.Clean("/" + hdr.Linkname)
+	default:
+		entry.Type = TypeOther
+	}
+	return entry
+}
+
+func (fsys *FS) removeUnder(dir string) {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	for name := range fsys.entries {
+		if strings.HasPrefix(name, prefix) {
+			delete(fsys.entries, name)
+		}
+	}
+}
+
+// Stat returns the entry at name without following symlinks.
+func (fsys *FS) Stat(name string) (Entry, error) {
+	entry, ok := fsys.entries[path.Clean("/"+name)]
+	if !ok {
+		if fsys.impliedDir(path.Clean("/" + name)) {
+			return Entry{Path: path.Clean("/" + name), Name: path.Base(name), Type: TypeDir, Mode: "drwxr-xr-x", LayerIndex: -1}, nil
+		}
+		return Entry{}, ErrNotFound
+	}
+	return entry, nil
+}
+
+// impliedDir reports whether name is a directory only known from its
+// children, as happens with layers that omit parent directory entries.
+func (fsys *FS) impliedDir(name string) bool {
+	prefix := strings.TrimSuffix(name, "/") + "/"
+	for other := range fsys.entries {
+		if strings.HasPrefix(other, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// List returns the direct children of dir sorted by name.
+func (fsys *FS) List(dir string) ([]Entry, error) {
+	entry, err := fsys.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Type != TypeDir {
+		return nil, ErrNotDir
+	}
+
+	prefix := strings.TrimSuffix(entry.Path, "/") + "/"
+	children := map[string]Entry{}
+	for name, child := range fsys.entries {
+		if name == entry.Path || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(name, prefix)
+		if first, _, nested := strings.Cut(rest, "/"); nested {
+			if _, ok := children[first]; !ok {
+				children[first] = Entry{Path: prefix + first, Name: first, Type: TypeDir, Mode: "drwxr-xr-x", LayerIndex: -1}
+			}
+			continue
+		}
+		children[rest] = child
+	}
+
+	list := make([]Entry, 0, len(children))
+	for _, child := range children {
+		list = append(list, child)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// Entries returns every entry of the flattened filesystem sorted by path.
+func (fsys *FS) Entries() []Entry {
+	list := make([]Entry, 0, len(fsys.entries))
+	for _, entry := range fsys.entries {
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return list
+}
+
+// ReadFile returns the contents of a regular file, following symlinks and
+// hard links. Files larger than limit return ErrFileTooBig along with the
+// resolved entry.
+func (fsys *FS) ReadFile(name string, limit int64) ([]byte, Entry, error) {
+	entry, err := fsys.resolve(name)
+	if err != nil {
+		return nil, Entry{}, err
+	}
+	if entry.Type == TypeHardlink {
+		target, err := fsys.resolve(entry.Linkname)
+		if err != nil {
+			return nil, entry, err
+		}
+		// The link's own tar header has no data; the contents are stored
+		// with the target, in the layer that wrote the link.
+		entry.Size = target.Size
+		entry.Type = TypeFile
+		data, err := fsys.readFromLayer(entry.LayerIndex, entry.Linkname, limit)
+		if errors.Is(err, ErrNotFound) {
+			data, err = fsys.readFromLayer(target.LayerIndex, target.Path, limit)
+		}
+		return data, entry, err
+	}
+	if entry.Type != TypeFile {
+		return nil, entry, ErrNotFile
+	}
+	if entry.Size > limit {
+		return nil, entry, ErrFileTooBig
+	}
+	data, err := fsys.readFromLayer(entry.LayerIndex, entry.Path, limit)
+	return data, entry, err
+}
+
+// resolve follows symlinks in every component of name.
+func (fsys *FS) resolve(name string) (Entry, error) {
+	current := path.Clean("/" + name)
+	for hops := 0; hops < maxSymlinkHops; hops++ {
+		resolved, redirected, err := fsys.resolveOnce(current)
+		if err != nil {
+			return Entry{}, err
+		}
+		if !redirected {
+			return fsys.Stat(resolved)
+		}
+		current = resolved
+	}
+	return Entry{}, errSymlinkLoop
+}
+
+// resolveOnce replaces the first symlink found in name with its target.
+func (fsys *FS) resolveOnce(name string) (string, bool, error) {
+	parts := strings.Split(strings.TrimPrefix(name, "/"), "/")
+	current := "/"
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		current = path.Join(current, part)
+		entry, err := fsys.Stat(current)
+		if err != nil {
+			return "", false, err
+		}
+		if entry.Type != TypeSymlink {
+			continue
+		}
+		target := entry.Linkname
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(current), target)
+		}
+		rest := strings.Join(parts[i+1:], "/")
+		return path.Clean(path.Join(target, rest)), true, nil
+	}
+	return current, false, nil
+}
+
+func (fsys *FS) readFromLayer(index int, name string, limit int64) ([]byte, error) {
+	if index < 0 || index >= len(fsys.layers) {
+		return nil, ErrNotFound
+	}
+	rc, err := fsys.layers[index].Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, ErrNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		if path.Clean("/"+hdr.Name) != name || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if hdr.Size > limit {
+			return nil, ErrFileTooBig
+		}
+		return io.ReadAll(io.LimitReader(tr, limit))
+	}
+}
+
+// Cache keeps the most recently used indexes by image digest, since indexing
+// reads every layer of the image.
+type Cache struct {
+	mu    sync.Mutex
+	size  int
+	order []string
+	items map[string]*FS
+}
+
+func NewCache(size int) *Cache {
+	return &Cache{size: max(size, 1), items: map[string]*FS{}}
+}
+
+// Index returns the cached index of img or builds it.
+func (c *Cache) Index(img v1.Image) (*FS, error) {
+	digest, err := img.Digest()
+	if err != nil {
+		return nil, err
+	}
+	key := digest.String()
+
+	c.mu.Lock()
+	if fsys, ok := c.items[key]; ok {
+		c.touch(key)
+		c.mu.Unlock()
+		return fsys, nil
+	}
+	c.mu.Unlock()
+
+	fsys, err := Index(img)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[key]; !ok {
+		c.items[key] = fsys
+		c.order = append(c.order, key)
+		for len(c.order) > c.size {
+			delete(c.items, c.order[0])
+			c.order = c.order[1:]
+		}
+	}
+	return fsys, nil
+}
+
+func (c *Cache) touch(key string) {
+	for i, k := range c.order {
+		if k == key {
+			c.order = append(append(c.order[:i:i], c.order[i+1:]...), key)
+			return
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package imagefs
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// file is one tar entry of a test layer. Typeflag defaults to a regular
+// file.
+type file struct {
+	name     string
+	typeflag byte
+	body     string
+	linkname string
+}
+
+func dir(name string) file           { return file{name: name, typeflag: tar.TypeDir} }
+func symlink(name, to string) file   { return file{name: name, typeflag: tar.TypeSymlink, linkname: to} }
+func hardlink(name, to string) file  { return file{name: name, typeflag: tar.TypeLink, linkname: to} }
+func regular(name, body string) file { return file{name: name, body: body} }
+
+// testImage builds an image in memory with one layer per element of layers,
+// base layer first.
+func testImage(t *testing.T, layers ...[]file) v1.Image {
+	t.Helper()
+	img := empty.Image
+	for _, files := range layers {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, f := range files {
+			hdr := &tar.Header{Name: f.name, Typeflag: f.typeflag, Linkname: f.linkname, Mode: 0o644}
+			switch f.typeflag {
+			case 0:
+				hdr.Typeflag = tar.TypeReg
+				hdr.Size = int64(len(f.body))
+			case tar.TypeDir:
+				hdr.Mode = 0o755
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.WriteString(tw, f.body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+		layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img, err = mutate.AppendLayers(img, layer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return img
+}
+
+func TestIndex(t *testing.T) {
+	tests := []struct {
+		name   string
+		layers [][]file
+		// want maps every expected path, other than the root, to its type.
+		want map[string]string
+	}{
+		{
+			name: "upper layer overwrites files",
+			layers: [][]file{
+				{dir("etc"), regular("etc/hosts", "base")},
+				{regular("etc/hosts", "upper"), regular("etc/motd", "hi")},
+			},
+			want: map[string]string{"/etc": TypeDir, "/etc/hosts": TypeFile, "/etc/motd": TypeFile},
+		},
+		{
+			name: "whiteout removes a file",
+			layers: [][]file{
+				{dir("etc"), regular("etc/hosts", "base"), regular("etc/passwd", "root")},
+				{regular("etc/.wh.hosts", "")},
+			},
+			want: map[string]string{"/etc": TypeDir, "/etc/passwd": TypeFile},
+		},
+		{
+			name: "whiteout removes a directory tree",
+			layers: [][]file{
+				{dir("var"), dir("var/cache"), regular("var/cache/a", "a"), regular("var/log", "log")},
+				{regular("var/.wh.cache", "")},
+			},
+			want: map[string]string{"/var": TypeDir, "/var/log": TypeFile},
+		},
+		{
+			name: "whiteout only hides lower layers",
+			layers: [][]file{
+				{regular("app", "old")},
+				{regular(".wh.app", ""), regular("app", "new")},
+			},
+			want: map[string]string{"/app": TypeFile},
+		},
+		{
+			name: "opaque directory hides lower contents",
+			layers: [][]file{
+				{dir("srv"), regular("srv/old", "old"), dir("srv/sub"), regular("srv/sub/deep", "deep"), regular("other", "x")},
+				{dir("srv"), regular("srv/.wh..wh..opq", ""), regular("srv/new", "new")},
+			},
+			want: map[string]string{"/srv": TypeDir, "/srv/new": TypeFile, "/other": TypeFile},
+		},
+		{
+			name: "file replaces a directory",
+			layers: [][]file{
+				{dir("opt"), dir("opt/tool"), regular("opt/tool/bin", "bin")},
+				{regular("opt/tool", "now a file")},
+			},
+			want: map[string]string{"/opt": TypeDir, "/opt/tool": TypeFile},
+		},
+		{
+			name: "links",
+			layers: [][]file{
+				{regular("bin/busybox", "bb"), hardlink("bin/sh", "bin/busybox"), symlink("bin/ls", "busybox")},
+			},
+			want: map[string]string{"/bin/busybox": TypeFile, "/bin/sh": TypeHardlink, "/bin/ls": TypeSymlink},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys, err := Index(testImage(t, tt.layers...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]string{}
+			for _, entry := range fsys.Entries() {
+				if entry.Path != "/" {
+					got[entry.Path] = entry.Type
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("entries = %v, want %v", got, tt.want)
+			}
+			for name, typ := range tt.want {
+				if got[name] != typ {
+					t.Errorf("%s: type %q, want %q", name, got[name], typ)
+				}
+			}
+		})
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	img := testImage(t,
+		[]file{
+			dir("bin"), regular("bin/busybox", "busybox v1"),
+			dir("etc"), regular("etc/hosts", "base hosts"),
+			dir("data"), regular("data/big", "0123456789"),
+		},
+		[]file{
+			regular("etc/hosts", "upper hosts"),
+			hardlink("bin/sh", "bin/busybox"),
+			symlink("bin/ls", "busybox"),
+			symlink("config", "/etc"),
+			symlink("loop/a", "b"), symlink("loop/b", "a"),
+			symlink("dangling", "/missing"),
+			regular("data/file", "same layer"), hardlink("data/link", "data/file"),
+		},
+	)
+	fsys, err := Index(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		limit   int64
+		want    string
+		wantErr error
+	}{
+		{name: "upper layer contents", path: "/etc/hosts", want: "upper hosts"},
+		{name: "relative symlink", path: "bin/ls", want: "busybox v1"},
+		{name: "symlinked directory", path: "/config/hosts", want: "upper hosts"},
+		{name: "hardlink to a lower layer", path: "/bin/sh", want: "busybox v1"},
+		{name: "hardlink in the same layer", path: "/data/link", want: "same layer"},
+		{name: "symlink loop", path: "/loop/a", wantErr: errSymlinkLoop},
+		{name: "dangling symlink", path: "/dangling", wantErr: ErrNotFound},
+		{name: "missing", path: "/etc/shadow", wantErr: ErrNotFound},
+		{name: "directory", path: "/etc", wantErr: ErrNotFile},
+		{name: "within the limit", path: "/data/big", limit: 10, want: "0123456789"},
+		{name: "too big", path: "/data/big", limit: 5, wantErr: ErrFileTooBig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit := tt.limit
+			if limit == 0 {
+				limit = 1 << 10
+			}
+			data, _, err := fsys.ReadFile(tt.path, limit)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadFile(%q) error = %v, want %v", tt.path, err, tt.wantErr)
+			}
+			if string(data) != tt.want {
+				t.Errorf("ReadFile(%q) = %q, want %q", tt.path, data, tt.want)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	// Parent directories are omitted from the layer, as some builders do.
+	fsys, err := Index(testImage(t, []file{regular("usr/lib/a.so", "a"), regular("usr/bin/tool", "t"), regular("usr/README", "r")}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := fsys.List("/usr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range list {
+		names = append(names, entry.Name+":"+entry.Type)
+	}
+	want := []string{"README:file", "bin:dir", "lib:dir"}
+	if !slices.Equal(names, want) {
+		t.Errorf("List(/usr) = %v, want %v", names, want)
+	}
+	if _, err := fsys.List("/usr/README"); !errors.Is(err, ErrNotDir) {
+		t.Errorf("List of a file = %v, want %v", err, ErrNotDir)
+	}
+}
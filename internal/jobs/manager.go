@@ -0,0 +1,291 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type State string
+
+const (
+	StateQueued    State = "queued"
+	StateRunning   State = "running"
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+)
+
+// LayerProgress tracks the upload of a single image layer.
+type LayerProgress struct {
+	Digest   string `json:"digest"`
+	Size     int64  `json:"size"`
+	Uploaded int64  `json:"uploaded"`
+	Skipped  bool   `json:"skipped"`
+	Done     bool   `json:"done"`
+}
+
+// Job is a snapshot of a background task. Values returned by the Manager are
+// copies and can be read without locking.
+type Job struct {
+	ID        string          `json:"id"`
+	Kind      string          `json:"kind"`
+	Username  string          `json:"username"`
+	State     State           `json:"state"`
+	Stage     string          `json:"stage,omitempty"`
+	Layers    []LayerProgress `json:"layers,omitempty"`
+	Result    any             `json:"result,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// Finished reports whether the job reached a terminal state.
+func (j Job) Finished() bool {
+	return j.State == StateSucceeded || j.State == StateFailed
+}
+
+// ErrQueueFull is returned by Submit when the queue cannot take another job.
+var ErrQueueFull = errors.New("too many jobs are queued, try again later")
+
+// RunFunc does the work of a job and reports progress through the Reporter.
+type RunFunc func(ctx context.Context, r *Reporter) (any, error)
+
+type task struct {
+	id  string
+	run RunFunc
+}
+
+// Manager runs jobs on a fixed pool of worker goroutines and keeps their
+// state in memory so clients can poll or subscribe to updates.
+type Manager struct {
+	mu          sync.Mutex
+	jobs        map[string]*Job
+	subscribers map[string]map[chan Job]struct{}
+	queue       chan task
+	retention   time.Duration
+}
+
+func NewManager(ctx context.Context, workers int, retention time.Duration) *Manager {
+	if workers < 1 {
+		workers = 1
+	}
+	m := &Manager{
+		jobs:        make(map[string]*Job),
+		subscribers: make(map[string]map[chan Job]struct{}),
+		queue:       make(chan task, 100),
+		retention:   retention,
+	}
+	for i := 0; i < workers; i++ {
+		go m.work(ctx)
+	}
+	return m
+}
+
+// Submit queues a new job and returns its initial snapshot. It does not
+// block: when the queue is full the job is dropped and ErrQueueFull returned.
+func (m *Manager) Submit(kind, username string, run RunFunc) (Job, error) {
+	now := time.Now()
+	job := &Job{
+		ID:        uuid.New().String(),
+		Kind:      kind,
+		Username:  username,
+		State:     StateQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	m.mu.Lock()
+	m.jobs[job.ID] = job
+	snapshot := job.snapshot()
+	m.mu.Unlock()
+
+	select {
+	case m.queue <- task{id: job.ID, run: run}:
+		return snapshot, nil
+	default:
+		m.mu.Lock()
+		delete(m.jobs, job.ID)
+		m.mu.Unlock()
+		return Job{}, ErrQueueFull
+	}
+}
+
+// Get returns a snapshot of the job with the given ID.
+func (m *Manager) Get(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return job.snapshot(), true
+}
+
+// Subscribe returns a channel that receives a snapshot after every change to
+// the job. The channel is closed once the job finishes or cancel is called.
+func (m *Manager) Subscribe(id string) (<-chan Job, func(), bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, nil, false
+	}
+	ch := make(chan Job, 16)
+	ch <- job.snapshot()
+	if job.Finished() {
+		close(ch)
+		return ch, func() {}, true
+	}
+	if m.subscribers[id] == nil {
+		m.subscribers[id] = make(map[chan Job]struct{})
+	}
+	m.subscribers[id][ch] = struct{}{}
+
+	cancel := func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if _, ok := m.subscribers[id][ch]; ok {
+			delete(m.subscribers[id], ch)
+			close(ch)
+		}
+	}
+	return ch, cancel, true
+}
+
+func (m *Manager) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case t := <-m.queue:
+			m.update(t.id, func(j *Job) { j.State = StateRunning })
+			result, err := m.run(ctx, t)
+			m.update(t.id, func(j *Job) {
+				// A failed job may still return a result describing why it failed.
+				j.Result = result
+				if err != nil {
+					j.State = StateFailed
+					j.Error = err.Error()
+					return
+				}
+				j.State = StateSucceeded
+			})
+			if err != nil {
+				log.Printf("Job %s failed: %v", t.id, err)
+			}
+			m.expire(t.id)
+		}
+	}
+}
+
+// run calls the job's RunFunc, turning a panic into a failed job so it
+// cannot take down the controller.
+func (m *Manager) run(ctx context.Context, t task) (result any, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("Job %s panicked: %v\n%s", t.id, p, debug.Stack())
+			result, err = nil, fmt.Errorf("job panicked: %v", p)
+		}
+	}()
+	return t.run(ctx, &Reporter{manager: m, id: t.id})
+}
+
+// update applies fn to the job and fans the new snapshot out to subscribers.
+func (m *Manager) update(id string, fn func(*Job)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return
+	}
+	fn(job)
+	job.UpdatedAt = time.Now()
+	snapshot := job.snapshot()
+	for ch := range m.subscribers[id] {
+		select {
+		case ch <- snapshot:
+		default:
+			// Slow subscriber; intermediate updates can be dropped but the
+			// final one must get through, so make room for it.
+			if snapshot.Finished() {
+				select {
+				case <-ch:
+				default:
+				}
+				ch <- snapshot
+			}
+		}
+		if snapshot.Finished() {
+			close(ch)
+		}
+	}
+	if snapshot.Finished() {
+		delete(m.subscribers, id)
+	}
+}
+
+// expire forgets a finished job after the retention period.
+func (m *Manager) expire(id string) {
+	time.AfterFunc(m.retention, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.jobs, id)
+	})
+}
+
+func (j *Job) snapshot() Job {
+	s := *j
+	s.Layers = append([]LayerProgress(nil), j.Layers...)
+	return s
+}
+
+// Reporter lets a running job publish its progress. A nil Reporter discards
+// all updates, which lets the same code run outside of a job.
+type Reporter struct {
+	manager *Manager
+	id      string
+}
+
+// ID returns the ID of the job being reported on.
+func (r *Reporter) ID() string {
+	if r == nil {
+		return ""
+	}
+	return r.id
+}
+
+// Stage records a human readable description of the current step.
+func (r *Reporter) Stage(stage string) {
+	if r == nil {
+		return
+	}
+	r.manager.update(r.id, func(j *Job) { j.Stage = stage })
+}
+
+// Layers replaces the list of tracked layers.
+func (r *Reporter) Layers(layers []LayerProgress) {
+	if r == nil {
+		return
+	}
+	r.manager.update(r.id, func(j *Job) { j.Layers = layers })
+}
+
+// Layer applies fn to the tracked layer with the given digest.
+func (r *Reporter) Layer(digest string, fn func(*LayerProgress)) {
+	if r == nil {
+		return
+	}
+	r.manager.update(r.id, func(j *Job) {
+		for i := range j.Layers {
+			if j.Layers[i].Digest == digest {
+				fn(&j.Layers[i])
+				return
+			}
+		}
+	})
+}
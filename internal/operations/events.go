@@ -0,0 +1,125 @@
+package operations
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Event types.
+const (
+	// EventStep reports the status of one resource.
+	EventStep = "step"
+	// EventLog is a line of raw output.
+	EventLog = "log"
+)
+
+// Step statuses.
+const (
+	StepCreating  = "creating"
+	StepUpdating  = "updating"
+	StepReplacing = "replacing"
+	StepDeleting  = "deleting"
+	StepDone      = "done"
+	StepFailed    = "failed"
+)
+
+// Event is a progress event of an operation. IDs increase in the order the
+// events were recorded.
+type Event struct {
+	ID           int64     `json:"id"`
+	Type         string    `json:"type"`
+	Resource     *string   `json:"resource,omitempty"`
+	ResourceType *string   `json:"resource_type,omitempty"`
+	Op           *string   `json:"op,omitempty"`
+	Status       *string   `json:"status,omitempty"`
+	Message      *string   `json:"message,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Events returns the events of operation id recorded after the event with ID
+// after.
+func (q *Queue) Events(ctx context.Context, id string, after int64) ([]Event, error) {
+	rows, err := q.pool.Query(ctx, `
+		SELECT id, type, resource, resource_type, op, status, message, created_at
+		FROM operation_events
+		WHERE operation_id = $1 AND id > $2
+		ORDER BY id
+		LIMIT 500
+	`, id, after)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[Event])
+}
+
+// Recorder stores the events of one operation. It is an io.Writer that
+// records each written line as a log event, and is safe for concurrent use.
+// Failures to store an event are logged, never returned, so that progress
+// reporting cannot fail the operation.
+type Recorder struct {
+	q       *Queue
+	id      string
+	mu      sync.Mutex
+	partial string
+}
+
+func (q *Queue) Recorder(id string) *Recorder {
+	return &Recorder{q: q, id: id}
+}
+
+// Step records a status change of resource.
+func (r *Recorder) Step(resource, resourceType, op, status string) {
+	r.insert(EventStep, &resource, &resourceType, &op, &status, nil)
+}
+
+// Log records a line of output.
+func (r *Recorder) Log(message string) {
+	r.insert(EventLog, nil, nil, nil, nil, &message)
+}
+
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+
+func (r *Recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	text := r.partial + string(p)
+	lines := strings.Split(text, "\n")
+	r.partial = lines[len(lines)-1]
+	r.mu.Unlock()
+
+	for _, line := range lines[:len(lines)-1] {
+		r.logLine(line)
+	}
+	return len(p), nil
+}
+
+// Flush records output that did not end with a newline.
+func (r *Recorder) Flush() {
+	r.mu.Lock()
+	line := r.partial
+	r.partial = ""
+	r.mu.Unlock()
+	r.logLine(line)
+}
+
+func (r *Recorder) logLine(line string) {
+	line = strings.TrimRight(ansiEscape.ReplaceAllString(line, ""), "\r ")
+	if line != "" {
+		r.Log(line)
+	}
+}
+
+func (r *Recorder) insert(eventType string, resource, resourceType, op, status, message *string) {
+	_, err := r.q.pool.Exec(context.Background(), `
+		INSERT INTO operation_events (operation_id, type, resource, resource_type, op, status, message)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, r.id, eventType, resource, resourceType, op, status, message)
+	if err != nil {
+		log.Printf("Failed to record event of operation %s: %v", r.id, err)
+	}
+}
@@ -0,0 +1,342 @@
+// Package operations runs long-running work such as deployments from a queue
+// stored in Postgres, so queued and interrupted operations survive a restart
+// of the controller.
+package operations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type State string
+
+const (
+	StateQueued    State = "queued"
+	StateRunning   State = "running"
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+)
+
+const (
+	// An operation whose worker has not sent a heartbeat for leaseDuration
+	// is considered abandoned and is picked up again.
+	leaseDuration     = 2 * time.Minute
+	heartbeatInterval = 30 * time.Second
+	pollInterval      = 2 * time.Second
+	maxAttempts       = 3
+)
+
+// ErrNotFound is returned by Get for unknown operations.
+var ErrNotFound = errors.New("operation not found")
+
+// Operation is the stored state of a queued operation.
+type Operation struct {
+	ID           string          `json:"id"`
+	Kind         string          `json:"kind"`
+	Key          string          `json:"key"`
+	Username     string          `json:"username"`
+	State        State           `json:"state"`
+	Payload      json.RawMessage `json:"-"`
+	Result       json.RawMessage `json:"result,omitempty"`
+	ResultURL    *string         `json:"result_url,omitempty"`
+	Error        *string         `json:"error,omitempty"`
+	ErrorDetails json.RawMessage `json:"error_details,omitempty"`
+	Attempts     int             `json:"attempts"`
+	CreatedAt    time.Time       `json:"created_at"`
+	StartedAt    *time.Time      `json:"started_at,omitempty"`
+	FinishedAt   *time.Time      `json:"finished_at,omitempty"`
+}
+
+// Finished reports whether the operation reached a terminal state.
+func (o Operation) Finished() bool {
+	return o.State == StateSucceeded || o.State == StateFailed
+}
+
+// Result is what a successful handler produced. URL points at the resource
+// the operation created, if any.
+type Result struct {
+	URL  string
+	Data any
+}
+
+// DetailedError is an error carrying structured details that are stored with
+// the failed operation.
+type DetailedError interface {
+	error
+	Details() any
+}
+
+// Handler runs an operation of one kind.
+type Handler func(ctx context.Context, op Operation) (Result, error)
+
+// Queue stores operations in the operations table and runs them on worker
+// goroutines. Operations with the same key never run concurrently.
+type Queue struct {
+	pool     *pgxpool.Pool
+	mu       sync.RWMutex
+	handlers map[string]Handler
+	wake     chan struct{}
+}
+
+func NewQueue(pool *pgxpool.Pool) *Queue {
+	return &Queue{
+		pool:     pool,
+		handlers: make(map[string]Handler),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Register sets the handler for operations of kind.
+func (q *Queue) Register(kind string, handler Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[kind] = handler
+}
+
+// Enqueue stores a new operation. key serializes operations on the same
+// resource, e.g. the deployment name.
+func (q *Queue) Enqueue(ctx context.Context, kind, key, username string, payload any) (Operation, error) {
+	document, err := json.Marshal(payload)
+	if err != nil {
+		return Operation{}, err
+	}
+	rows, err := q.pool.Query(ctx, `
+		INSERT INTO operations (id, kind, key, username, payload)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+columns,
+		uuid.New().String(), kind, key, username, document)
+	if err != nil {
+		return Operation{}, err
+	}
+	op, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByPos[Operation])
+	if err != nil {
+		return Operation{}, err
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return op, nil
+}
+
+// Get loads an operation by ID.
+func (q *Queue) Get(ctx context.Context, id string) (Operation, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return Operation{}, ErrNotFound
+	}
+	rows, err := q.pool.Query(ctx, `SELECT `+columns+` FROM operations WHERE id = $1`, id)
+	if err != nil {
+		return Operation{}, err
+	}
+	op, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByPos[Operation])
+	if err == pgx.ErrNoRows {
+		return Operation{}, ErrNotFound
+	}
+	return op, err
+}
+
+// Active reports whether an operation on key is queued or running.
+func (q *Queue) Active(ctx context.Context, key string) (bool, error) {
+	var active bool
+	err := q.pool.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM operations WHERE key = $1 AND state IN ('queued', 'running'))
+	`, key).Scan(&active)
+	return active, err
+}
+
+const columns = `id::text, kind, key, username, state, payload, result, result_url, error, error_details, attempts, created_at, started_at, finished_at`
+
+// Start runs workers goroutines until ctx is done.
+func (q *Queue) Start(ctx context.Context, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go q.work(ctx)
+	}
+}
+
+func (q *Queue) work(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		// Drain the queue before waiting again
+		for {
+			op, ok, err := q.claim(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Failed to claim operation: %v", err)
+				}
+				break
+			}
+			if !ok {
+				break
+			}
+			q.run(ctx, op)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// claim takes the oldest runnable operation. Operations abandoned by a
+// stopped controller are retried until they have been attempted
+// maxAttempts times. Claims are serialized with an advisory lock so that the
+// check for a running operation on the same key cannot race.
+func (q *Queue) claim(ctx context.Context) (Operation, bool, error) {
+	tx, err := q.pool.Begin(ctx)
+	if err != nil {
+		return Operation{}, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('operations.claim'))`); err != nil {
+		return Operation{}, false, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE operations
+		SET state = 'failed', error = 'controller stopped while the operation was running', finished_at = now()
+		WHERE state = 'running' AND heartbeat_at < now() - make_interval(secs => $1) AND attempts >= $2
+	`, leaseDuration.Seconds(), maxAttempts)
+	if err != nil {
+		return Operation{}, false, err
+	}
+
+	rows, err := tx.Query(ctx, `
+		UPDATE operations
+		SET state = 'running', attempts = attempts + 1, started_at = now(), heartbeat_at = now()
+		WHERE id = (
+			SELECT o.id FROM operations o
+			WHERE (o.state = 'queued' OR (o.state = 'running' AND o.heartbeat_at < now() - make_interval(secs => $1)))
+				AND NOT EXISTS (
+					SELECT 1 FROM operations r
+					WHERE r.key = o.key AND r.id <> o.id
+						AND r.state = 'running' AND r.heartbeat_at >= now() - make_interval(secs => $1)
+				)
+			ORDER BY o.created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+columns,
+		leaseDuration.Seconds())
+	if err != nil {
+		return Operation{}, false, err
+	}
+	op, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByPos[Operation])
+	if err == pgx.ErrNoRows {
+		return Operation{}, false, nil
+	}
+	if err != nil {
+		return Operation{}, false, err
+	}
+	return op, true, tx.Commit(ctx)
+}
+
+// run executes op and stores the outcome, sending heartbeats meanwhile.
+func (q *Queue) run(ctx context.Context, op Operation) {
+	q.mu.RLock()
+	handler, ok := q.handlers[op.Kind]
+	q.mu.RUnlock()
+	if !ok {
+		q.finish(ctx, op, Result{}, fmt.Errorf("no handler for operation kind %q", op.Kind))
+		return
+	}
+
+	heartbeatCtx, stopHeartbeat := context.WithCancel(ctx)
+	go q.heartbeat(heartbeatCtx, op.ID)
+
+	log.Printf("Running %s operation %s (attempt %d)", op.Kind, op.ID, op.Attempts)
+	result, err := call(ctx, handler, op)
+	stopHeartbeat()
+	q.finish(ctx, op, result, err)
+}
+
+// call runs handler, turning a panic into a failed operation so it cannot
+// take down the controller.
+func call(ctx context.Context, handler Handler, op Operation) (result Result, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("Operation %s panicked: %v\n%s", op.ID, p, debug.Stack())
+			result, err = Result{}, fmt.Errorf("operation panicked: %v", p)
+		}
+	}()
+	return handler(ctx, op)
+}
+
+func (q *Queue) heartbeat(ctx context.Context, id string) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := q.pool.Exec(ctx, `UPDATE operations SET heartbeat_at = now() WHERE id = $1`, id); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to record heartbeat of operation %s: %v", id, err)
+			}
+		}
+	}
+}
+
+func (q *Queue) finish(ctx context.Context, op Operation, result Result, runErr error) {
+	// The outcome is stored even if the controller is shutting down
+	ctx = context.WithoutCancel(ctx)
+
+	if runErr != nil {
+		var details any
+		var detailed DetailedError
+		if errors.As(runErr, &detailed) {
+			details = detailed.Details()
+		}
+		document, err := json.Marshal(details)
+		if err != nil || details == nil {
+			document = nil
+		}
+		_, err = q.pool.Exec(ctx, `
+			UPDATE operations
+			SET state = 'failed', error = $2, error_details = $3, finished_at = now()
+			WHERE id = $1
+		`, op.ID, runErr.Error(), document)
+		if err != nil {
+			log.Printf("Failed to record failure of operation %s: %v", op.ID, err)
+		}
+		log.Printf("Operation %s failed: %v", op.ID, runErr)
+		return
+	}
+
+	document, err := json.Marshal(result.Data)
+	if err != nil {
+		log.Printf("Failed to encode result of operation %s: %v", op.ID, err)
+		document = nil
+	}
+	var resultURL *string
+	if result.URL != "" {
+		resultURL = &result.URL
+	}
+	_, err = q.pool.Exec(ctx, `
+		UPDATE operations
+		SET state = 'succeeded', result = $2, result_url = $3, finished_at = now()
+		WHERE id = $1
+	`, op.ID, document, resultURL)
+	if err != nil {
+		log.Printf("Failed to record result of operation %s: %v", op.ID, err)
+	}
+	log.Printf("Operation %s succeeded", op.ID)
+}
@@ -0,0 +1,182 @@
+package quota
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+const (
+	ScopeUser         = "user"
+	ScopeOrganization = "organization"
+)
+
+// Usage is the stored compressed bytes counted against one quota. A Limit of
+// zero means unlimited.
+type Usage struct {
+	Scope string `json:"scope"`
+	Name  string `json:"name"`
+	Bytes int64  `json:"bytes"`
+	Limit int64  `json:"limit"`
+}
+
+// Remaining returns how many bytes can still be stored, or -1 if unlimited.
+func (u Usage) Remaining() int64 {
+	if u.Limit == 0 {
+		return -1
+	}
+	return max(u.Limit-u.Bytes, 0)
+}
+
+// ExceededError reports that storing Requested more bytes would exceed a
+// quota.
+type ExceededError struct {
+	Usage     Usage `json:"usage"`
+	Requested int64 `json:"requested"`
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%s storage quota for %s exceeded: %d of %d bytes used, %d more requested",
+		e.Usage.Scope, e.Usage.Name, e.Usage.Bytes, e.Usage.Limit, e.Requested)
+}
+
+// Full reports whether the quota was already used up before the request.
+func (e *ExceededError) Full() bool {
+	return e.Usage.Bytes >= e.Usage.Limit
+}
+
+// Check returns an ExceededError for the first quota that cannot hold
+// additional more bytes.
+func Check(additional int64, usages ...Usage) error {
+	for _, u := range usages {
+		if u.Limit > 0 && u.Bytes+additional > u.Limit {
+			return &ExceededError{Usage: u, Requested: additional}
+		}
+	}
+	return nil
+}
+
+// TierLimits parses STORAGE_QUOTAS, a comma separated list of tier=size
+// pairs such as "free=5GiB,pro=100GiB". Tiers that are not listed are
+// unlimited.
+func TierLimits() (map[string]int64, error) {
+	limits := map[string]int64{}
+	value := strings.TrimSpace(os.Getenv("STORAGE_QUOTAS"))
+	if value == "" {
+		return limits, nil
+	}
+	for _, entry := range strings.Split(value, ",") {
+		tier, size, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid STORAGE_QUOTAS entry %q", entry)
+		}
+		bytes, err := ParseSize(size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid STORAGE_QUOTAS entry %q: %v", entry, err)
+		}
+		limits[strings.TrimSpace(tier)] = bytes
+	}
+	return limits, nil
+}
+
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+	{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3}, {"B", 1},
+}
+
+// ParseSize parses a byte count with an optional binary or decimal unit.
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	number := s
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(number, unit.suffix) {
+			number = strings.TrimSpace(strings.TrimSuffix(number, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// ImageSize returns the compressed size of an image as stored in a registry:
+// manifest, config and layers.
+func ImageSize(img v1.Image) (int64, error) {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return 0, err
+	}
+	size, err := img.Size()
+	if err != nil {
+		return 0, err
+	}
+	size += manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		size += layer.Size
+	}
+	return size, nil
+}
+
+// IndexSize returns the stored size of a multi-arch index: its own manifest
+// plus every child, counting blobs shared between children once.
+func IndexSize(idx v1.ImageIndex) (int64, error) {
+	seen := map[v1.Hash]bool{}
+	return indexSize(idx, seen)
+}
+
+func indexSize(idx v1.ImageIndex, seen map[v1.Hash]bool) (int64, error) {
+	size, err := idx.Size()
+	if err != nil {
+		return 0, err
+	}
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return 0, err
+	}
+	for _, desc := range manifest.Manifests {
+		if seen[desc.Digest] {
+			continue
+		}
+		seen[desc.Digest] = true
+		switch {
+		case desc.MediaType.IsIndex():
+			child, err := idx.ImageIndex(desc.Digest)
+			if err != nil {
+				return 0, err
+			}
+			childSize, err := indexSize(child, seen)
+			if err != nil {
+				return 0, err
+			}
+			size += childSize
+		case desc.MediaType.IsImage():
+			img, err := idx.Image(desc.Digest)
+			if err != nil {
+				return 0, err
+			}
+			imgManifest, err := img.Manifest()
+			if err != nil {
+				return 0, err
+			}
+			size += desc.Size
+			for _, blob := range append([]v1.Descriptor{imgManifest.Config}, imgManifest.Layers...) {
+				if !seen[blob.Digest] {
+					seen[blob.Digest] = true
+					size += blob.Size
+				}
+			}
+		}
+	}
+	return size, nil
+}
@@ -0,0 +1,114 @@
+package quota
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "0", want: 0},
+		{in: "512", want: 512},
+		{in: "512B", want: 512},
+		{in: "4KiB", want: 4 << 10},
+		{in: "4KB", want: 4000},
+		{in: "5 GiB", want: 5 << 30},
+		{in: " 100GB ", want: 100e9},
+		{in: "2TiB", want: 2 << 40},
+		{in: "", wantErr: true},
+		{in: "GiB", wantErr: true},
+		{in: "-1GiB", wantErr: true},
+		{in: "1.5GiB", wantErr: true},
+		{in: "5PiB", wantErr: true},
+		{in: "9999999999TiB", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseSize(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSize(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSize(%q) = %d, want %d", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	user := Usage{Scope: ScopeUser, Name: "alice", Bytes: 80, Limit: 100}
+	org := Usage{Scope: ScopeOrganization, Name: "acme", Bytes: 10, Limit: 50}
+	unlimited := Usage{Scope: ScopeUser, Name: "bob", Bytes: 1 << 40}
+
+	tests := []struct {
+		name       string
+		additional int64
+		usages     []Usage
+		exceeded   string
+		full       bool
+	}{
+		{name: "no quotas", additional: 1 << 30},
+		{name: "unlimited", additional: 1 << 30, usages: []Usage{unlimited}},
+		{name: "fits exactly", additional: 20, usages: []Usage{user}},
+		{name: "over user quota", additional: 21, usages: []Usage{user}, exceeded: "alice"},
+		{name: "first exceeded quota wins", additional: 45, usages: []Usage{user, org}, exceeded: "alice"},
+		{name: "over organization quota", additional: 41, usages: []Usage{unlimited, org}, exceeded: "acme"},
+		{name: "already full", additional: 1, usages: []Usage{{Name: "carol", Bytes: 100, Limit: 100}}, exceeded: "carol", full: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Check(tt.additional, tt.usages...)
+			if tt.exceeded == "" {
+				if err != nil {
+					t.Fatalf("Check() = %v, want nil", err)
+				}
+				return
+			}
+			var exceeded *ExceededError
+			if !errors.As(err, &exceeded) {
+				t.Fatalf("Check() = %v, want an ExceededError", err)
+			}
+			if exceeded.Usage.Name != tt.exceeded || exceeded.Requested != tt.additional {
+				t.Errorf("exceeded %s by %d, want %s by %d", exceeded.Usage.Name, exceeded.Requested, tt.exceeded, tt.additional)
+			}
+			if exceeded.Full() != tt.full {
+				t.Errorf("Full() = %v, want %v", exceeded.Full(), tt.full)
+			}
+		})
+	}
+}
+
+func TestTierLimits(t *testing.T) {
+	t.Setenv("STORAGE_QUOTAS", "free=5GiB, pro = 100GiB")
+	limits, err := TierLimits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits["free"] != 5<<30 || limits["pro"] != 100<<30 || len(limits) != 2 {
+		t.Errorf("TierLimits() = %v", limits)
+	}
+
+	t.Setenv("STORAGE_QUOTAS", "free")
+	if _, err := TierLimits(); err == nil {
+		t.Error("TierLimits() accepted an entry without a size")
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	for _, tt := range []struct {
+		usage Usage
+		want  int64
+	}{
+		{Usage{Bytes: 10}, -1},
+		{Usage{Bytes: 10, Limit: 25}, 15},
+		{Usage{Bytes: 30, Limit: 25}, 0},
+	} {
+		if got := tt.usage.Remaining(); got != tt.want {
+			t.Errorf("%+v.Remaining() = %d, want %d", tt.usage, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Package rebase tracks the base image each stored image was built on and
+// swaps in newer base layers when the base is patched.
+package rebase
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+
+	"github.com/digizyne/lfcont/internal/registry"
+)
+
+// OCI annotations naming the base image, see
+// https://github.com/opencontainers/image-spec/blob/main/annotations.md
+const (
+	AnnotationBaseName   = "org.opencontainers.image.base.name"
+	AnnotationBaseDigest = "org.opencontainers.image.base.digest"
+)
+
+// Where a base record came from.
+const (
+	SourceAnnotation = "annotation"
+	SourceDeclared   = "declared"
+	SourceBuild      = "build"
+)
+
+// Base identifies the image another image was built on.
+type Base struct {
+	// Name is the tag that is followed for updates.
+	Name string `json:"base_name"`
+	// Digest is the manifest (or index) digest that was built on.
+	Digest string `json:"base_digest"`
+}
+
+// FromAnnotations reads the base from the manifest annotations of img.
+func FromAnnotations(img v1.Image) (Base, bool, error) {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return Base{}, false, err
+	}
+	base := Base{
+		Name:   manifest.Annotations[AnnotationBaseName],
+		Digest: manifest.Annotations[AnnotationBaseDigest],
+	}
+	if base.Name == "" || base.Digest == "" {
+		return Base{}, false, nil
+	}
+	if _, err := v1.NewHash(base.Digest); err != nil {
+		return Base{}, false, nil
+	}
+	return base, true, nil
+}
+
+// Reference returns the base pinned to its recorded digest.
+func (b Base) Reference() (name.Digest, error) {
+	tag, err := name.ParseReference(b.Name)
+	if err != nil {
+		return name.Digest{}, err
+	}
+	return tag.Context().Digest(b.Digest), nil
+}
+
+// Image fetches the recorded base for platform.
+func (b Base) Image(ctx context.Context, platform v1.Platform, opts ...remote.Option) (v1.Image, error) {
+	ref, err := b.Reference()
+	if err != nil {
+		return nil, err
+	}
+	return registry.Resolve(ctx, ref, platform, opts...)
+}
+
+// Latest returns the base with Digest set to what its tag points at now.
+func Latest(ctx context.Context, baseName string, opts ...remote.Option) (Base, error) {
+	ref, err := name.ParseReference(baseName)
+	if err != nil {
+		return Base{}, err
+	}
+	pinned, err := registry.Pin(ctx, ref, opts...)
+	if err != nil {
+		return Base{}, err
+	}
+	return Base{Name: baseName, Digest: pinned.DigestStr()}, nil
+}
+
+// BuiltOn reports whether the layers of base are the bottom layers of img.
+func BuiltOn(img, base v1.Image) (bool, error) {
+	layers, err := img.Layers()
+	if err != nil {
+		return false, err
+	}
+	baseLayers, err := base.Layers()
+	if err != nil {
+		return false, err
+	}
+	if len(baseLayers) > len(layers) {
+		return false, nil
+	}
+	for i, layer := range baseLayers {
+		want, err := layer.Digest()
+		if err != nil {
+			return false, err
+		}
+		got, err := layers[i].Digest()
+		if err != nil {
+			return false, err
+		}
+		if want != got {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Rebase replaces the oldBase layers at the bottom of img with those of
+// newBase. OCI images keep their manifest type, with the base annotations
+// pointing at the new base.
+func Rebase(img, oldBase, newBase v1.Image, base Base) (v1.Image, error) {
+	rebased, err := mutate.Rebase(img, oldBase, newBase)
+	if err != nil {
+		return nil, err
+	}
+	mediaType, err := img.MediaType()
+	if err != nil {
+		return nil, err
+	}
+	if mediaType != types.OCIManifestSchema1 {
+		return rebased, nil
+	}
+
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %v", err)
+	}
+	annotations := map[string]string{}
+	for key, value := range manifest.Annotations {
+		annotations[key] = value
+	}
+	annotations[AnnotationBaseName] = base.Name
+	annotations[AnnotationBaseDigest] = base.Digest
+
+	rebased = mutate.MediaType(rebased, types.OCIManifestSchema1)
+	rebased = mutate.ConfigMediaType(rebased, types.OCIConfigJSON)
+	return mutate.Annotations(rebased, annotations).(v1.Image), nil
+}
@@ -0,0 +1,99 @@
+package rebase
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/digizyne/lfcont/internal/registry"
+)
+
+// Watcher periodically checks whether the tags of recorded base images moved
+// and flags the images built on an older digest as outdated.
+type Watcher struct {
+	Pool *pgxpool.Pool
+	// RemoteOptions returns the credentials used to talk to the registry.
+	RemoteOptions func(ctx context.Context) []remote.Option
+}
+
+// Run checks every interval until ctx is done.
+func (w *Watcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			outdated, err := w.Check(ctx)
+			if err != nil {
+				log.Printf("Base image check failed: %v", err)
+				continue
+			}
+			log.Printf("Base image check found %d outdated images", outdated)
+		}
+	}
+}
+
+// Check resolves every recorded base tag and updates the outdated flags. It
+// returns the number of images whose base has moved. A base that cannot be
+// resolved is logged and skipped.
+func (w *Watcher) Check(ctx context.Context) (int, error) {
+	rows, err := w.Pool.Query(ctx, `SELECT DISTINCT base_name FROM image_bases ORDER BY base_name`)
+	if err != nil {
+		return 0, err
+	}
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return 0, err
+	}
+
+	opts := w.RemoteOptions(ctx)
+	outdated := 0
+	for _, baseName := range names {
+		latest, current, err := w.current(ctx, baseName, opts)
+		if err != nil {
+			log.Printf("Failed to resolve base image %s: %v", baseName, err)
+			continue
+		}
+		// A recorded digest may name either the tag's index or the platform
+		// image in it; both count as up to date.
+		tag, err := w.Pool.Exec(ctx, `
+			UPDATE image_bases
+			SET latest_digest = $2, outdated = base_digest <> ALL($3), checked_at = now()
+			WHERE base_name = $1
+		`, baseName, latest.Digest, current)
+		if err != nil {
+			return outdated, fmt.Errorf("failed to update %s: %v", baseName, err)
+		}
+		var count int
+		if err := w.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM image_bases WHERE base_name = $1 AND outdated`, baseName).Scan(&count); err != nil {
+			return outdated, err
+		}
+		log.Printf("Base image %s is at %s, %d of %d images outdated", baseName, latest.Digest, count, tag.RowsAffected())
+		outdated += count
+	}
+	return outdated, nil
+}
+
+// current returns the latest base and the digests that identify it.
+func (w *Watcher) current(ctx context.Context, baseName string, opts []remote.Option) (Base, []string, error) {
+	latest, err := Latest(ctx, baseName, opts...)
+	if err != nil {
+		return Base{}, nil, err
+	}
+	img, err := latest.Image(ctx, registry.DefaultPlatform, opts...)
+	if err != nil {
+		return Base{}, nil, err
+	}
+	platformDigest, err := img.Digest()
+	if err != nil {
+		return Base{}, nil, err
+	}
+	return latest, []string{latest.Digest, platformDigest.String()}, nil
+}
@@ -0,0 +1,167 @@
+package registry
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+)
+
+// Annotations on index.json entries naming the image, set by
+// 'docker buildx --output type=oci' and 'docker save' respectively.
+const (
+	annotationRefName       = "org.opencontainers.image.ref.name"
+	annotationContainerName = "io.containerd.image.name"
+)
+
+// ArchiveIndex is a multi-arch index read from an image archive. The blobs
+// are unpacked on disk until Close is called.
+type ArchiveIndex struct {
+	Index v1.ImageIndex
+	// Name is the image name recorded in the archive, or "" if it has none.
+	Name string
+	dir  string
+}
+
+func (a *ArchiveIndex) Close() error {
+	return os.RemoveAll(a.dir)
+}
+
+// LoadIndex reads a gzipped archive in OCI image layout, as written by
+// 'docker buildx --output type=oci' or 'docker save' with the containerd image
+// store, and returns the multi-arch index in it. It returns nil if the
+// archive is not an OCI layout or holds a single platform only; those are
+// loaded through the Docker daemon.
+func LoadIndex(archivePath string) (*ArchiveIndex, error) {
+	dir, err := os.MkdirTemp("", "push-layout-*")
+	if err != nil {
+		return nil, err
+	}
+	found, err := unpackLayout(archivePath, dir)
+	if err != nil || !found {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	path, err := layout.FromPath(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("invalid OCI layout: %v", err)
+	}
+	root, err := path.ImageIndex()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("invalid OCI layout: %v", err)
+	}
+	idx, imageName, err := multiPlatformIndex(root, "")
+	if err != nil || idx == nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &ArchiveIndex{Index: idx, Name: imageName, dir: dir}, nil
+}
+
+// unpackLayout extracts the archive into dir if it contains an index.json.
+// Archives without one are only read up to the end.
+func unpackLayout(archivePath, dir string) (bool, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		return false, fmt.Errorf("invalid gzip data: %v", err)
+	}
+	defer gzr.Close()
+
+	found := false
+	tr := tar.NewReader(gzr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return found, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("invalid tar archive: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		clean := filepath.Clean(hdr.Name)
+		if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return false, fmt.Errorf("invalid path %q in archive", hdr.Name)
+		}
+		if clean == "index.json" {
+			found = true
+		}
+		target := filepath.Join(dir, clean)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return false, err
+		}
+		out, err := os.Create(target)
+		if err != nil {
+			return false, err
+		}
+		_, err = io.Copy(out, tr)
+		out.Close()
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// multiPlatformIndex finds the first index under idx with images for more
+// than one platform, following single nested indexes.
+func multiPlatformIndex(idx v1.ImageIndex, imageName string) (v1.ImageIndex, string, error) {
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return nil, "", err
+	}
+	images, err := PlatformImages(idx)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(images) > 1 {
+		return idx, imageName, nil
+	}
+	if len(manifest.Manifests) != 1 || !manifest.Manifests[0].MediaType.IsIndex() {
+		return nil, "", nil
+	}
+
+	desc := manifest.Manifests[0]
+	if n := archiveImageName(desc.Annotations); n != "" {
+		imageName = n
+	}
+	child, err := idx.ImageIndex(desc.Digest)
+	if err != nil {
+		return nil, "", err
+	}
+	return multiPlatformIndex(child, imageName)
+}
+
+// archiveImageName returns the repository name from index.json annotations,
+// e.g. "myapp" for "docker.io/library/myapp:latest".
+func archiveImageName(annotations map[string]string) string {
+	for _, key := range []string{annotationContainerName, annotationRefName} {
+		// buildx sets ref.name to just the tag unless a full name was given
+		value := annotations[key]
+		if !strings.ContainsAny(value, "/:") {
+			continue
+		}
+		ref, err := name.ParseReference(value)
+		if err != nil {
+			continue
+		}
+		repository := ref.Context().RepositoryStr()
+		return strings.TrimPrefix(repository, "library/")
+	}
+	return ""
+}
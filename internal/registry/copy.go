@@ -0,0 +1,42 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// DefaultPlatform is the platform Cloud Run executes.
+var DefaultPlatform = v1.Platform{OS: "linux", Architecture: "amd64"}
+
+// Resolve fetches src and, when it is a multi-arch index, selects the child
+// image matching platform.
+func Resolve(ctx context.Context, src name.Reference, platform v1.Platform, opts ...remote.Option) (v1.Image, error) {
+	opts = append(append([]remote.Option{}, opts...), remote.WithContext(ctx), remote.WithPlatform(platform))
+	desc, err := remote.Get(src, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %v", src, err)
+	}
+	img, err := desc.Image()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s for platform %s: %v", src, platform, err)
+	}
+	return img, nil
+}
+
+// Pin resolves ref to the manifest digest it currently points at. References
+// that are already pinned are returned unchanged.
+func Pin(ctx context.Context, ref name.Reference, opts ...remote.Option) (name.Digest, error) {
+	if digest, ok := ref.(name.Digest); ok {
+		return digest, nil
+	}
+	opts = append(append([]remote.Option{}, opts...), remote.WithContext(ctx))
+	desc, err := remote.Head(ref, opts...)
+	if err != nil {
+		return name.Digest{}, fmt.Errorf("failed to resolve digest of %s: %v", ref, err)
+	}
+	return ref.Context().Digest(desc.Digest.String()), nil
+}
@@ -0,0 +1,119 @@
+package registry
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// newTestRegistry starts an in-process registry and returns its host.
+func newTestRegistry(t *testing.T) string {
+	t.Helper()
+	server := httptest.NewServer(ggcrregistry.New(ggcrregistry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+// TestResolveAndUpload copies images between two in-process registries the
+// way an import does, selecting the requested platform from a multi-arch
+// index.
+func TestResolveAndUpload(t *testing.T) {
+	ctx := context.Background()
+	srcHost, dstHost := newTestRegistry(t), newTestRegistry(t)
+	anonymous := []remote.Option{remote.WithAuth(authn.Anonymous)}
+
+	images := map[string]v1.Image{}
+	index := v1.ImageIndex(empty.Index)
+	for _, arch := range []string{"amd64", "arm64"} {
+		img, err := random.Image(512, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		images[arch] = img
+		index = mutate.AppendManifests(index, mutate.IndexAddendum{
+			Add: img,
+			Descriptor: v1.Descriptor{
+				Platform: &v1.Platform{OS: "linux", Architecture: arch},
+			},
+		})
+	}
+	multiArch, err := name.ParseReference(srcHost + "/vendor/app:multi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.WriteIndex(multiArch, index); err != nil {
+		t.Fatal(err)
+	}
+	single, err := name.ParseReference(srcHost + "/vendor/app:single")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(single, images["amd64"]); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		tag      string
+		src      name.Reference
+		platform v1.Platform
+		want     v1.Image
+	}{
+		{"single image", "single", single, DefaultPlatform, images["amd64"]},
+		{"index default platform", "amd64", multiArch, DefaultPlatform, images["amd64"]},
+		{"index other platform", "arm64", multiArch, v1.Platform{OS: "linux", Architecture: "arm64"}, images["arm64"]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst, err := name.ParseReference(dstHost + "/images/app:" + tt.tag)
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := Resolve(ctx, tt.src, tt.platform, anonymous...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := Upload(ctx, nil, dst, img, anonymous...); err != nil {
+				t.Fatal(err)
+			}
+			digest, err := img.Digest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := tt.want.Digest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if digest != want {
+				t.Errorf("resolved %s, want %s", digest, want)
+			}
+			desc, err := remote.Head(dst)
+			if err != nil {
+				t.Fatalf("copied image is missing: %v", err)
+			}
+			if desc.Digest != want {
+				t.Errorf("destination has %s, want %s", desc.Digest, want)
+			}
+		})
+	}
+
+	missing, err := name.ParseReference(srcHost + "/vendor/app:missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Resolve(ctx, missing, DefaultPlatform, anonymous...); err == nil {
+		t.Error("Resolve of a missing tag succeeded")
+	}
+}
@@ -0,0 +1,126 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"github.com/digizyne/lfcont/internal/jobs"
+)
+
+// PlatformImage is a child of a multi-arch index built for one platform.
+type PlatformImage struct {
+	Platform v1.Platform
+	Digest   v1.Hash
+	Image    v1.Image
+}
+
+// PlatformImages returns the runnable children of idx. Entries without a
+// platform, such as the attestation manifests buildx adds, are skipped.
+func PlatformImages(idx v1.ImageIndex) ([]PlatformImage, error) {
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	var images []PlatformImage
+	for _, desc := range manifest.Manifests {
+		if !desc.MediaType.IsImage() || desc.Platform == nil || desc.Platform.OS == "unknown" {
+			continue
+		}
+		img, err := idx.Image(desc.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s image: %v", desc.Platform, err)
+		}
+		images = append(images, PlatformImage{Platform: *desc.Platform, Digest: desc.Digest, Image: img})
+	}
+	return images, nil
+}
+
+// SelectPlatform returns the child of images matching platform.
+func SelectPlatform(images []PlatformImage, platform v1.Platform) (PlatformImage, bool) {
+	for _, image := range images {
+		if image.Platform.Satisfies(platform) {
+			return image, true
+		}
+	}
+	return PlatformImage{}, false
+}
+
+// UploadIndex pushes every image referenced by idx, including nested indexes
+// and attestations, then writes the index itself.
+func UploadIndex(ctx context.Context, r *jobs.Reporter, ref name.Reference, idx v1.ImageIndex, opts ...remote.Option) error {
+	var layers []v1.Layer
+	seen := map[v1.Hash]bool{}
+	err := walkIndex(idx, func(img v1.Image) error {
+		imgLayers, err := img.Layers()
+		if err != nil {
+			return err
+		}
+		for _, layer := range imgLayers {
+			digest, err := layer.Digest()
+			if err != nil {
+				return err
+			}
+			if !seen[digest] {
+				seen[digest] = true
+				layers = append(layers, layer)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := uploadLayers(ctx, r, ref.Context(), layers, opts); err != nil {
+		return err
+	}
+
+	r.Stage("writing manifest")
+	return remote.WriteIndex(ref, idx, append(append([]remote.Option{}, opts...), remote.WithContext(ctx))...)
+}
+
+// walkIndex calls fn for every image in idx and its nested indexes.
+func walkIndex(idx v1.ImageIndex, fn func(v1.Image) error) error {
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return err
+	}
+	for _, desc := range manifest.Manifests {
+		switch {
+		case desc.MediaType.IsIndex():
+			child, err := idx.ImageIndex(desc.Digest)
+			if err != nil {
+				return err
+			}
+			if err := walkIndex(child, fn); err != nil {
+				return err
+			}
+		case desc.MediaType.IsImage():
+			img, err := idx.Image(desc.Digest)
+			if err != nil {
+				return err
+			}
+			if err := fn(img); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PinPlatform resolves ref to the digest of the image that runs on platform:
+// the matching child for a multi-arch index, the image itself otherwise.
+func PinPlatform(ctx context.Context, ref name.Reference, platform v1.Platform, opts ...remote.Option) (name.Digest, error) {
+	img, err := Resolve(ctx, ref, platform, opts...)
+	if err != nil {
+		return name.Digest{}, err
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return name.Digest{}, err
+	}
+	return ref.Context().Digest(digest.String()), nil
+}
@@ -0,0 +1,98 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/digizyne/lfcont/internal/jobs"
+)
+
+// Upload pushes each layer separately so that per-layer progress can be
+// reported, then writes the config and manifest. opts must carry the
+// credentials for the destination registry.
+func Upload(ctx context.Context, r *jobs.Reporter, ref name.Reference, img v1.Image, opts ...remote.Option) error {
+	layers, err := img.Layers()
+	if err != nil {
+		return err
+	}
+	if err := uploadLayers(ctx, r, ref.Context(), layers, opts); err != nil {
+		return err
+	}
+
+	r.Stage("writing manifest")
+	return remote.Write(ref, img, append(append([]remote.Option{}, opts...), remote.WithContext(ctx))...)
+}
+
+// uploadLayers pushes layers to repo, at most three at a time.
+func uploadLayers(ctx context.Context, r *jobs.Reporter, repo name.Repository, layers []v1.Layer, opts []remote.Option) error {
+	progress := make([]jobs.LayerProgress, 0, len(layers))
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return err
+		}
+		size, err := layer.Size()
+		if err != nil {
+			return err
+		}
+		progress = append(progress, jobs.LayerProgress{Digest: digest.String(), Size: size})
+	}
+	r.Layers(progress)
+	r.Stage("uploading layers")
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(3)
+	for i, layer := range layers {
+		digest := progress[i].Digest
+		tracked := &trackedLayer{Layer: layer}
+		var upload v1.Layer = tracked
+		if ml, ok := layer.(*remote.MountableLayer); ok {
+			upload = &remote.MountableLayer{Layer: tracked, Reference: ml.Reference}
+		}
+		g.Go(func() error {
+			updates := make(chan v1.Update, 16)
+			done := make(chan error, 1)
+			layerOpts := append(append([]remote.Option{}, opts...), remote.WithContext(gctx), remote.WithProgress(updates))
+			go func() {
+				done <- remote.WriteLayer(repo, upload, layerOpts...)
+			}()
+			for update := range updates {
+				if update.Error != nil {
+					continue
+				}
+				r.Layer(digest, func(l *jobs.LayerProgress) { l.Uploaded = update.Complete })
+			}
+			if err := <-done; err != nil {
+				return fmt.Errorf("layer %s: %v", digest, err)
+			}
+			r.Layer(digest, func(l *jobs.LayerProgress) {
+				l.Done = true
+				l.Skipped = !tracked.opened.Load()
+				if l.Skipped {
+					l.Uploaded = 0
+				}
+			})
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// trackedLayer records whether the registry client actually read the layer
+// contents. Layers that already exist in the registry are never opened.
+type trackedLayer struct {
+	v1.Layer
+	opened atomic.Bool
+}
+
+func (l *trackedLayer) Compressed() (io.ReadCloser, error) {
+	l.opened.Store(true)
+	return l.Layer.Compressed()
+}
@@ -0,0 +1,338 @@
+package retention
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/digizyne/lfcont/internal/signing"
+)
+
+// Collector applies the stored retention policies, deleting expired manifests
+// from the registry along with their container_images rows.
+type Collector struct {
+	Pool *pgxpool.Pool
+	// RemoteOptions returns the credentials used to talk to the registry.
+	RemoteOptions func(ctx context.Context) []remote.Option
+}
+
+// RepositoryReport is the result of one policy. Error is set when the
+// policy could not be applied; the other repositories are still collected.
+type RepositoryReport struct {
+	Policy         Policy     `json:"policy"`
+	Decisions      []Decision `json:"decisions"`
+	ImagesDeleted  int        `json:"images_deleted"`
+	BytesReclaimed int64      `json:"bytes_reclaimed"`
+	Error          string     `json:"error,omitempty"`
+}
+
+type Report struct {
+	DryRun         bool               `json:"dry_run"`
+	StartedAt      time.Time          `json:"started_at"`
+	Repositories   []RepositoryReport `json:"repositories"`
+	ImagesDeleted  int                `json:"images_deleted"`
+	BytesReclaimed int64              `json:"bytes_reclaimed"`
+}
+
+// Run collects every interval until ctx is done.
+func (c *Collector) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := c.Collect(ctx, "", false)
+			if err != nil {
+				log.Printf("Retention collection failed: %v", err)
+				continue
+			}
+			log.Printf("Retention collection deleted %d images and reclaimed %d bytes", report.ImagesDeleted, report.BytesReclaimed)
+		}
+	}
+}
+
+// Collect applies the policies of username, or of every user when username
+// is empty. With dryRun set nothing is deleted and the report shows what
+// would be; BytesReclaimed is then an estimate.
+func (c *Collector) Collect(ctx context.Context, username string, dryRun bool) (Report, error) {
+	report := Report{DryRun: dryRun, StartedAt: time.Now(), Repositories: []RepositoryReport{}}
+
+	policies, err := Policies(ctx, c.Pool, username)
+	if err != nil {
+		return report, err
+	}
+	for _, policy := range policies {
+		repoReport, err := c.collectRepository(ctx, policy, report.StartedAt, dryRun)
+		if err != nil {
+			log.Printf("Retention of %s for %s failed: %v", policy.Repository, policy.Username, err)
+			repoReport.Error = err.Error()
+		}
+		report.Repositories = append(report.Repositories, repoReport)
+		report.ImagesDeleted += repoReport.ImagesDeleted
+		report.BytesReclaimed += repoReport.BytesReclaimed
+	}
+	return report, nil
+}
+
+// Policies loads the retention policies of username, or all of them.
+func Policies(ctx context.Context, pool *pgxpool.Pool, username string) ([]Policy, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT username, repository, keep_last, keep_days, updated_at
+		FROM retention_policies
+		WHERE $1 = '' OR username = $1
+		ORDER BY username, repository
+	`, username)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[Policy])
+}
+
+func (c *Collector) collectRepository(ctx context.Context, policy Policy, now time.Time, dryRun bool) (RepositoryReport, error) {
+	report := RepositoryReport{Policy: policy}
+
+	repo, err := name.NewRepository(policy.Repository)
+	if err != nil {
+		return report, err
+	}
+
+	rows, err := c.Pool.Query(ctx, `
+		SELECT ci.fqin, COALESCE(ci.digest, ''), ci.created_at,
+			EXISTS (
+				SELECT 1 FROM deployments d
+				WHERE d.container_image = ci.fqin OR (ci.digest IS NOT NULL AND d.image_digest = ci.digest)
+			) OR EXISTS (
+				SELECT 1 FROM deployment_revisions r
+				WHERE r.container_image = ci.fqin OR (ci.digest IS NOT NULL AND r.image_digest = ci.digest)
+			)
+		FROM container_images ci
+		WHERE ci.username = $1 AND starts_with(ci.fqin, $2 || ':')
+	`, policy.Username, policy.Repository)
+	if err != nil {
+		return report, err
+	}
+	images, err := pgx.CollectRows(rows, pgx.RowToStructByPos[Image])
+	if err != nil {
+		return report, err
+	}
+
+	report.Decisions = Plan(policy, images, now)
+
+	// A digest can only be deleted from the registry when no image that is
+	// kept, including other users' images in the same repository, shares it.
+	expired := map[string][]string{}
+	kept := map[string]bool{}
+	for _, d := range report.Decisions {
+		if d.Keep {
+			kept[d.Digest] = true
+		} else {
+			expired[d.Digest] = append(expired[d.Digest], d.Fqin)
+		}
+	}
+	var expiredFqins []string
+	for _, fqins := range expired {
+		expiredFqins = append(expiredFqins, fqins...)
+	}
+	if len(expiredFqins) == 0 {
+		return report, nil
+	}
+	shared, err := c.sharedDigests(ctx, policy.Repository, expiredFqins)
+	if err != nil {
+		return report, err
+	}
+
+	opts := append(c.RemoteOptions(ctx), remote.WithContext(ctx))
+	var deletable []string
+	for digest := range expired {
+		if digest != "" && !kept[digest] && !shared[digest] {
+			deletable = append(deletable, digest)
+		}
+	}
+	var keptDigests []string
+	for digest := range kept {
+		if digest != "" {
+			keptDigests = append(keptDigests, digest)
+		}
+	}
+	for digest := range shared {
+		keptDigests = append(keptDigests, digest)
+	}
+	if dryRun {
+		report.ImagesDeleted = len(expiredFqins)
+		report.BytesReclaimed = reclaimableBytes(repo, deletable, keptDigests, opts)
+		return report, nil
+	}
+
+	// The records go first: a failed registry delete leaves an unreferenced
+	// manifest behind, while the reverse order would leave records of images
+	// that no longer exist.
+	tx, err := c.Pool.Begin(ctx)
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, `DELETE FROM container_images WHERE fqin = ANY($1)`, expiredFqins); err != nil {
+		return report, err
+	}
+	// Digests recorded or deployed since the plan was made are kept
+	rows, err = tx.Query(ctx, `
+		SELECT candidate.digest FROM unnest($1::text[]) AS candidate(digest)
+		WHERE NOT EXISTS (SELECT 1 FROM container_images ci WHERE ci.digest = candidate.digest AND starts_with(ci.fqin, $2 || ':'))
+			AND NOT EXISTS (SELECT 1 FROM deployments d WHERE d.image_digest = candidate.digest)
+			AND NOT EXISTS (SELECT 1 FROM deployment_revisions r WHERE r.image_digest = candidate.digest)
+	`, deletable, policy.Repository)
+	if err != nil {
+		return report, err
+	}
+	rechecked, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return report, err
+	}
+	stillDeletable := map[string]bool{}
+	for _, digest := range rechecked {
+		stillDeletable[digest] = true
+	}
+	for _, digest := range deletable {
+		if !stillDeletable[digest] {
+			keptDigests = append(keptDigests, digest)
+		}
+	}
+	deletable = rechecked
+	// Inventory is keyed by digest and may be shared by images that remain.
+	if _, err := tx.Exec(ctx, `
+		DELETE FROM vulnerability_findings vf
+		WHERE vf.digest = ANY($1) AND NOT EXISTS (SELECT 1 FROM container_images ci WHERE ci.digest = vf.digest)
+	`, deletable); err != nil {
+		return report, err
+	}
+	if _, err := tx.Exec(ctx, `
+		DELETE FROM sboms s
+		WHERE s.digest = ANY($1) AND NOT EXISTS (SELECT 1 FROM container_images ci WHERE ci.digest = s.digest)
+	`, deletable); err != nil {
+		return report, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return report, err
+	}
+	report.ImagesDeleted = len(expiredFqins)
+
+	// Manifests are read before they are deleted; only the digests that
+	// survived the recheck are counted
+	report.BytesReclaimed = reclaimableBytes(repo, deletable, keptDigests, opts)
+	for _, digest := range deletable {
+		ref := repo.Digest(digest)
+		if err := remote.Delete(ref, opts...); err != nil {
+			log.Printf("Warning: retention failed to delete %s from the registry: %v", ref, err)
+			continue
+		}
+		deleteSignatures(ref, opts)
+	}
+
+	if _, err := c.Pool.Exec(ctx, `
+		INSERT INTO retention_reclaims (username, repository, images_deleted, bytes_reclaimed)
+		VALUES ($1, $2, $3, $4)
+	`, policy.Username, policy.Repository, report.ImagesDeleted, report.BytesReclaimed); err != nil {
+		return report, err
+	}
+
+	log.Printf("Retention deleted %d images from %s for %s", report.ImagesDeleted, policy.Repository, policy.Username)
+	return report, nil
+}
+
+// sharedDigests returns the digests of fqins that are also recorded under a
+// tag outside fqins in the same repository.
+func (c *Collector) sharedDigests(ctx context.Context, repository string, fqins []string) (map[string]bool, error) {
+	rows, err := c.Pool.Query(ctx, `
+		SELECT DISTINCT other.digest
+		FROM container_images expired
+		JOIN container_images other ON other.digest = expired.digest AND other.fqin <> ALL($1)
+		WHERE expired.fqin = ANY($1) AND starts_with(other.fqin, $2 || ':')
+	`, fqins, repository)
+	if err != nil {
+		return nil, err
+	}
+	digests, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+	shared := map[string]bool{}
+	for _, digest := range digests {
+		shared[digest] = true
+	}
+	return shared, nil
+}
+
+// reclaimableBytes sums the blobs referenced only by deleted manifests. The
+// registry may keep blobs still used by manifests outside our records, so
+// this is an upper bound.
+func reclaimableBytes(repo name.Repository, deleted, kept []string, opts []remote.Option) int64 {
+	keptBlobs := map[string]bool{}
+	for _, digest := range kept {
+		for blob := range manifestBlobs(repo.Digest(digest), opts) {
+			keptBlobs[blob] = true
+		}
+	}
+	var total int64
+	counted := map[string]bool{}
+	for _, digest := range deleted {
+		for blob, size := range manifestBlobs(repo.Digest(digest), opts) {
+			if keptBlobs[blob] || counted[blob] {
+				continue
+			}
+			counted[blob] = true
+			total += size
+		}
+	}
+	return total
+}
+
+// manifestBlobs returns the manifest, config and layer blobs of an image with
+// their sizes. Images that cannot be read contribute nothing.
+func manifestBlobs(ref name.Digest, opts []remote.Option) map[string]int64 {
+	blobs := map[string]int64{}
+	desc, err := remote.Get(ref, opts...)
+	if err != nil {
+		log.Printf("Warning: failed to read manifest %s: %v", ref, err)
+		return blobs
+	}
+	blobs[desc.Digest.String()] = desc.Size
+	if desc.MediaType.IsIndex() {
+		return blobs
+	}
+	img, err := desc.Image()
+	if err != nil {
+		return blobs
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return blobs
+	}
+	blobs[manifest.Config.Digest.String()] = manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		blobs[layer.Digest.String()] = layer.Size
+	}
+	return blobs
+}
+
+// deleteSignatures removes the cosign signature artifact of a deleted image,
+// if there is one.
+func deleteSignatures(ref name.Digest, opts []remote.Option) {
+	tag, err := signing.SignatureTag(ref)
+	if err != nil {
+		return
+	}
+	desc, err := remote.Head(tag, opts...)
+	if err != nil {
+		return
+	}
+	if err := remote.Delete(tag.Context().Digest(desc.Digest.String()), opts...); err != nil {
+		log.Printf("Warning: failed to delete signatures %s: %v", tag, err)
+	}
+}
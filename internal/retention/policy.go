@@ -0,0 +1,76 @@
+package retention
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Policy decides which of a user's images in one repository are kept. An
+// image is kept when any rule keeps it: it is one of the newest KeepLast
+// images, it is younger than KeepDays, or a deployment references it. A zero
+// value disables the corresponding rule; with both disabled nothing expires.
+type Policy struct {
+	Username   string    `json:"username"`
+	Repository string    `json:"repository"`
+	KeepLast   int       `json:"keep_last"`
+	KeepDays   int       `json:"keep_days"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func (p Policy) Validate() error {
+	if p.KeepLast < 0 {
+		return fmt.Errorf("keep_last must not be negative")
+	}
+	if p.KeepDays < 0 {
+		return fmt.Errorf("keep_days must not be negative")
+	}
+	return nil
+}
+
+// Image is a recorded image considered by a policy.
+type Image struct {
+	Fqin      string    `json:"fqin"`
+	Digest    string    `json:"digest"`
+	CreatedAt time.Time `json:"created_at"`
+	// Deployed is set while a deployment or a revision it can be rolled
+	// back to references the image.
+	Deployed bool `json:"deployed"`
+}
+
+// Decision is the outcome of a policy for one image.
+type Decision struct {
+	Image
+	Keep   bool   `json:"keep"`
+	Reason string `json:"reason"`
+}
+
+// Plan applies the policy to images as of now. Decisions are returned newest
+// first.
+func Plan(p Policy, images []Image, now time.Time) []Decision {
+	sorted := append([]Image(nil), images...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].CreatedAt.After(sorted[j].CreatedAt)
+	})
+
+	cutoff := now.AddDate(0, 0, -p.KeepDays)
+	decisions := make([]Decision, 0, len(sorted))
+	for i, img := range sorted {
+		d := Decision{Image: img, Keep: true}
+		switch {
+		case img.Deployed:
+			d.Reason = "referenced by a deployment or one of its revisions"
+		case p.KeepLast == 0 && p.KeepDays == 0:
+			d.Reason = "no retention limits set"
+		case p.KeepLast > 0 && i < p.KeepLast:
+			d.Reason = fmt.Sprintf("one of the %d newest images", p.KeepLast)
+		case p.KeepDays > 0 && img.CreatedAt.After(cutoff):
+			d.Reason = fmt.Sprintf("pushed within the last %d days", p.KeepDays)
+		default:
+			d.Keep = false
+			d.Reason = "expired"
+		}
+		decisions = append(decisions, d)
+	}
+	return decisions
+}
@@ -0,0 +1,85 @@
+package retention
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPlan(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) time.Time { return now.AddDate(0, 0, -days) }
+	images := []Image{
+		{Fqin: "app:old", CreatedAt: daysAgo(40)},
+		{Fqin: "app:new", CreatedAt: daysAgo(1)},
+		{Fqin: "app:deployed", CreatedAt: daysAgo(90), Deployed: true},
+		{Fqin: "app:mid", CreatedAt: daysAgo(10)},
+	}
+
+	tests := []struct {
+		name   string
+		policy Policy
+		keep   map[string]bool
+	}{
+		{
+			name:   "no limits keeps everything",
+			policy: Policy{},
+			keep:   map[string]bool{"app:new": true, "app:mid": true, "app:old": true, "app:deployed": true},
+		},
+		{
+			name:   "keep last",
+			policy: Policy{KeepLast: 1},
+			keep:   map[string]bool{"app:new": true, "app:mid": false, "app:old": false, "app:deployed": true},
+		},
+		{
+			name:   "keep days",
+			policy: Policy{KeepDays: 30},
+			keep:   map[string]bool{"app:new": true, "app:mid": true, "app:old": false, "app:deployed": true},
+		},
+		{
+			name:   "either rule keeps an image",
+			policy: Policy{KeepLast: 3, KeepDays: 5},
+			keep:   map[string]bool{"app:new": true, "app:mid": true, "app:old": true, "app:deployed": true},
+		},
+		{
+			name:   "deployed images outlive every limit",
+			policy: Policy{KeepLast: 2, KeepDays: 5},
+			keep:   map[string]bool{"app:new": true, "app:mid": true, "app:old": false, "app:deployed": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decisions := Plan(tt.policy, images, now)
+			if len(decisions) != len(images) {
+				t.Fatalf("got %d decisions, want %d", len(decisions), len(images))
+			}
+			for i, d := range decisions {
+				if i > 0 && d.CreatedAt.After(decisions[i-1].CreatedAt) {
+					t.Errorf("decisions are not newest first: %s after %s", d.Fqin, decisions[i-1].Fqin)
+				}
+				if d.Keep != tt.keep[d.Fqin] {
+					t.Errorf("%s: keep = %v (%s), want %v", d.Fqin, d.Keep, d.Reason, tt.keep[d.Fqin])
+				}
+			}
+		})
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		wantErr bool
+	}{
+		{"zero", Policy{}, false},
+		{"limits", Policy{KeepLast: 5, KeepDays: 30}, false},
+		{"negative keep last", Policy{KeepLast: -1}, true},
+		{"negative keep days", Policy{KeepDays: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package sbom
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const FormatCycloneDX = "CycloneDX"
+
+// Document is the subset of a CycloneDX 1.5 JSON BOM that we produce.
+type Document struct {
+	BOMFormat    string      `json:"bomFormat"`
+	SpecVersion  string      `json:"specVersion"`
+	SerialNumber string      `json:"serialNumber"`
+	Version      int         `json:"version"`
+	Metadata     Metadata    `json:"metadata"`
+	Components   []Component `json:"components"`
+}
+
+type Metadata struct {
+	Timestamp string    `json:"timestamp"`
+	Component Component `json:"component"`
+}
+
+type Component struct {
+	BOMRef     string     `json:"bom-ref,omitempty"`
+	Type       string     `json:"type"`
+	Name       string     `json:"name"`
+	Version    string     `json:"version,omitempty"`
+	PURL       string     `json:"purl,omitempty"`
+	Properties []Property `json:"properties,omitempty"`
+}
+
+type Property struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Property names used to keep the scan details in the document.
+const (
+	PropertyType   = "lfcont:package:type"
+	PropertyArch   = "lfcont:package:arch"
+	PropertySource = "lfcont:package:source"
+	PropertyDistro = "lfcont:package:distro"
+	PropertyPath   = "lfcont:package:path"
+	PropertyLayer  = "lfcont:layer:digest"
+)
+
+// NewCycloneDX wraps the packages found in an image into a CycloneDX BOM.
+func NewCycloneDX(image, digest string, pkgs []Package) Document {
+	components := make([]Component, 0, len(pkgs))
+	for _, p := range pkgs {
+		components = append(components, Component{
+			BOMRef:  p.PURL + "#" + p.Path,
+			Type:    "library",
+			Name:    p.Name,
+			Version: p.Version,
+			PURL:    p.PURL,
+			Properties: []Property{
+				{Name: PropertyType, Value: p.Type},
+				{Name: PropertyArch, Value: p.Arch},
+				{Name: PropertySource, Value: p.Source},
+				{Name: PropertyDistro, Value: p.Distro},
+				{Name: PropertyPath, Value: p.Path},
+				{Name: PropertyLayer, Value: p.Layer},
+			},
+		})
+	}
+	return Document{
+		BOMFormat:    FormatCycloneDX,
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + uuid.New().String(),
+		Version:      1,
+		Metadata: Metadata{
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Component: Component{
+				BOMRef:  digest,
+				Type:    "container",
+				Name:    image,
+				Version: digest,
+			},
+		},
+		Components: components,
+	}
+}
+
+// Packages recovers the package list from a document produced by NewCycloneDX.
+func (d Document) Packages() []Package {
+	pkgs := make([]Package, 0, len(d.Components))
+	for _, c := range d.Components {
+		p := Package{Name: c.Name, Version: c.Version, PURL: c.PURL}
+		for _, prop := range c.Properties {
+			switch prop.Name {
+			case PropertyType:
+				p.Type = prop.Value
+			case PropertyArch:
+				p.Arch = prop.Value
+			case PropertySource:
+				p.Source = prop.Value
+			case PropertyDistro:
+				p.Distro = prop.Value
+			case PropertyPath:
+				p.Path = prop.Value
+			case PropertyLayer:
+				p.Layer = prop.Value
+			}
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs
+}
@@ -0,0 +1,358 @@
+package sbom
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"debug/buildinfo"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+const (
+	TypeDeb    = "deb"
+	TypeApk    = "apk"
+	TypeRpm    = "rpm"
+	TypeGolang = "golang"
+)
+
+// Package is a single installed OS package or language module.
+// Source is the source package an OS package was built from, which is what
+// distribution advisories refer to. Distro is the "<id>-<version_id>" of the
+// image's os-release.
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Type    string `json:"type"`
+	Arch    string `json:"arch,omitempty"`
+	Source  string `json:"source,omitempty"`
+	Distro  string `json:"distro,omitempty"`
+	PURL    string `json:"purl"`
+	Path    string `json:"path"`
+	Layer   string `json:"layer"`
+}
+
+// maxBinarySize bounds how large an executable we inspect for Go build info.
+const maxBinarySize = 512 << 20
+
+var elfMagic = []byte{0x7f, 'E', 'L', 'F'}
+
+// Scan walks the image layers from the bottom up and returns the packages
+// present in the final filesystem. Package databases replaced or deleted by a
+// later layer (including through whiteouts) are dropped.
+func Scan(img v1.Image) ([]Package, error) {
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	// Packages are keyed by the file they were read from so that a later layer
+	// rewriting or deleting that file replaces them.
+	files := make(map[string][]Package)
+	var distro osRelease
+
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return nil, err
+		}
+		if err := scanLayer(layer, digest.String(), files, &distro); err != nil {
+			return nil, fmt.Errorf("layer %s: %v", digest, err)
+		}
+	}
+
+	var pkgs []Package
+	for _, found := range files {
+		for _, p := range found {
+			if p.Type != TypeGolang && distro.id != "" {
+				p.Distro = distro.id + "-" + distro.versionID
+			}
+			p.PURL = purl(p, distro.id)
+			pkgs = append(pkgs, p)
+		}
+	}
+	sort.Slice(pkgs, func(i, j int) bool {
+		if pkgs[i].Type != pkgs[j].Type {
+			return pkgs[i].Type < pkgs[j].Type
+		}
+		if pkgs[i].Name != pkgs[j].Name {
+			return pkgs[i].Name < pkgs[j].Name
+		}
+		return pkgs[i].Path < pkgs[j].Path
+	})
+	return pkgs, nil
+}
+
+func scanLayer(layer v1.Layer, digest string, files map[string][]Package, distro *osRelease) error {
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean("/" + hdr.Name)
+		dir, base := path.Split(name)
+		if base == ".wh..wh..opq" {
+			removeUnder(files, dir)
+			continue
+		}
+		if strings.HasPrefix(base, ".wh.") {
+			removed := path.Join(dir, strings.TrimPrefix(base, ".wh."))
+			delete(files, removed)
+			removeUnder(files, removed+"/")
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		var found []Package
+		switch {
+		case name == "/etc/os-release" || name == "/usr/lib/os-release":
+			if release := parseOsRelease(tr); release.id != "" {
+				*distro = release
+			}
+			continue
+		case name == "/var/lib/dpkg/status" || strings.HasPrefix(name, "/var/lib/dpkg/status.d/"):
+			found, err = parseDpkg(tr)
+		case name == "/lib/apk/db/installed":
+			found, err = parseApk(tr)
+		case name == "/var/lib/rpmmanifest/container-manifest-2":
+			found, err = parseRpmManifest(tr)
+		case hdr.Mode&0o111 != 0 && hdr.Size > int64(len(elfMagic)) && hdr.Size <= maxBinarySize:
+			found, err = parseGoBinary(tr)
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+
+		for i := range found {
+			found[i].Path = name
+			found[i].Layer = digest
+		}
+		if len(found) == 0 {
+			delete(files, name)
+		} else {
+			files[name] = found
+		}
+	}
+}
+
+func removeUnder(files map[string][]Package, dir string) {
+	for name := range files {
+		if strings.HasPrefix(name, dir) {
+			delete(files, name)
+		}
+	}
+}
+
+type osRelease struct {
+	id        string
+	versionID string
+}
+
+func parseOsRelease(r io.Reader) osRelease {
+	var release osRelease
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"'`)
+		switch key {
+		case "ID":
+			release.id = value
+		case "VERSION_ID":
+			release.versionID = value
+		}
+	}
+	return release
+}
+
+// parseDpkg reads a dpkg status file (or a distroless status.d entry).
+func parseDpkg(r io.Reader) ([]Package, error) {
+	var pkgs []Package
+	err := parseStanzas(r, ": ", func(fields map[string]string) {
+		status, hasStatus := fields["Status"]
+		if fields["Package"] == "" || (hasStatus && !strings.HasSuffix(status, " installed")) {
+			return
+		}
+		// Source is "name" or "name (version)" when it differs from the binary.
+		source, _, _ := strings.Cut(fields["Source"], " ")
+		pkgs = append(pkgs, Package{
+			Name:    fields["Package"],
+			Version: fields["Version"],
+			Arch:    fields["Architecture"],
+			Source:  source,
+			Type:    TypeDeb,
+		})
+	})
+	return pkgs, err
+}
+
+// parseApk reads the Alpine installed database.
+func parseApk(r io.Reader) ([]Package, error) {
+	var pkgs []Package
+	err := parseStanzas(r, ":", func(fields map[string]string) {
+		if fields["P"] == "" {
+			return
+		}
+		pkgs = append(pkgs, Package{
+			Name:    fields["P"],
+			Version: fields["V"],
+			Arch:    fields["A"],
+			Source:  fields["o"],
+			Type:    TypeApk,
+		})
+	})
+	return pkgs, err
+}
+
+// parseStanzas splits blank-line separated "key<sep>value" records.
+func parseStanzas(r io.Reader, sep string, emit func(map[string]string)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	fields := map[string]string{}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if len(fields) > 0 {
+				emit(fields)
+			}
+			fields = map[string]string{}
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			// Continuation of a multi-line field such as Description.
+			continue
+		}
+		if key, value, ok := strings.Cut(line, sep); ok {
+			fields[key] = strings.TrimSpace(value)
+		}
+	}
+	if len(fields) > 0 {
+		emit(fields)
+	}
+	return scanner.Err()
+}
+
+// parseRpmManifest reads the tab separated package manifest that rpm-based
+// distroless images (which ship without an rpm database) carry instead.
+func parseRpmManifest(r io.Reader) ([]Package, error) {
+	var pkgs []Package
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		cols := strings.Split(scanner.Text(), "\t")
+		if len(cols) < 2 || cols[0] == "" {
+			continue
+		}
+		p := Package{Name: cols[0], Version: cols[1], Type: TypeRpm}
+		if len(cols) > 7 {
+			p.Arch = cols[7]
+		}
+		if len(cols) > 9 {
+			p.Source = sourceRpmName(cols[9])
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs, scanner.Err()
+}
+
+// sourceRpmName turns "openssl-3.0.8-1.el9.src.rpm" into "openssl".
+func sourceRpmName(srpm string) string {
+	name := strings.TrimSuffix(srpm, ".src.rpm")
+	for i := 0; i < 2; i++ {
+		if idx := strings.LastIndex(name, "-"); idx > 0 {
+			name = name[:idx]
+		}
+	}
+	return name
+}
+
+// parseGoBinary extracts the module list embedded in Go executables. Files
+// that are not Go ELF binaries yield no packages.
+func parseGoBinary(r io.Reader) ([]Package, error) {
+	magic := make([]byte, len(elfMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, nil
+	}
+	if !bytes.Equal(magic, elfMagic) {
+		return nil, nil
+	}
+
+	// buildinfo needs random access, so spool the binary to disk.
+	f, err := os.CreateTemp("", "sbom-bin-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := io.Copy(f, io.MultiReader(bytes.NewReader(magic), r)); err != nil {
+		return nil, err
+	}
+
+	info, err := buildinfo.Read(f)
+	if err != nil {
+		// Not a Go binary.
+		return nil, nil
+	}
+
+	pkgs := []Package{{Name: "stdlib", Version: info.GoVersion, Type: TypeGolang}}
+	if info.Main.Path != "" {
+		pkgs = append(pkgs, Package{Name: info.Main.Path, Version: info.Main.Version, Type: TypeGolang})
+	}
+	for _, dep := range info.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		pkgs = append(pkgs, Package{Name: dep.Path, Version: dep.Version, Type: TypeGolang})
+	}
+	return pkgs, nil
+}
+
+var purlVersionEscaper = strings.NewReplacer(":", "%3A", "+", "%2B", " ", "%20")
+
+// purl builds a package URL (https://github.com/package-url/purl-spec).
+func purl(p Package, distro string) string {
+	version := purlVersionEscaper.Replace(p.Version)
+	switch p.Type {
+	case TypeGolang:
+		return fmt.Sprintf("pkg:golang/%s@%s", p.Name, version)
+	default:
+		namespace := distro
+		if namespace == "" {
+			namespace = "unknown"
+		}
+		s := fmt.Sprintf("pkg:%s/%s/%s@%s", p.Type, namespace, p.Name, version)
+		var qualifiers []string
+		if p.Arch != "" {
+			qualifiers = append(qualifiers, "arch="+p.Arch)
+		}
+		if p.Distro != "" {
+			qualifiers = append(qualifiers, "distro="+p.Distro)
+		}
+		if len(qualifiers) > 0 {
+			s += "?" + strings.Join(qualifiers, "&")
+		}
+		return s
+	}
+}
@@ -0,0 +1,58 @@
+// Package sealing encrypts values for storage with AES-GCM. Keys derived from
+// a configured secret go through HKDF with a label naming their purpose, so
+// one secret can serve several uses without their keys colliding.
+package sealing
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+)
+
+// KeySize is the size of AES-256 keys, both derived and random.
+const KeySize = 32
+
+// Derive returns an AEAD keyed with HKDF-SHA256 of secret for label.
+func Derive(secret, label string) (cipher.AEAD, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("no secret configured")
+	}
+	key, err := hkdf.Key(sha256.New, []byte(secret), nil, label, KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return NewAEAD(key)
+}
+
+// NewAEAD returns an AEAD for a random key, such as a data key.
+func NewAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes", KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts plaintext under a random nonce, which prefixes the result.
+func Seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open reverses Seal.
+func Open(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("sealed value is truncated")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
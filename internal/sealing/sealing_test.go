@@ -0,0 +1,88 @@
+package sealing
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDerive(t *testing.T) {
+	plaintext := []byte("signing key")
+	aead, err := Derive("secret", "label a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := Seal(aead, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := Seal(aead, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(sealed, again) {
+		t.Error("sealing twice gave the same ciphertext")
+	}
+
+	same, err := Derive("secret", "label a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened, err := Open(same, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("Open() = %q, want %q", opened, plaintext)
+	}
+
+	for _, other := range []struct{ secret, label string }{
+		{"secret", "label b"},
+		{"other secret", "label a"},
+	} {
+		aead, err := Derive(other.secret, other.label)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Open(aead, sealed); err == nil {
+			t.Errorf("Open() with secret %q and label %q succeeded", other.secret, other.label)
+		}
+	}
+
+	if _, err := Derive("", "label a"); err == nil {
+		t.Error("Derive() accepted an empty secret")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	aead, err := NewAEAD(bytes.Repeat([]byte{7}, KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := Seal(aead, []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name   string
+		sealed []byte
+	}{
+		{"tampered", tampered},
+		{"truncated", sealed[:aead.NonceSize()-1]},
+		{"nonce only", sealed[:aead.NonceSize()]},
+		{"empty", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Open(aead, tt.sealed); err == nil {
+				t.Error("Open() succeeded")
+			}
+		})
+	}
+
+	if _, err := NewAEAD([]byte("short")); err == nil {
+		t.Error("NewAEAD() accepted a short key")
+	}
+}
@@ -0,0 +1,177 @@
+package secrets
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+const (
+	RulePrivateKey        = "private-key"
+	RuleGCPServiceAccount = "gcp-service-account"
+	RuleAWSAccessKey      = "aws-access-key"
+	RuleDotenv            = "dotenv"
+	RuleGitDirectory      = "git-directory"
+)
+
+const (
+	// maxScannedFileSize bounds which files have their contents searched.
+	maxScannedFileSize = 1 << 20
+	// binarySniffLen is how much of a file is checked for NUL bytes to decide
+	// whether it is binary.
+	binarySniffLen = 8000
+)
+
+// Finding is a likely secret found in an image layer. The secret itself is
+// never included.
+type Finding struct {
+	Rule    string `json:"rule"`
+	Path    string `json:"path"`
+	Layer   string `json:"layer"`
+	Message string `json:"message"`
+}
+
+var (
+	privateKeyPattern     = regexp.MustCompile(`-----BEGIN ((RSA|DSA|EC|OPENSSH|ENCRYPTED|PGP) )?PRIVATE KEY( BLOCK)?-----`)
+	serviceAccountPattern = regexp.MustCompile(`"type"\s*:\s*"service_account"`)
+	awsAccessKeyPattern   = regexp.MustCompile(`\b(AKIA|ASIA|A3T[A-Z0-9])[A-Z0-9]{16}\b`)
+)
+
+// Scan inspects every layer of the image. Whiteouts are deliberately ignored:
+// a secret deleted in a later layer is still published in the earlier one.
+func Scan(img v1.Image) ([]Finding, error) {
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	findings := []Finding{}
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return nil, err
+		}
+		layerFindings, err := scanLayer(layer, digest.String())
+		if err != nil {
+			return nil, fmt.Errorf("layer %s: %v", digest, err)
+		}
+		findings = append(findings, layerFindings...)
+	}
+	return findings, nil
+}
+
+func scanLayer(layer v1.Layer, digest string) ([]Finding, error) {
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var findings []Finding
+	gitDirs := map[string]bool{}
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := path.Clean("/" + hdr.Name)
+		if strings.HasPrefix(path.Base(name), ".wh.") {
+			continue
+		}
+
+		// Report each .git directory once rather than every object inside it.
+		if idx := strings.Index(name+"/", "/.git/"); idx >= 0 {
+			gitDir := name[:idx] + "/.git"
+			if !gitDirs[gitDir] {
+				gitDirs[gitDir] = true
+				findings = append(findings, Finding{
+					Rule:    RuleGitDirectory,
+					Path:    gitDir,
+					Layer:   digest,
+					Message: "git repository metadata (history, remotes and possibly credentials) is included in the image",
+				})
+			}
+			continue
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if isDotenv(path.Base(name)) {
+			findings = append(findings, Finding{
+				Rule:    RuleDotenv,
+				Path:    name,
+				Layer:   digest,
+				Message: "environment file is included in the image",
+			})
+		}
+
+		if hdr.Size == 0 || hdr.Size > maxScannedFileSize {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, scanContent(name, digest, content)...)
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Path < findings[j].Path })
+	return findings, nil
+}
+
+func isDotenv(base string) bool {
+	if base != ".env" && !strings.HasPrefix(base, ".env.") {
+		return false
+	}
+	// Templates are meant to be committed and shipped.
+	for _, suffix := range []string{".example", ".sample", ".template", ".dist"} {
+		if strings.HasSuffix(base, suffix) {
+			return false
+		}
+	}
+	return true
+}
+
+func scanContent(name, digest string, content []byte) []Finding {
+	var findings []Finding
+	if privateKeyPattern.Match(content) {
+		findings = append(findings, Finding{
+			Rule:    RulePrivateKey,
+			Path:    name,
+			Layer:   digest,
+			Message: "file contains a PEM encoded private key",
+		})
+	}
+	if serviceAccountPattern.Match(content) && bytes.Contains(content, []byte(`"private_key"`)) {
+		findings = append(findings, Finding{
+			Rule:    RuleGCPServiceAccount,
+			Path:    name,
+			Layer:   digest,
+			Message: "file contains a Google Cloud service account key",
+		})
+	}
+	// AWS key IDs are plain text; skip binaries where random matches are common.
+	if !bytes.Contains(content[:min(len(content), binarySniffLen)], []byte{0}) && awsAccessKeyPattern.Match(content) {
+		findings = append(findings, Finding{
+			Rule:    RuleAWSAccessKey,
+			Path:    name,
+			Layer:   digest,
+			Message: "file contains an AWS access key ID",
+		})
+	}
+	return findings
+}
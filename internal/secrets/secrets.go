@@ -0,0 +1,82 @@
+package secrets
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"os"
+
+	"github.com/digizyne/lfcont/internal/sealing"
+)
+
+// Sealed is an envelope-encrypted value: Ciphertext is encrypted under a
+// random data key, and Key is that data key encrypted under the master key.
+// Both carry their nonce as a prefix.
+type Sealed struct {
+	Key        []byte `json:"key"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// MasterKey returns DEPLOY_SECRETS_KEY, the secret the data keys are
+// encrypted under. Deployment secrets are disabled when it is empty.
+func MasterKey() string {
+	return os.Getenv("DEPLOY_SECRETS_KEY")
+}
+
+// secretEncryptionLabel binds the key derived from DEPLOY_SECRETS_KEY to
+// wrapping the data keys of deployment secrets.
+const secretEncryptionLabel = "lfcont deployment secret encryption v1"
+
+// Seal encrypts value under a new data key wrapped with masterKey.
+func Seal(value, masterKey string) (Sealed, error) {
+	master, err := masterAEAD(masterKey)
+	if err != nil {
+		return Sealed{}, err
+	}
+	dataKey := make([]byte, sealing.KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return Sealed{}, err
+	}
+	data, err := sealing.NewAEAD(dataKey)
+	if err != nil {
+		return Sealed{}, err
+	}
+
+	wrappedKey, err := sealing.Seal(master, dataKey)
+	if err != nil {
+		return Sealed{}, err
+	}
+	ciphertext, err := sealing.Seal(data, []byte(value))
+	if err != nil {
+		return Sealed{}, err
+	}
+	return Sealed{Key: wrappedKey, Ciphertext: ciphertext}, nil
+}
+
+// Open reverses Seal.
+func Open(s Sealed, masterKey string) (string, error) {
+	master, err := masterAEAD(masterKey)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := sealing.Open(master, s.Key)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt data key: %v", err)
+	}
+	data, err := sealing.NewAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	value, err := sealing.Open(data, s.Ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret: %v", err)
+	}
+	return string(value), nil
+}
+
+func masterAEAD(masterKey string) (cipher.AEAD, error) {
+	if masterKey == "" {
+		return nil, fmt.Errorf("no secrets key configured")
+	}
+	return sealing.Derive(masterKey, secretEncryptionLabel)
+}
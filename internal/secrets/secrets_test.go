@@ -0,0 +1,83 @@
+package secrets
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/digizyne/lfcont/internal/sealing"
+)
+
+func TestSealOpen(t *testing.T) {
+	for _, value := range []string{"", "hunter2", "multi\nline\x00value"} {
+		sealed, err := Seal(value, "master")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(value) > 0 && bytes.Contains(sealed.Ciphertext, []byte(value)) {
+			t.Errorf("ciphertext contains the plaintext %q", value)
+		}
+		opened, err := Open(sealed, "master")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opened != value {
+			t.Errorf("Open() = %q, want %q", opened, value)
+		}
+	}
+
+	first, err := Seal("value", "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Seal("value", "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first.Key, second.Key) || bytes.Equal(first.Ciphertext, second.Ciphertext) {
+		t.Error("sealing twice reused a data key or nonce")
+	}
+}
+
+func TestOpenFails(t *testing.T) {
+	sealed, err := Seal("value", "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := Seal("other", "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := Sealed{Key: sealed.Key, Ciphertext: bytes.Clone(sealed.Ciphertext)}
+	tampered.Ciphertext[len(tampered.Ciphertext)-1] ^= 1
+
+	// A value sealed under the signing key label must not open as a
+	// deployment secret even with the same secret.
+	signingKey, err := sealing.Derive("master", "lfcont signing key encryption v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	crossKey, err := sealing.Seal(signingKey, bytes.Repeat([]byte{1}, sealing.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		sealed    Sealed
+		masterKey string
+	}{
+		{"wrong master key", sealed, "other master"},
+		{"no master key", sealed, ""},
+		{"tampered ciphertext", tampered, "master"},
+		{"swapped data key", Sealed{Key: other.Key, Ciphertext: sealed.Ciphertext}, "master"},
+		{"key wrapped for another purpose", Sealed{Key: crossKey, Ciphertext: sealed.Ciphertext}, "master"},
+		{"empty", Sealed{}, "master"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Open(tt.sealed, tt.masterKey); err == nil {
+				t.Error("Open() succeeded")
+			}
+		})
+	}
+}
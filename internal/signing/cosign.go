@@ -0,0 +1,212 @@
+package signing
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// These match the layout cosign uses, so images signed here verify with
+// 'cosign verify --key' and signatures made by cosign verify here.
+const (
+	SimpleSigningMediaType types.MediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	SignatureAnnotation                    = "dev.cosignproject.cosign/signature"
+	signatureType                          = "cosign container image signature"
+)
+
+// ErrNoSignature is returned by Verify when no signature made by one of the
+// trusted keys is attached to the image.
+var ErrNoSignature = errors.New("no valid signature from a trusted key")
+
+// payload is the Red Hat simple signing format cosign signs.
+type payload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]any `json:"optional"`
+}
+
+// SignatureTag returns where the signatures of digest are stored:
+// <repository>:sha256-<hex>.sig.
+func SignatureTag(digest name.Digest) (name.Tag, error) {
+	h, err := v1.NewHash(digest.DigestStr())
+	if err != nil {
+		return name.Tag{}, err
+	}
+	return digest.Context().Tag(fmt.Sprintf("%s-%s.sig", h.Algorithm, h.Hex)), nil
+}
+
+// Sign attaches an ECDSA signature over digest to the image's signature
+// artifact, keeping signatures that are already there. Signing is a no-op
+// when the artifact already holds a valid signature from key.
+func Sign(ctx context.Context, digest name.Digest, key *ecdsa.PrivateKey, opts ...remote.Option) error {
+	opts = append(append([]remote.Option{}, opts...), remote.WithContext(ctx))
+	tag, err := SignatureTag(digest)
+	if err != nil {
+		return err
+	}
+
+	base, err := signatureImage(tag, opts...)
+	if err != nil {
+		return err
+	}
+	if _, err := verifyImage(base, digest, map[string]crypto.PublicKey{"": &key.PublicKey}); err == nil {
+		return nil
+	}
+
+	var body payload
+	body.Critical.Identity.DockerReference = digest.Context().String()
+	body.Critical.Image.DockerManifestDigest = digest.DigestStr()
+	body.Critical.Type = signatureType
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	if err != nil {
+		return err
+	}
+
+	img, err := mutate.Append(base, mutate.Addendum{
+		Layer: static.NewLayer(data, SimpleSigningMediaType),
+		Annotations: map[string]string{
+			SignatureAnnotation: base64.StdEncoding.EncodeToString(signature),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if err := remote.Write(tag, img, opts...); err != nil {
+		return fmt.Errorf("failed to write signature to %s: %v", tag, err)
+	}
+	return nil
+}
+
+// Verify checks the signatures attached to digest against the trusted keys
+// and returns the name of the first key with a valid signature.
+func Verify(ctx context.Context, digest name.Digest, keys map[string]crypto.PublicKey, opts ...remote.Option) (string, error) {
+	opts = append(append([]remote.Option{}, opts...), remote.WithContext(ctx))
+	tag, err := SignatureTag(digest)
+	if err != nil {
+		return "", err
+	}
+	img, err := signatureImage(tag, opts...)
+	if err != nil {
+		return "", err
+	}
+	return verifyImage(img, digest, keys)
+}
+
+// signatureImage fetches the existing signature artifact, or an empty one if
+// the image has not been signed yet.
+func signatureImage(tag name.Tag, opts ...remote.Option) (v1.Image, error) {
+	img, err := remote.Image(tag, opts...)
+	var terr *transport.Error
+	if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+		return mutate.ConfigMediaType(mutate.MediaType(empty.Image, types.OCIManifestSchema1), types.OCIConfigJSON), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signatures from %s: %v", tag, err)
+	}
+	return img, nil
+}
+
+func verifyImage(img v1.Image, digest name.Digest, keys map[string]crypto.PublicKey) (string, error) {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return "", err
+	}
+
+	// Check keys in a stable order so the reported key does not vary.
+	names := make([]string, 0, len(keys))
+	for keyName := range keys {
+		names = append(names, keyName)
+	}
+	sort.Strings(names)
+
+	for _, desc := range manifest.Layers {
+		if desc.MediaType != SimpleSigningMediaType {
+			continue
+		}
+		signature, err := base64.StdEncoding.DecodeString(desc.Annotations[SignatureAnnotation])
+		if err != nil || len(signature) == 0 {
+			continue
+		}
+		data, err := layerBlob(img, desc.Digest)
+		if err != nil {
+			return "", err
+		}
+		if !coversDigest(data, digest) {
+			continue
+		}
+		for _, keyName := range names {
+			if verifySignature(keys[keyName], data, signature) {
+				return keyName, nil
+			}
+		}
+	}
+	return "", ErrNoSignature
+}
+
+func layerBlob(img v1.Image, digest v1.Hash) ([]byte, error) {
+	layer, err := img.LayerByDigest(digest)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func coversDigest(data []byte, digest name.Digest) bool {
+	var body payload
+	if err := json.Unmarshal(data, &body); err != nil {
+		return false
+	}
+	return strings.EqualFold(body.Critical.Type, signatureType) &&
+		body.Critical.Image.DockerManifestDigest == digest.DigestStr()
+}
+
+func verifySignature(key crypto.PublicKey, data, signature []byte) bool {
+	sum := sha256.Sum256(data)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(k, sum[:], signature)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], signature) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, data, signature)
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/digizyne/lfcont/internal/sealing"
+)
+
+// GenerateKey creates an ECDSA P-256 key, the cosign default, and returns it
+// together with its PEM encoded public key.
+func GenerateKey() (*ecdsa.PrivateKey, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, "", err
+	}
+	publicKey, err := EncodePublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return key, publicKey, nil
+}
+
+// EncodePublicKey returns the PKIX "PUBLIC KEY" PEM block that
+// 'cosign verify --key' accepts.
+func EncodePublicKey(key crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// ParsePublicKey parses a PEM encoded ECDSA, RSA or Ed25519 public key.
+func ParsePublicKey(data string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("expected a PEM encoded PUBLIC KEY block")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+}
+
+// keyEncryptionLabel binds keys derived from SIGNING_KEY_SECRET to the
+// encryption of signing keys, so the secret can be shared with other uses.
+const keyEncryptionLabel = "lfcont signing key encryption v1"
+
+// SealPrivateKey encrypts a private key for storage under a key derived from
+// secret.
+func SealPrivateKey(key *ecdsa.PrivateKey, secret string) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+	return sealing.Seal(aead, der)
+}
+
+// OpenPrivateKey reverses SealPrivateKey.
+func OpenPrivateKey(sealed []byte, secret string) (*ecdsa.PrivateKey, error) {
+	aead, err := newAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+	der, err := sealing.Open(aead, sealed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt signing key: %v", err)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return ecKey, nil
+}
+
+func newAEAD(secret string) (cipher.AEAD, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("no key encryption secret configured")
+	}
+	return sealing.Derive(secret, keyEncryptionLabel)
+}
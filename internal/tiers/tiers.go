@@ -0,0 +1,179 @@
+package tiers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tier is the resources and scaling a deployment gets on Cloud Run.
+type Tier struct {
+	// CPU and Memory are Cloud Run limits such as "1" and "512Mi".
+	CPU    string `json:"cpu"`
+	Memory string `json:"memory"`
+
+	MinInstances int `json:"min_instances"`
+	MaxInstances int `json:"max_instances"`
+	// Concurrency is the maximum number of requests sent to one instance.
+	Concurrency int `json:"concurrency"`
+	// Timeout is the request timeout, e.g. "300s".
+	Timeout string `json:"timeout"`
+	// CPUAlwaysAllocated keeps the CPU allocated outside of requests.
+	CPUAlwaysAllocated bool `json:"cpu_always_allocated"`
+	// Plans are the user plans (users.tier) that may deploy on the tier.
+	// An empty list allows every plan.
+	Plans []string `json:"plans,omitempty"`
+}
+
+// Catalog is the set of tiers deployments can choose from. Deployments that
+// do not name a tier get Default.
+type Catalog struct {
+	Default string          `json:"default"`
+	Tiers   map[string]Tier `json:"tiers"`
+}
+
+// DefaultCatalog is used when DEPLOY_TIERS_FILE is not set. The free tier
+// matches what every deployment got before tiers were applied.
+func DefaultCatalog() Catalog {
+	return Catalog{
+		Default: "free",
+		Tiers: map[string]Tier{
+			"free": {
+				CPU: "1", Memory: "512Mi",
+				MinInstances: 0, MaxInstances: 1,
+				Concurrency: 80, Timeout: "300s",
+			},
+			"pro": {
+				CPU: "2", Memory: "1Gi",
+				MinInstances: 0, MaxInstances: 10,
+				Concurrency: 80, Timeout: "900s",
+				Plans: []string{"pro", "dedicated"},
+			},
+			"dedicated": {
+				CPU: "4", Memory: "4Gi",
+				MinInstances: 1, MaxInstances: 20,
+				Concurrency: 250, Timeout: "3600s",
+				CPUAlwaysAllocated: true,
+				Plans:              []string{"dedicated"},
+			},
+		},
+	}
+}
+
+// Load reads the catalog from the JSON file named by DEPLOY_TIERS_FILE, or
+// returns DefaultCatalog when it is not set.
+func Load() (Catalog, error) {
+	path := os.Getenv("DEPLOY_TIERS_FILE")
+	if path == "" {
+		return DefaultCatalog(), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Catalog{}, fmt.Errorf("failed to read DEPLOY_TIERS_FILE: %v", err)
+	}
+	var catalog Catalog
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return Catalog{}, fmt.Errorf("invalid DEPLOY_TIERS_FILE: %v", err)
+	}
+	if err := catalog.Validate(); err != nil {
+		return Catalog{}, fmt.Errorf("invalid DEPLOY_TIERS_FILE: %v", err)
+	}
+	return catalog, nil
+}
+
+// Validate checks every tier and that the default tier exists and is open
+// to every plan.
+func (c Catalog) Validate() error {
+	if len(c.Tiers) == 0 {
+		return fmt.Errorf("no tiers defined")
+	}
+	defaultTier, ok := c.Tiers[c.Default]
+	if !ok {
+		return fmt.Errorf("default tier %q is not defined", c.Default)
+	}
+	if len(defaultTier.Plans) > 0 {
+		return fmt.Errorf("default tier %q must not be restricted to plans", c.Default)
+	}
+	for name, tier := range c.Tiers {
+		if err := tier.Validate(); err != nil {
+			return fmt.Errorf("tier %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks the tier against the limits Cloud Run accepts.
+func (t Tier) Validate() error {
+	cpu, err := strconv.ParseFloat(strings.TrimSuffix(t.CPU, "m"), 64)
+	if err != nil || cpu <= 0 {
+		return fmt.Errorf("cpu: invalid value %q", t.CPU)
+	}
+	if strings.HasSuffix(t.CPU, "m") {
+		cpu /= 1000
+	}
+	if cpu < 1 && t.Concurrency > 1 {
+		return fmt.Errorf("cpu: less than 1 cpu requires a concurrency of 1")
+	}
+	if t.Memory == "" {
+		return fmt.Errorf("memory is required")
+	}
+	if t.MinInstances < 0 {
+		return fmt.Errorf("min_instances must not be negative")
+	}
+	if t.MaxInstances < 1 || t.MaxInstances < t.MinInstances {
+		return fmt.Errorf("max_instances must be at least 1 and at least min_instances")
+	}
+	if t.Concurrency < 1 || t.Concurrency > 1000 {
+		return fmt.Errorf("concurrency must be between 1 and 1000")
+	}
+	timeout, err := time.ParseDuration(t.Timeout)
+	if err != nil {
+		return fmt.Errorf("timeout: %v", err)
+	}
+	if timeout < time.Second || timeout > time.Hour {
+		return fmt.Errorf("timeout must be between 1s and 1h")
+	}
+	return nil
+}
+
+// Resolve returns the name and settings of tier, or of the default tier
+// when tier is empty.
+func (c Catalog) Resolve(tier string) (string, Tier, error) {
+	if tier == "" {
+		tier = c.Default
+	}
+	settings, ok := c.Tiers[tier]
+	if !ok {
+		return "", Tier{}, fmt.Errorf("unknown tier %q, expected one of %s", tier, strings.Join(c.Names(), ", "))
+	}
+	return tier, settings, nil
+}
+
+// Names lists the tiers in the catalog in alphabetical order.
+func (c Catalog) Names() []string {
+	names := make([]string, 0, len(c.Tiers))
+	for name := range c.Tiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Allows reports whether users on plan may deploy on the tier.
+func (t Tier) Allows(plan string) bool {
+	return len(t.Plans) == 0 || slices.Contains(t.Plans, plan)
+}
+
+// RequestTimeout is Timeout in the seconds format Cloud Run expects.
+func (t Tier) RequestTimeout() string {
+	timeout, err := time.ParseDuration(t.Timeout)
+	if err != nil {
+		return t.Timeout
+	}
+	return fmt.Sprintf("%ds", int64(timeout.Seconds()))
+}
@@ -0,0 +1,147 @@
+package tiers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTierValidate(t *testing.T) {
+	valid := Tier{CPU: "1", Memory: "512Mi", MaxInstances: 1, Concurrency: 80, Timeout: "300s"}
+	tests := []struct {
+		name    string
+		change  func(tier *Tier)
+		wantErr string
+	}{
+		{name: "valid", change: func(tier *Tier) {}},
+		{name: "millicpu with concurrency 1", change: func(tier *Tier) { tier.CPU, tier.Concurrency = "500m", 1 }},
+		{name: "millicpu above one cpu", change: func(tier *Tier) { tier.CPU = "2000m" }},
+		{name: "invalid cpu", change: func(tier *Tier) { tier.CPU = "two" }, wantErr: "cpu"},
+		{name: "zero cpu", change: func(tier *Tier) { tier.CPU = "0" }, wantErr: "cpu"},
+		{name: "fractional cpu with concurrency", change: func(tier *Tier) { tier.CPU = "0.5" }, wantErr: "concurrency of 1"},
+		{name: "missing memory", change: func(tier *Tier) { tier.Memory = "" }, wantErr: "memory"},
+		{name: "negative min instances", change: func(tier *Tier) { tier.MinInstances = -1 }, wantErr: "min_instances"},
+		{name: "max below min", change: func(tier *Tier) { tier.MinInstances, tier.MaxInstances = 3, 2 }, wantErr: "max_instances"},
+		{name: "no instances", change: func(tier *Tier) { tier.MaxInstances = 0 }, wantErr: "max_instances"},
+		{name: "concurrency too high", change: func(tier *Tier) { tier.Concurrency = 1001 }, wantErr: "concurrency"},
+		{name: "invalid timeout", change: func(tier *Tier) { tier.Timeout = "5 minutes" }, wantErr: "timeout"},
+		{name: "timeout too long", change: func(tier *Tier) { tier.Timeout = "2h" }, wantErr: "timeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tier := valid
+			tt.change(&tier)
+			err := tier.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want an error about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCatalogValidate(t *testing.T) {
+	if err := DefaultCatalog().Validate(); err != nil {
+		t.Fatalf("default catalog is invalid: %v", err)
+	}
+
+	free := DefaultCatalog().Tiers["free"]
+	restricted := free
+	restricted.Plans = []string{"pro"}
+	tests := []struct {
+		name    string
+		catalog Catalog
+		wantErr string
+	}{
+		{name: "no tiers", catalog: Catalog{Default: "free"}, wantErr: "no tiers"},
+		{name: "missing default", catalog: Catalog{Default: "basic", Tiers: map[string]Tier{"free": free}}, wantErr: "not defined"},
+		{name: "restricted default", catalog: Catalog{Default: "free", Tiers: map[string]Tier{"free": restricted}}, wantErr: "restricted to plans"},
+		{name: "invalid tier", catalog: Catalog{Default: "free", Tiers: map[string]Tier{"free": free, "big": {CPU: "8"}}}, wantErr: "tier big"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.catalog.Validate(); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want an error about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	catalog := DefaultCatalog()
+	for _, tt := range []struct {
+		tier, want string
+		wantErr    bool
+	}{
+		{tier: "", want: "free"},
+		{tier: "pro", want: "pro"},
+		{tier: "enterprise", wantErr: true},
+	} {
+		name, _, err := catalog.Resolve(tt.tier)
+		if (err != nil) != tt.wantErr || name != tt.want {
+			t.Errorf("Resolve(%q) = %q, %v; want %q, error %v", tt.tier, name, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestAllows(t *testing.T) {
+	catalog := DefaultCatalog()
+	for _, tt := range []struct {
+		tier, plan string
+		want       bool
+	}{
+		{"free", "free", true},
+		{"free", "", true},
+		{"pro", "free", false},
+		{"pro", "pro", true},
+		{"pro", "dedicated", true},
+		{"dedicated", "pro", false},
+		{"dedicated", "dedicated", true},
+	} {
+		if got := catalog.Tiers[tt.tier].Allows(tt.plan); got != tt.want {
+			t.Errorf("%s.Allows(%q) = %v, want %v", tt.tier, tt.plan, got, tt.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	t.Setenv("DEPLOY_TIERS_FILE", "")
+	if catalog, err := Load(); err != nil || catalog.Default != "free" {
+		t.Errorf("Load() without a file = %+v, %v; want the default catalog", catalog, err)
+	}
+
+	t.Setenv("DEPLOY_TIERS_FILE", write("tiers.json", `{"default":"small","tiers":{"small":{"cpu":"1","memory":"256Mi","max_instances":2,"concurrency":10,"timeout":"60s"}}}`))
+	catalog, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if catalog.Default != "small" || catalog.Tiers["small"].Memory != "256Mi" {
+		t.Errorf("Load() = %+v", catalog)
+	}
+
+	t.Setenv("DEPLOY_TIERS_FILE", write("invalid.json", `{"default":"small","tiers":{}}`))
+	if _, err := Load(); err == nil {
+		t.Error("Load() accepted a catalog without tiers")
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	if got := (Tier{Timeout: "15m"}).RequestTimeout(); got != "900s" {
+		t.Errorf("RequestTimeout() = %q, want 900s", got)
+	}
+}
@@ -0,0 +1,90 @@
+package vuln
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+var cvss3Weights = map[string]map[string]float64{
+	"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+	"AC": {"L": 0.77, "H": 0.44},
+	"UI": {"N": 0.85, "R": 0.62},
+	"C":  {"H": 0.56, "L": 0.22, "N": 0},
+	"I":  {"H": 0.56, "L": 0.22, "N": 0},
+	"A":  {"H": 0.56, "L": 0.22, "N": 0},
+}
+
+// cvss3BaseScore computes the base score of a CVSS v3.0/v3.1 vector such as
+// "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H".
+func cvss3BaseScore(vector string) (float64, error) {
+	metrics := map[string]string{}
+	for _, part := range strings.Split(vector, "/") {
+		if key, value, ok := strings.Cut(part, ":"); ok {
+			metrics[key] = value
+		}
+	}
+	if !strings.HasPrefix(metrics["CVSS"], "3") {
+		return 0, fmt.Errorf("not a CVSS v3 vector: %s", vector)
+	}
+
+	changed := metrics["S"] == "C"
+	weight := func(metric string) (float64, error) {
+		if metric == "PR" {
+			switch metrics["PR"] {
+			case "N":
+				return 0.85, nil
+			case "L":
+				if changed {
+					return 0.68, nil
+				}
+				return 0.62, nil
+			case "H":
+				if changed {
+					return 0.5, nil
+				}
+				return 0.27, nil
+			}
+			return 0, fmt.Errorf("invalid PR in %s", vector)
+		}
+		w, ok := cvss3Weights[metric][metrics[metric]]
+		if !ok {
+			return 0, fmt.Errorf("invalid %s in %s", metric, vector)
+		}
+		return w, nil
+	}
+
+	values := map[string]float64{}
+	for _, metric := range []string{"AV", "AC", "PR", "UI", "C", "I", "A"} {
+		w, err := weight(metric)
+		if err != nil {
+			return 0, err
+		}
+		values[metric] = w
+	}
+
+	iss := 1 - (1-values["C"])*(1-values["I"])*(1-values["A"])
+	var impact float64
+	if changed {
+		impact = 7.52*(iss-0.029) - 3.25*math.Pow(iss-0.02, 15)
+	} else {
+		impact = 6.42 * iss
+	}
+	if impact <= 0 {
+		return 0, nil
+	}
+	exploitability := 8.22 * values["AV"] * values["AC"] * values["PR"] * values["UI"]
+	if changed {
+		return roundUp(math.Min(1.08*(impact+exploitability), 10)), nil
+	}
+	return roundUp(math.Min(impact+exploitability, 10)), nil
+}
+
+// roundUp is the CVSS v3.1 "Roundup" function.
+func roundUp(x float64) float64 {
+	i := int(math.Round(x * 100000))
+	if i%10000 == 0 {
+		return float64(i) / 100000
+	}
+	return (math.Floor(float64(i)/10000) + 1) / 10
+}
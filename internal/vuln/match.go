@@ -0,0 +1,333 @@
+package vuln
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/digizyne/lfcont/internal/sbom"
+)
+
+// Finding is a package in an image that falls inside an advisory's affected
+// versions.
+type Finding struct {
+	AdvisoryID   string   `json:"advisory_id"`
+	Summary      string   `json:"summary"`
+	Package      string   `json:"package"`
+	Version      string   `json:"version"`
+	Type         string   `json:"type"`
+	Path         string   `json:"path"`
+	FixedVersion string   `json:"fixed_version,omitempty"`
+	Severity     Severity `json:"severity"`
+}
+
+// ecosystem maps a package to the OSV ecosystem its advisories are published
+// under and the version ordering of that ecosystem. Packages from
+// distributions we have no ordering for are not matched.
+func ecosystem(p sbom.Package) (string, compareFunc, bool) {
+	if p.Type == sbom.TypeGolang {
+		return "Go", compareSemver, true
+	}
+
+	id, version, _ := strings.Cut(p.Distro, "-")
+	major, _, _ := strings.Cut(version, ".")
+	switch {
+	case p.Type == sbom.TypeDeb && id == "debian":
+		return "Debian:" + major, compareDpkg, true
+	case p.Type == sbom.TypeDeb && id == "ubuntu":
+		return "Ubuntu:" + version, compareDpkg, true
+	case p.Type == sbom.TypeApk && id == "alpine":
+		parts := strings.SplitN(version, ".", 3)
+		if len(parts) < 2 {
+			return "", nil, false
+		}
+		return "Alpine:v" + parts[0] + "." + parts[1], compareApk, true
+	case p.Type == sbom.TypeRpm && id == "almalinux":
+		return "AlmaLinux:" + major, compareRpm, true
+	case p.Type == sbom.TypeRpm && id == "rocky":
+		return "Rocky Linux:" + major, compareRpm, true
+	}
+	return "", nil, false
+}
+
+// advisoryName is the package name advisories use: the source package for OS
+// packages, the module path for Go.
+func advisoryName(p sbom.Package) string {
+	if p.Source != "" {
+		return p.Source
+	}
+	return p.Name
+}
+
+type candidate struct {
+	advisoryID string
+	summary    string
+	ecosystem  string
+	ranges     []Range
+	versions   []string
+	severity   Severity
+}
+
+// Match checks the packages against the imported advisories.
+func Match(ctx context.Context, pool *pgxpool.Pool, pkgs []sbom.Package) ([]Finding, error) {
+	names := map[string]struct{}{}
+	for _, p := range pkgs {
+		names[advisoryName(p)] = struct{}{}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	nameList := make([]string, 0, len(names))
+	for name := range names {
+		nameList = append(nameList, name)
+	}
+
+	rows, err := pool.Query(ctx, `
+		SELECT a.id, a.summary, aa.ecosystem, aa.name, aa.ranges, aa.versions, aa.severity
+		FROM advisory_affected aa
+		JOIN advisories a ON a.id = aa.advisory_id
+		WHERE aa.name = ANY($1)
+	`, nameList)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query advisories: %v", err)
+	}
+	defer rows.Close()
+
+	candidates := map[string][]candidate{}
+	for rows.Next() {
+		var c candidate
+		var name, severity string
+		var ranges, versions []byte
+		if err := rows.Scan(&c.advisoryID, &c.summary, &c.ecosystem, &name, &ranges, &versions, &severity); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(ranges, &c.ranges); err != nil {
+			return nil, fmt.Errorf("advisory %s has invalid ranges: %v", c.advisoryID, err)
+		}
+		if err := json.Unmarshal(versions, &c.versions); err != nil {
+			return nil, fmt.Errorf("advisory %s has invalid versions: %v", c.advisoryID, err)
+		}
+		c.severity = Severity(severity)
+		candidates[name] = append(candidates[name], c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	findings := []Finding{}
+	seen := map[string]bool{}
+	for _, p := range pkgs {
+		eco, compare, ok := ecosystem(p)
+		if !ok || p.Version == "" || p.Version == "(devel)" {
+			continue
+		}
+		for _, c := range candidates[advisoryName(p)] {
+			if c.ecosystem != eco && !strings.HasPrefix(c.ecosystem, eco+":") {
+				continue
+			}
+			affected, fixed := isAffected(p.Version, c, compare)
+			if !affected {
+				continue
+			}
+			key := c.advisoryID + "|" + p.Name + "|" + p.Path
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			findings = append(findings, Finding{
+				AdvisoryID:   c.advisoryID,
+				Summary:      c.summary,
+				Package:      p.Name,
+				Version:      p.Version,
+				Type:         p.Type,
+				Path:         p.Path,
+				FixedVersion: fixed,
+				Severity:     c.severity,
+			})
+		}
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].Severity.Rank() != findings[j].Severity.Rank() {
+			return findings[i].Severity.Rank() > findings[j].Severity.Rank()
+		}
+		return findings[i].AdvisoryID < findings[j].AdvisoryID
+	})
+	return findings, nil
+}
+
+// isAffected evaluates the OSV affected versions and ranges for version and
+// returns the fixing version when one is known.
+func isAffected(version string, c candidate, compare compareFunc) (bool, string) {
+	for _, v := range c.versions {
+		if compare(version, v) == 0 {
+			return true, ""
+		}
+	}
+
+	for _, r := range c.ranges {
+		if r.Type != "SEMVER" && r.Type != "ECOSYSTEM" {
+			continue
+		}
+		events := append([]Event(nil), r.Events...)
+		sort.SliceStable(events, func(i, j int) bool {
+			return compareEvent(events[i], events[j], compare) < 0
+		})
+
+		affected := false
+		fixed := ""
+		for _, e := range events {
+			switch {
+			case e.Introduced != "":
+				if e.Introduced == "0" || compare(version, e.Introduced) >= 0 {
+					affected = true
+				}
+			case e.Fixed != "":
+				if compare(version, e.Fixed) >= 0 {
+					affected = false
+				} else if affected && fixed == "" {
+					fixed = e.Fixed
+				}
+			case e.LastAffected != "":
+				if compare(version, e.LastAffected) > 0 {
+					affected = false
+				}
+			}
+		}
+		if affected {
+			return true, fixed
+		}
+	}
+	return false, ""
+}
+
+func eventVersion(e Event) string {
+	switch {
+	case e.Introduced != "":
+		return e.Introduced
+	case e.Fixed != "":
+		return e.Fixed
+	case e.LastAffected != "":
+		return e.LastAffected
+	default:
+		return e.Limit
+	}
+}
+
+func compareEvent(a, b Event, compare compareFunc) int {
+	av, bv := eventVersion(a), eventVersion(b)
+	switch {
+	case av == "0" && bv == "0":
+		return 0
+	case av == "0":
+		return -1
+	case bv == "0":
+		return 1
+	}
+	return compare(av, bv)
+}
+
+// Store replaces the findings recorded for an image digest.
+func Store(ctx context.Context, pool *pgxpool.Pool, digest string, findings []Finding) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM vulnerability_findings WHERE digest = $1`, digest); err != nil {
+		return err
+	}
+	for _, f := range findings {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO vulnerability_findings (digest, advisory_id, package, version, type, path, fixed_version, severity)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			ON CONFLICT DO NOTHING
+		`, digest, f.AdvisoryID, f.Package, f.Version, f.Type, f.Path, f.FixedVersion, string(f.Severity))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// Scan matches the packages of one image and stores the findings.
+func Scan(ctx context.Context, pool *pgxpool.Pool, digest string, pkgs []sbom.Package) ([]Finding, error) {
+	findings, err := Match(ctx, pool, pkgs)
+	if err != nil {
+		return nil, err
+	}
+	if err := Store(ctx, pool, digest, findings); err != nil {
+		return nil, fmt.Errorf("failed to store findings: %v", err)
+	}
+	return findings, nil
+}
+
+// RescanAll re-matches every stored SBOM, typically after new advisories were
+// imported.
+func RescanAll(ctx context.Context, pool *pgxpool.Pool) error {
+	rows, err := pool.Query(ctx, `SELECT digest, document FROM sboms`)
+	if err != nil {
+		return err
+	}
+	type stored struct {
+		digest   string
+		document sbom.Document
+	}
+	var all []stored
+	for rows.Next() {
+		var s stored
+		var document []byte
+		if err := rows.Scan(&s.digest, &document); err != nil {
+			rows.Close()
+			return err
+		}
+		if err := json.Unmarshal(document, &s.document); err != nil {
+			log.Printf("Warning: skipping unreadable SBOM for %s: %v", s.digest, err)
+			continue
+		}
+		all = append(all, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, s := range all {
+		if _, err := Scan(ctx, pool, s.digest, s.document.Packages()); err != nil {
+			return fmt.Errorf("failed to rescan %s: %v", s.digest, err)
+		}
+	}
+	return nil
+}
+
+// CountsByDigest returns the findings per severity for each digest.
+func CountsByDigest(ctx context.Context, pool *pgxpool.Pool, digests []string) (map[string]Counts, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT digest, severity, COUNT(*)
+		FROM vulnerability_findings
+		WHERE digest = ANY($1)
+		GROUP BY digest, severity
+	`, digests)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]Counts{}
+	for rows.Next() {
+		var digest, severity string
+		var n int
+		if err := rows.Scan(&digest, &severity, &n); err != nil {
+			return nil, err
+		}
+		c := counts[digest]
+		c.Add(Severity(severity), n)
+		counts[digest] = c
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,238 @@
+package vuln
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Advisory is the subset of the OSV schema (https://ossf.github.io/osv-schema/)
+// needed for matching.
+type Advisory struct {
+	ID               string          `json:"id"`
+	Modified         time.Time       `json:"modified"`
+	Withdrawn        *time.Time      `json:"withdrawn"`
+	Summary          string          `json:"summary"`
+	Aliases          []string        `json:"aliases"`
+	Severity         []SeverityScore `json:"severity"`
+	Affected         []Affected      `json:"affected"`
+	DatabaseSpecific map[string]any  `json:"database_specific"`
+}
+
+type SeverityScore struct {
+	Type  string `json:"type"`
+	Score string `json:"score"`
+}
+
+type Affected struct {
+	Package struct {
+		Ecosystem string `json:"ecosystem"`
+		Name      string `json:"name"`
+	} `json:"package"`
+	Severity          []SeverityScore `json:"severity"`
+	Ranges            []Range         `json:"ranges"`
+	Versions          []string        `json:"versions"`
+	EcosystemSpecific map[string]any  `json:"ecosystem_specific"`
+	DatabaseSpecific  map[string]any  `json:"database_specific"`
+}
+
+type Range struct {
+	Type   string  `json:"type"`
+	Events []Event `json:"events"`
+}
+
+type Event struct {
+	Introduced   string `json:"introduced,omitempty"`
+	Fixed        string `json:"fixed,omitempty"`
+	LastAffected string `json:"last_affected,omitempty"`
+	Limit        string `json:"limit,omitempty"`
+}
+
+// severity picks the most specific severity available for an affected entry:
+// the entry's own rating, then the advisory's database label, then a CVSS v3
+// score computed from the vector.
+func (a Advisory) severity(affected Affected) Severity {
+	for _, specific := range []map[string]any{affected.EcosystemSpecific, affected.DatabaseSpecific, a.DatabaseSpecific} {
+		if label, ok := specific["severity"].(string); ok {
+			if s, err := ParseSeverity(label); err == nil && s != SeverityUnknown {
+				return s
+			}
+		}
+	}
+	for _, scores := range [][]SeverityScore{affected.Severity, a.Severity} {
+		for _, score := range scores {
+			switch score.Type {
+			case "CVSS_V3":
+				if base, err := cvss3BaseScore(score.Score); err == nil {
+					return severityFromScore(base)
+				}
+			case "Ubuntu":
+				if s, err := ParseSeverity(score.Score); err == nil {
+					return s
+				}
+			}
+		}
+	}
+	return SeverityUnknown
+}
+
+// importBatchSize is how many advisories are written per transaction.
+const importBatchSize = 500
+
+// Import loads an OSV dump into Postgres. path is either a directory of OSV
+// JSON files (searched recursively) or a zip archive such as the per-ecosystem
+// all.zip published by osv.dev. It returns the number of advisories imported.
+func Import(ctx context.Context, pool *pgxpool.Pool, path string) (int, error) {
+	var pending []Advisory
+	imported := 0
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		if err := storeAdvisories(ctx, pool, pending); err != nil {
+			return err
+		}
+		imported += len(pending)
+		pending = pending[:0]
+		return nil
+	}
+
+	err := readDump(path, func(name string, r io.Reader) error {
+		var advisory Advisory
+		if err := json.NewDecoder(r).Decode(&advisory); err != nil {
+			log.Printf("Warning: skipping advisory %s: %v", name, err)
+			return nil
+		}
+		if advisory.ID == "" {
+			return nil
+		}
+		pending = append(pending, advisory)
+		if len(pending) >= importBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return imported, err
+	}
+	if err := flush(); err != nil {
+		return imported, err
+	}
+	return imported, nil
+}
+
+func readDump(path string, fn func(name string, r io.Reader) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to open advisory dump: %v", err)
+	}
+
+	if !info.IsDir() {
+		archive, err := zip.OpenReader(path)
+		if err != nil {
+			return fmt.Errorf("advisory dump must be a directory or zip archive: %v", err)
+		}
+		defer archive.Close()
+		for _, f := range archive.File {
+			if !strings.HasSuffix(f.Name, ".json") {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = fn(f.Name, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return filepath.WalkDir(path, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(name, ".json") {
+			return nil
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return fn(name, f)
+	})
+}
+
+func storeAdvisories(ctx context.Context, pool *pgxpool.Pool, advisories []Advisory) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	batch := &pgx.Batch{}
+	for _, advisory := range advisories {
+		if advisory.Withdrawn != nil {
+			batch.Queue(`DELETE FROM advisories WHERE id = $1`, advisory.ID)
+			continue
+		}
+
+		overall := SeverityUnknown
+		for _, affected := range advisory.Affected {
+			if s := advisory.severity(affected); s.Rank() > overall.Rank() {
+				overall = s
+			}
+		}
+		aliases := advisory.Aliases
+		if aliases == nil {
+			aliases = []string{}
+		}
+		batch.Queue(`
+			INSERT INTO advisories (id, summary, aliases, severity, modified)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (id) DO UPDATE SET
+				summary = EXCLUDED.summary,
+				aliases = EXCLUDED.aliases,
+				severity = EXCLUDED.severity,
+				modified = EXCLUDED.modified
+		`, advisory.ID, advisory.Summary, aliases, string(overall), advisory.Modified)
+		batch.Queue(`DELETE FROM advisory_affected WHERE advisory_id = $1`, advisory.ID)
+
+		for _, affected := range advisory.Affected {
+			if affected.Package.Name == "" {
+				continue
+			}
+			ranges, err := json.Marshal(affected.Ranges)
+			if err != nil {
+				return err
+			}
+			versions, err := json.Marshal(affected.Versions)
+			if err != nil {
+				return err
+			}
+			batch.Queue(`
+				INSERT INTO advisory_affected (advisory_id, ecosystem, name, ranges, versions, severity)
+				VALUES ($1, $2, $3, $4, $5, $6)
+			`, advisory.ID, affected.Package.Ecosystem, affected.Package.Name, ranges, versions, string(advisory.severity(affected)))
+		}
+	}
+
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("failed to store advisories: %v", err)
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,110 @@
+package vuln
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Severity string
+
+const (
+	SeverityUnknown  Severity = "unknown"
+	SeverityLow      Severity = "low"
+	SeverityMedium   Severity = "medium"
+	SeverityHigh     Severity = "high"
+	SeverityCritical Severity = "critical"
+)
+
+// Rank orders severities from unknown (0) to critical (4).
+func (s Severity) Rank() int {
+	switch s {
+	case SeverityLow:
+		return 1
+	case SeverityMedium:
+		return 2
+	case SeverityHigh:
+		return 3
+	case SeverityCritical:
+		return 4
+	default:
+		return 0
+	}
+}
+
+// ParseSeverity accepts our own severity names as well as the labels used by
+// advisory sources (GHSA "moderate", Ubuntu "negligible", ...).
+func ParseSeverity(s string) (Severity, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "critical":
+		return SeverityCritical, nil
+	case "high", "important":
+		return SeverityHigh, nil
+	case "medium", "moderate":
+		return SeverityMedium, nil
+	case "low", "negligible":
+		return SeverityLow, nil
+	case "unknown", "":
+		return SeverityUnknown, nil
+	default:
+		return SeverityUnknown, fmt.Errorf("unknown severity %q", s)
+	}
+}
+
+func severityFromScore(score float64) Severity {
+	switch {
+	case score >= 9:
+		return SeverityCritical
+	case score >= 7:
+		return SeverityHigh
+	case score >= 4:
+		return SeverityMedium
+	case score > 0:
+		return SeverityLow
+	default:
+		return SeverityUnknown
+	}
+}
+
+// Counts is the number of findings per severity for one image.
+type Counts struct {
+	Critical int `json:"critical"`
+	High     int `json:"high"`
+	Medium   int `json:"medium"`
+	Low      int `json:"low"`
+	Unknown  int `json:"unknown"`
+}
+
+func (c *Counts) Add(severity Severity, n int) {
+	switch severity {
+	case SeverityCritical:
+		c.Critical += n
+	case SeverityHigh:
+		c.High += n
+	case SeverityMedium:
+		c.Medium += n
+	case SeverityLow:
+		c.Low += n
+	default:
+		c.Unknown += n
+	}
+}
+
+// AtLeast returns how many findings are at or above threshold.
+func (c Counts) AtLeast(threshold Severity) int {
+	total := 0
+	for _, s := range []struct {
+		severity Severity
+		count    int
+	}{
+		{SeverityCritical, c.Critical},
+		{SeverityHigh, c.High},
+		{SeverityMedium, c.Medium},
+		{SeverityLow, c.Low},
+		{SeverityUnknown, c.Unknown},
+	} {
+		if s.severity.Rank() >= threshold.Rank() {
+			total += s.count
+		}
+	}
+	return total
+}
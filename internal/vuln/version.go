@@ -0,0 +1,286 @@
+package vuln
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/mod/semver"
+)
+
+// compareFunc orders two versions of the same ecosystem, returning -1, 0 or 1.
+type compareFunc func(a, b string) int
+
+// compareSemver compares Go module versions. OSV omits the "v" prefix and the
+// standard library reports "go1.x.y", so both are normalised first.
+func compareSemver(a, b string) int {
+	return semver.Compare(canonicalSemver(a), canonicalSemver(b))
+}
+
+func canonicalSemver(v string) string {
+	v = strings.TrimPrefix(v, "go")
+	if !strings.HasPrefix(v, "v") {
+		v = "v" + v
+	}
+	return v
+}
+
+// compareDpkg implements the Debian version ordering (epoch:upstream-revision).
+func compareDpkg(a, b string) int {
+	aEpoch, aUpstream, aRevision := splitDpkg(a)
+	bEpoch, bUpstream, bRevision := splitDpkg(b)
+	if aEpoch != bEpoch {
+		return sign(aEpoch - bEpoch)
+	}
+	if c := compareDpkgPart(aUpstream, bUpstream); c != 0 {
+		return c
+	}
+	return compareDpkgPart(aRevision, bRevision)
+}
+
+func splitDpkg(v string) (int, string, string) {
+	epoch := 0
+	if e, rest, ok := strings.Cut(v, ":"); ok {
+		epoch, _ = strconv.Atoi(e)
+		v = rest
+	}
+	revision := ""
+	if idx := strings.LastIndex(v, "-"); idx >= 0 {
+		v, revision = v[:idx], v[idx+1:]
+	}
+	return epoch, v, revision
+}
+
+// dpkgOrder ranks a non-digit character: '~' sorts before everything, even
+// the end of the string, and letters sort before other symbols.
+func dpkgOrder(c byte) int {
+	switch {
+	case c == '~':
+		return -1
+	case c >= '0' && c <= '9':
+		return 0
+	case unicode.IsLetter(rune(c)):
+		return int(c)
+	default:
+		return int(c) + 256
+	}
+}
+
+func compareDpkgPart(a, b string) int {
+	for a != "" || b != "" {
+		// Compare the leading non-digit run character by character.
+		for (a != "" && !isDigit(a[0])) || (b != "" && !isDigit(b[0])) {
+			ac, bc := 0, 0
+			if a != "" {
+				ac = dpkgOrder(a[0])
+			}
+			if b != "" {
+				bc = dpkgOrder(b[0])
+			}
+			if ac != bc {
+				return sign(ac - bc)
+			}
+			a, b = a[1:], b[1:]
+		}
+
+		var an, bn string
+		an, a = takeDigits(a)
+		bn, b = takeDigits(b)
+		if c := compareNumeric(an, bn); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareRpm implements rpmvercmp on [epoch:]version[-release].
+func compareRpm(a, b string) int {
+	aEpoch, aVersion, aRelease := splitDpkg(a)
+	bEpoch, bVersion, bRelease := splitDpkg(b)
+	if aEpoch != bEpoch {
+		return sign(aEpoch - bEpoch)
+	}
+	if c := rpmvercmp(aVersion, bVersion); c != 0 {
+		return c
+	}
+	return rpmvercmp(aRelease, bRelease)
+}
+
+func rpmvercmp(a, b string) int {
+	for a != "" || b != "" {
+		a = strings.TrimLeftFunc(a, isRpmSeparator)
+		b = strings.TrimLeftFunc(b, isRpmSeparator)
+
+		// A tilde sorts before anything, including the end of the string.
+		if strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~") {
+			if !strings.HasPrefix(a, "~") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		if a == "" || b == "" {
+			break
+		}
+
+		var as, bs string
+		numeric := isDigit(a[0])
+		if numeric {
+			as, a = takeDigits(a)
+			bs, b = takeDigits(b)
+			if bs == "" {
+				// Numeric segments are newer than alphabetic ones.
+				return 1
+			}
+			if c := compareNumeric(as, bs); c != 0 {
+				return c
+			}
+			continue
+		}
+		as, a = takeLetters(a)
+		bs, b = takeLetters(b)
+		if bs == "" {
+			return -1
+		}
+		if c := strings.Compare(as, bs); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	default:
+		return 1
+	}
+}
+
+func isRpmSeparator(r rune) bool {
+	return !unicode.IsDigit(r) && !unicode.IsLetter(r) && r != '~'
+}
+
+// compareApk implements the Alpine package version ordering:
+// numbers[.numbers...][letter][_suffix[number]...][-rN].
+func compareApk(a, b string) int {
+	av, bv := parseApkVersion(a), parseApkVersion(b)
+	for i := 0; i < len(av.numbers) || i < len(bv.numbers); i++ {
+		if i >= len(av.numbers) {
+			return -1
+		}
+		if i >= len(bv.numbers) {
+			return 1
+		}
+		if c := compareNumeric(av.numbers[i], bv.numbers[i]); c != 0 {
+			return c
+		}
+	}
+	if av.letter != bv.letter {
+		return sign(int(av.letter) - int(bv.letter))
+	}
+	for i := 0; i < len(av.suffixes) || i < len(bv.suffixes); i++ {
+		as, bs := apkSuffix{rank: apkNoSuffix}, apkSuffix{rank: apkNoSuffix}
+		if i < len(av.suffixes) {
+			as = av.suffixes[i]
+		}
+		if i < len(bv.suffixes) {
+			bs = bv.suffixes[i]
+		}
+		if as.rank != bs.rank {
+			return sign(as.rank - bs.rank)
+		}
+		if c := compareNumeric(as.number, bs.number); c != 0 {
+			return c
+		}
+	}
+	return compareNumeric(av.revision, bv.revision)
+}
+
+const apkNoSuffix = 4
+
+var apkSuffixRanks = map[string]int{
+	"alpha": 0, "beta": 1, "pre": 2, "rc": 3,
+	"cvs": 5, "svn": 6, "git": 7, "hg": 8, "p": 9,
+}
+
+type apkSuffix struct {
+	rank   int
+	number string
+}
+
+type apkVersion struct {
+	numbers  []string
+	letter   byte
+	suffixes []apkSuffix
+	revision string
+}
+
+func parseApkVersion(v string) apkVersion {
+	var parsed apkVersion
+	if idx := strings.LastIndex(v, "-r"); idx >= 0 {
+		v, parsed.revision = v[:idx], v[idx+2:]
+	}
+	main, suffixes, _ := strings.Cut(v, "_")
+	for _, part := range strings.Split(main, ".") {
+		digits, rest := takeDigits(part)
+		parsed.numbers = append(parsed.numbers, digits)
+		if rest != "" {
+			parsed.letter = rest[0]
+		}
+	}
+	if suffixes != "" {
+		for _, suffix := range strings.Split(suffixes, "_") {
+			word, number := takeLetters(suffix)
+			rank, ok := apkSuffixRanks[word]
+			if !ok {
+				rank = apkNoSuffix
+			}
+			parsed.suffixes = append(parsed.suffixes, apkSuffix{rank: rank, number: number})
+		}
+	}
+	return parsed
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func takeDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func takeLetters(s string) (string, string) {
+	i := 0
+	for i < len(s) && unicode.IsLetter(rune(s[i])) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// compareNumeric compares two digit strings of arbitrary length.
+func compareNumeric(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		return sign(len(a) - len(b))
+	}
+	return strings.Compare(a, b)
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,116 @@
+package vuln
+
+import "testing"
+
+type versionCase struct {
+	a, b string
+	want int
+}
+
+// checkOrder runs compare on every case in both directions.
+func checkOrder(t *testing.T, compare compareFunc, cases []versionCase) {
+	t.Helper()
+	for _, tc := range cases {
+		if got := compare(tc.a, tc.b); got != tc.want {
+			t.Errorf("compare(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+		if got := compare(tc.b, tc.a); got != -tc.want {
+			t.Errorf("compare(%q, %q) = %d, want %d", tc.b, tc.a, got, -tc.want)
+		}
+	}
+}
+
+func TestCompareSemver(t *testing.T) {
+	checkOrder(t, compareSemver, []versionCase{
+		{"1.2.3", "1.2.3", 0},
+		{"1.2.3", "v1.2.3", 0},
+		{"1.2.3", "1.2.4", -1},
+		{"1.9.0", "1.10.0", -1},
+		{"2.0.0", "1.99.99", 1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0-alpha", "1.0.0-beta", -1},
+		{"go1.21.5", "go1.22.0", -1},
+		{"go1.22.1", "1.22.0", 1},
+	})
+}
+
+func TestCompareDpkg(t *testing.T) {
+	checkOrder(t, compareDpkg, []versionCase{
+		{"1.0", "1.0", 0},
+		{"0:1.0", "1.0", 0},
+		{"1.0", "1.1", -1},
+		{"1.2.10", "1.2.9", 1},
+		{"1.0", "1.0.0", -1},
+		{"00010", "10", 0},
+		// Epochs override the upstream version
+		{"1:1.0", "2.0", 1},
+		{"2:0.1", "1:9.9", 1},
+		// A tilde sorts before everything, even the end of the version
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~~", "1.0~", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.2.3-1~deb11u1", "1.2.3-1", -1},
+		// Letters sort before other symbols
+		{"1.0a", "1.0", 1},
+		{"1.0a", "1.0+", -1},
+		{"1.0+b1", "1.0", 1},
+		// Revisions
+		{"1.0-1", "1.0-2", -1},
+		{"1.0-1ubuntu1", "1.0-1", 1},
+		{"7.88.1-10+deb12u5", "7.88.1-10+deb12u6", -1},
+		{"2.36-9+deb12u4", "2.36-9+deb12u10", -1},
+		// Only the last hyphen starts the revision
+		{"1.0-2-1", "1.0-3", 1},
+	})
+}
+
+func TestCompareRpm(t *testing.T) {
+	checkOrder(t, compareRpm, []versionCase{
+		{"1.0", "1.0", 0},
+		{"0:1.0", "1.0", 0},
+		{"1.0", "1.1", -1},
+		{"1.10", "1.9", 1},
+		{"001", "1", 0},
+		{"1.0.1", "1.0", 1},
+		{"1:1.0", "2.0", 1},
+		// Numeric segments are newer than alphabetic ones
+		{"1.1", "1.a", 1},
+		{"1.0a", "1.0", 1},
+		{"a", "b", -1},
+		// Separators only delimit segments
+		{"1.0", "1_0", 0},
+		{"1..0", "1.0", 0},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		// Releases
+		{"1.0-1.el9", "1.0-2.el9", -1},
+		{"2.34-60.el9", "2.34-100.el9", -1},
+		{"3.0.7-24.el9", "3.0.7-24.el9_3", -1},
+		{"1.0-1.fc39", "1.0-1.fc40", -1},
+	})
+}
+
+func TestCompareApk(t *testing.T) {
+	checkOrder(t, compareApk, []versionCase{
+		{"1.2.3", "1.2.3", 0},
+		{"3.0.8-r0", "3.0.8-r0", 0},
+		{"1.2.3", "1.2.4", -1},
+		{"1.2.10", "1.2.9", 1},
+		{"1.2", "1.2.1", -1},
+		{"1.2a", "1.2", 1},
+		{"1.2a", "1.2b", -1},
+		// Revisions
+		{"1.2.3-r0", "1.2.3-r1", -1},
+		{"1.2.3-r9", "1.2.3-r10", -1},
+		{"1.2.3", "1.2.3-r1", -1},
+		// Pre-release suffixes sort before the release, patch suffixes after
+		{"1.2.3_alpha", "1.2.3_beta", -1},
+		{"1.2.3_pre2", "1.2.3_rc1", -1},
+		{"1.2.3_rc1", "1.2.3_rc2", -1},
+		{"1.2.3_rc1", "1.2.3", -1},
+		{"1.2.3_p1", "1.2.3", 1},
+		{"1.2.3_p1", "1.2.3_p2", -1},
+		{"1.2.3_git20240101", "1.2.3_p1", -1},
+		{"1.1.1w-r1", "1.1.1v-r3", 1},
+	})
+}